@@ -0,0 +1,344 @@
+package libphonelabgo
+
+import (
+	"fmt"
+	phonelab "github.com/shaseley/phonelab-go"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// app_jank.go uses the jank messages apps print themselves to tell app-side
+// stalls from compositor-side ones. The state machine only sees gaps between
+// SurfaceFlinger frames; if the app logged a slow frame or skipped frames at
+// the same time, the app was at fault. Otherwise it was the compositor (or
+// something else in the system).
+
+// Example:
+// ... 26367 26367 I OpenGLRenderer: Davey! duration=759ms; Flags=0, IntendedVsync=184541427062431, Vsync=184541427062431, OldestInputEvent=9223372036854775807, NewestInputEvent=0, HandleInputStart=184541428521171, AnimationStart=184541428525442, PerformTraversalsStart=184541428527734, DrawStart=184542134197838, SyncQueued=184542157412734, SyncStart=184542157660755, IssueDrawCommandsStart=184542157870703, SwapBuffers=184542183927838, FrameCompleted=184542186671796, DequeueBufferDuration=1484000, QueueBufferDuration=1250000,
+//
+// Timestamps are CLOCK_MONOTONIC nanoseconds, the same clock as the
+// SurfaceFlinger frame times.
+type OpenGLRendererDaveyLog struct {
+	DurationMs       int64
+	Flags            int64
+	IntendedVsyncNs  int64
+	VsyncNs          int64
+	FrameCompletedNs int64
+	// Everything else, by name
+	Fields map[string]int64
+}
+
+// Example:
+// ... 26367 26367 I Choreographer: Skipped 47 frames!  The application may be doing too much work on its main thread.
+type ChoreographerSkippedLog struct {
+	SkippedFrames int
+}
+
+var daveyDurationRe = regexp.MustCompile(`Davey! duration=(\d+)ms;`)
+var daveyFieldRe = regexp.MustCompile(`(\w+)=(-?\d+)`)
+var choreographerSkippedRe = regexp.MustCompile(`Skipped (\d+) frames!`)
+
+// OpenGLRendererParser parses logs with the OpenGLRenderer tag. Only the
+// "Davey!" slow frame messages are handled.
+type OpenGLRendererParser struct{}
+
+func NewOpenGLRendererParser() phonelab.Parser {
+	return &OpenGLRendererParser{}
+}
+
+func (parser *OpenGLRendererParser) Parse(payload string) (interface{}, error) {
+	m := daveyDurationRe.FindStringSubmatch(payload)
+	if m == nil {
+		// We can't parse it
+		return nil, nil
+	}
+
+	log := &OpenGLRendererDaveyLog{
+		Fields: make(map[string]int64),
+	}
+
+	var err error
+	if log.DurationMs, err = strconv.ParseInt(m[1], 10, 64); err != nil {
+		return nil, err
+	}
+
+	rest := payload[strings.Index(payload, m[0])+len(m[0]):]
+	for _, field := range daveyFieldRe.FindAllStringSubmatch(rest, -1) {
+		v, err := strconv.ParseInt(field[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Davey field %v: %v", field[1], err)
+		}
+		log.Fields[field[1]] = v
+	}
+
+	log.Flags = log.Fields["Flags"]
+	log.IntendedVsyncNs = log.Fields["IntendedVsync"]
+	log.VsyncNs = log.Fields["Vsync"]
+	log.FrameCompletedNs = log.Fields["FrameCompleted"]
+
+	return log, nil
+}
+
+// ChoreographerParser parses logs with the Choreographer tag. Only the
+// "Skipped N frames!" messages are handled.
+type ChoreographerParser struct{}
+
+func NewChoreographerParser() phonelab.Parser {
+	return &ChoreographerParser{}
+}
+
+func (parser *ChoreographerParser) Parse(payload string) (interface{}, error) {
+	m := choreographerSkippedRe.FindStringSubmatch(payload)
+	if m == nil {
+		return nil, nil
+	}
+
+	frames, err := strconv.Atoi(m[1])
+	if err != nil {
+		return nil, err
+	}
+	return &ChoreographerSkippedLog{SkippedFrames: frames}, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// AppJankEvent sources
+const (
+	AppJankSourceDavey         = "davey"
+	AppJankSourceChoreographer = "choreographer"
+)
+
+// AppJankEvent is a stall reported by an app, in sys time.
+type AppJankEvent struct {
+	Source        string  `json:"source"`
+	Pid           int     `json:"pid"`
+	StartNs       int64   `json:"start_ns"`
+	EndNs         int64   `json:"end_ns"`
+	SkippedFrames int     `json:"skipped_frames,omitempty"`
+	TraceTime     float64 `json:"tracetime"`
+}
+
+func (event *AppJankEvent) MonotonicTimestamp() float64 {
+	if GlobalConf.UseSysTime {
+		return float64(event.StartNs) / nsPerSecF
+	} else {
+		return event.TraceTime
+	}
+}
+
+func (event *AppJankEvent) DurationMs() int64 {
+	return (event.EndNs - event.StartNs) / nsPerMs
+}
+
+// AppJankEmitter converts Davey and Choreographer logs to AppJankEvents. It
+// needs the TimeSyncPreprocessor upstream to place Choreographer messages,
+// which have no timestamp of their own. Choreographer only gives a count of
+// skipped frames, so the vsync period is inferred from any SurfaceFlinger
+// frame times in the stream, unless Pacing has one set.
+type AppJankEmitter struct {
+	Source phonelab.Processor
+	// Only the vsync settings are used. Defaults to DefaultFramePacingParams.
+	Pacing *FramePacingParams
+}
+
+func (emitter *AppJankEmitter) Process() <-chan interface{} {
+	outChan := make(chan interface{})
+
+	go func() {
+		inChan := emitter.Source.Process()
+
+		// Add this to sys time to get trace time.
+		curOffsetNs := int64(0)
+
+		pacing := NewFramePacingAnalyzer(emitter.Pacing)
+		prevFrameNs := int64(0)
+
+		for iLog := range inChan {
+			// The timesync preprocessor sends offsets on their own
+			if msg, ok := iLog.(*TimeSyncMsg); ok {
+				curOffsetNs = msg.OffsetNs
+				continue
+			}
+
+			ll, ok := iLog.(*phonelab.Logline)
+			if !ok || ll == nil {
+				continue
+			}
+
+			switch t := ll.Payload.(type) {
+			case *TimeSyncMsg:
+				curOffsetNs = t.OffsetNs
+
+			case *SFFrameTimesLog:
+				for _, ts := range t.Times {
+					if prevFrameNs > 0 {
+						pacing.Observe(ts - prevFrameNs)
+					}
+					prevFrameNs = ts
+				}
+
+			case *OpenGLRendererDaveyLog:
+				event := &AppJankEvent{
+					Source:    AppJankSourceDavey,
+					Pid:       ll.Pid,
+					StartNs:   t.IntendedVsyncNs,
+					EndNs:     t.FrameCompletedNs,
+					TraceTime: ll.TraceTime,
+				}
+				if event.StartNs <= 0 {
+					event.StartNs = event.EndNs - t.DurationMs*nsPerMs
+				}
+				if event.EndNs <= 0 {
+					event.EndNs = event.StartNs + t.DurationMs*nsPerMs
+				}
+				if event.StartNs > 0 {
+					outChan <- event
+				}
+
+			case *ChoreographerSkippedLog:
+				// Logged when the late frame finally starts, so the stall
+				// was just before it.
+				endNs := int64(ll.TraceTime*nsPerSecF) - curOffsetNs
+				outChan <- &AppJankEvent{
+					Source:        AppJankSourceChoreographer,
+					Pid:           ll.Pid,
+					StartNs:       endNs - int64(t.SkippedFrames)*pacing.VsyncPeriodNs(),
+					EndNs:         endNs,
+					SkippedFrames: t.SkippedFrames,
+					TraceTime:     ll.TraceTime,
+				}
+			}
+		}
+		close(outChan)
+	}()
+
+	return outChan
+}
+
+type AppJankEmitterGenerator struct{}
+
+func (g *AppJankEmitterGenerator) GenerateProcessor(source *phonelab.PipelineSourceInstance,
+	kwargs map[string]interface{}) phonelab.Processor {
+
+	return &AppJankEmitter{
+		Source: source.Processor,
+		Pacing: NewFramePacingParams(kwargs),
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Which side a JankEvent was attributed to
+const (
+	JankSideApp        = "app"
+	JankSideCompositor = "compositor"
+)
+
+// How far apart an app stall and a frame time gap can be and still be
+// considered the same jank.
+const DefaultJankAttributionSlackMs = 50
+
+// JankAttributionStats counts how state machine jank was attributed.
+type JankAttributionStats struct {
+	AppJank        int `json:"app_jank"`
+	CompositorJank int `json:"compositor_jank"`
+	// App stalls that didn't overlap any measured jank, e.g. while waiting
+	// for input.
+	UnmatchedAppJank int `json:"unmatched_app_jank"`
+}
+
+// Attribute each JankEvent in the results to the app or the compositor. Sets
+// JankEvent.Side and returns the counts.
+func AttributeJank(results []*InputEventResult, appJank []*AppJankEvent, slackMs int64) *JankAttributionStats {
+	stats := &JankAttributionStats{}
+	slackNs := slackMs * nsPerMs
+	matched := make([]bool, len(appJank))
+
+	for _, res := range results {
+		for _, jank := range res.Jank {
+			endNs := jank.TimestampNs
+			startNs := endNs - jank.JankAmount*nsPerMs
+
+			jank.Side = JankSideCompositor
+			for i, app := range appJank {
+				if app.StartNs-slackNs <= endNs && app.EndNs+slackNs >= startNs {
+					jank.Side = JankSideApp
+					matched[i] = true
+				}
+			}
+
+			if jank.Side == JankSideApp {
+				stats.AppJank += 1
+			} else {
+				stats.CompositorJank += 1
+			}
+		}
+	}
+
+	for _, m := range matched {
+		if !m {
+			stats.UnmatchedAppJank += 1
+		}
+	}
+
+	return stats
+}
+
+// JankAttributionProcessor attributes the jank in InputEventResults using
+// AppJankEvents from the same source. Inputs can arrive in any order, so
+// results are held until the end, then sent with JankEvent.Side filled in,
+// followed by the JankAttributionStats. Anything else is passed through.
+type JankAttributionProcessor struct {
+	Source  phonelab.Processor
+	SlackMs int64
+}
+
+func (proc *JankAttributionProcessor) Process() <-chan interface{} {
+	outChan := make(chan interface{})
+
+	go func() {
+		inChan := proc.Source.Process()
+
+		results := make([]*InputEventResult, 0)
+		appJank := make([]*AppJankEvent, 0)
+
+		for iLog := range inChan {
+			if res, ok := iLog.(*InputEventResult); ok {
+				results = append(results, res)
+				continue
+			}
+			if event, ok := iLog.(*AppJankEvent); ok {
+				appJank = append(appJank, event)
+			}
+			outChan <- iLog
+		}
+
+		stats := AttributeJank(results, appJank, proc.SlackMs)
+		for _, res := range results {
+			outChan <- res
+		}
+		outChan <- stats
+
+		close(outChan)
+	}()
+
+	return outChan
+}
+
+type JankAttributionProcessorGenerator struct{}
+
+func (g *JankAttributionProcessorGenerator) GenerateProcessor(source *phonelab.PipelineSourceInstance,
+	kwargs map[string]interface{}) phonelab.Processor {
+
+	proc := &JankAttributionProcessor{
+		Source:  source.Processor,
+		SlackMs: DefaultJankAttributionSlackMs,
+	}
+
+	if v, ok := kwargs["slack_ms"]; ok {
+		proc.SlackMs = int64(v.(int))
+	}
+
+	return proc
+}
@@ -0,0 +1,158 @@
+package libphonelabgo
+
+import (
+	phonelab "github.com/shaseley/phonelab-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseDavey(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	payload := `Davey! duration=759ms; Flags=0, IntendedVsync=184541427062431, Vsync=184541427062431, OldestInputEvent=9223372036854775807, NewestInputEvent=0, HandleInputStart=184541428521171, AnimationStart=184541428525442, PerformTraversalsStart=184541428527734, DrawStart=184542134197838, SyncQueued=184542157412734, SyncStart=184542157660755, IssueDrawCommandsStart=184542157870703, SwapBuffers=184542183927838, FrameCompleted=184542186671796, DequeueBufferDuration=1484000, QueueBufferDuration=1250000,`
+
+	parser := NewOpenGLRendererParser()
+	log, err := parser.Parse(payload)
+	require.Nil(err)
+	davey, ok := log.(*OpenGLRendererDaveyLog)
+	require.True(ok)
+
+	assert.Equal(int64(759), davey.DurationMs)
+	assert.Equal(int64(184541427062431), davey.IntendedVsyncNs)
+	assert.Equal(int64(184542186671796), davey.FrameCompletedNs)
+	assert.Equal(int64(1484000), davey.Fields["DequeueBufferDuration"])
+	assert.Equal(16, len(davey.Fields))
+
+	// Other OpenGLRenderer logs are ignored
+	log, err = parser.Parse("Initialized EGL, version 1.4")
+	assert.Nil(err)
+	assert.Nil(log)
+}
+
+func TestParseChoreographer(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	parser := NewChoreographerParser()
+	log, err := parser.Parse("Skipped 47 frames!  The application may be doing too much work on its main thread.")
+	assert.Nil(err)
+	assert.Equal(&ChoreographerSkippedLog{SkippedFrames: 47}, log)
+
+	log, err = parser.Parse("Frame time is 0.1 ms in the future!")
+	assert.Nil(err)
+	assert.Nil(log)
+}
+
+func TestAppJankEmitter(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	source := &sliceSource{[]interface{}{
+		&TimeSyncMsg{OffsetNs: 500 * nsPerMs},
+		&phonelab.Logline{
+			Pid:       100,
+			TraceTime: 10.0,
+			Payload:   &ChoreographerSkippedLog{SkippedFrames: 6},
+		},
+		&phonelab.Logline{
+			Pid:       200,
+			TraceTime: 20.0,
+			Payload: &OpenGLRendererDaveyLog{
+				DurationMs:       800,
+				IntendedVsyncNs:  19000 * nsPerMs,
+				FrameCompletedNs: 19800 * nsPerMs,
+			},
+		},
+	}}
+
+	res := collectAll(&AppJankEmitter{Source: source})
+	require.Equal(2, len(res))
+
+	choreo := res[0].(*AppJankEvent)
+	assert.Equal(AppJankSourceChoreographer, choreo.Source)
+	assert.Equal(100, choreo.Pid)
+	assert.Equal(int64(9500*nsPerMs), choreo.EndNs)
+	assert.Equal(int64(100), choreo.DurationMs())
+
+	davey := res[1].(*AppJankEvent)
+	assert.Equal(AppJankSourceDavey, davey.Source)
+	assert.Equal(int64(19000*nsPerMs), davey.StartNs)
+	assert.Equal(int64(800), davey.DurationMs())
+}
+
+func TestAppJankEmitterVsync(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// 120Hz frames, then a stall of 6 frames
+	times := make([]int64, 0)
+	for i := int64(1); i <= 30; i++ {
+		times = append(times, i*8333333)
+	}
+	logs := func() []interface{} {
+		return []interface{}{
+			&phonelab.Logline{Payload: &SFFrameTimesLog{Token: 1, Times: times}},
+			&phonelab.Logline{
+				TraceTime: 10.0,
+				Payload:   &ChoreographerSkippedLog{SkippedFrames: 6},
+			},
+		}
+	}
+
+	res := collectAll(&AppJankEmitter{Source: &sliceSource{logs()}})
+	require.Equal(1, len(res))
+	event := res[0].(*AppJankEvent)
+	assert.InDelta(50.0, float64(event.EndNs-event.StartNs)/nsPerMsF, 0.1)
+
+	// A configured period wins
+	res = collectAll((&AppJankEmitterGenerator{}).GenerateProcessor(
+		&phonelab.PipelineSourceInstance{Processor: &sliceSource{logs()}},
+		map[string]interface{}{"vsync_period_ms": 20}))
+	require.Equal(1, len(res))
+	assert.Equal(int64(120), res[0].(*AppJankEvent).DurationMs())
+}
+
+func TestJankAttribution(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	res := NewInputEventResult(&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 1000 * nsPerMs})
+	res.Jank = append(res.Jank,
+		// The app stalled
+		&JankEvent{TimestampNs: 1500 * nsPerMs, JankAmount: 200},
+		// Nothing from the app
+		&JankEvent{TimestampNs: 3000 * nsPerMs, JankAmount: 100},
+	)
+
+	source := &sliceSource{[]interface{}{
+		res,
+		&AppJankEvent{Source: AppJankSourceDavey, StartNs: 1320 * nsPerMs, EndNs: 1480 * nsPerMs},
+		// While waiting for input
+		&AppJankEvent{Source: AppJankSourceChoreographer, StartNs: 9000 * nsPerMs, EndNs: 9100 * nsPerMs},
+	}}
+
+	out := collectAll(&JankAttributionProcessor{
+		Source:  source,
+		SlackMs: DefaultJankAttributionSlackMs,
+	})
+	require.Equal(4, len(out))
+
+	// App jank is passed through, results are held until the end
+	assert.IsType(&AppJankEvent{}, out[0])
+	assert.IsType(&AppJankEvent{}, out[1])
+	assert.Equal(res, out[2])
+	assert.Equal(JankSideApp, res.Jank[0].Side)
+	assert.Equal(JankSideCompositor, res.Jank[1].Side)
+
+	assert.Equal(&JankAttributionStats{
+		AppJank:          1,
+		CompositorJank:   1,
+		UnmatchedAppJank: 1,
+	}, out[3])
+}
@@ -0,0 +1,135 @@
+package libphonelabgo
+
+import (
+	phonelab "github.com/shaseley/phonelab-go"
+	"sort"
+)
+
+// apps.go tracks which app is in the foreground, based on the activity
+// lifecycle logs. Results can then be broken down per app.
+
+const (
+	ActivityActionResume = "onResume"
+	ActivityActionPause  = "onPause"
+)
+
+// Used when results are grouped by app, but the app isn't known.
+const UnknownApp = "(unknown)"
+
+// AppChangeEvent is emitted when a different app comes to the foreground.
+type AppChangeEvent struct {
+	TimestampNs int64   `json:"timestamp_ns"`
+	TraceTime   float64 `json:"tracetime"`
+	App         string  `json:"app"`
+	Activity    string  `json:"activity"`
+	PrevApp     string  `json:"prev_app"`
+}
+
+func (event *AppChangeEvent) MonotonicTimestamp() float64 {
+	if GlobalConf.UseSysTime {
+		return float64(event.TimestampNs) / nsPerSecF
+	} else {
+		return event.TraceTime
+	}
+}
+
+// ForegroundAppTracker follows activity lifecycle logs and reports when the
+// foreground app changes. Switching activities within the same app is not a
+// change.
+type ForegroundAppTracker struct {
+	CurApp      string
+	CurActivity string
+}
+
+func NewForegroundAppTracker() *ForegroundAppTracker {
+	return &ForegroundAppTracker{}
+}
+
+func (tracker *ForegroundAppTracker) OnActivityLog(log *ActivityLifeCycleLog, traceTime float64) *AppChangeEvent {
+	if log.Action != ActivityActionResume {
+		return nil
+	}
+
+	tracker.CurActivity = log.ActivityName
+
+	if log.AppName == tracker.CurApp {
+		return nil
+	}
+
+	event := &AppChangeEvent{
+		TimestampNs: log.UpTimeMs * nsPerMs,
+		TraceTime:   traceTime,
+		App:         log.AppName,
+		Activity:    log.ActivityName,
+		PrevApp:     tracker.CurApp,
+	}
+	tracker.CurApp = log.AppName
+
+	return event
+}
+
+// AppTimeline answers which app was in the foreground at a given time.
+type AppTimeline struct {
+	changes []*AppChangeEvent
+}
+
+func NewAppTimeline(changes []*AppChangeEvent) *AppTimeline {
+	sorted := make([]*AppChangeEvent, len(changes))
+	copy(sorted, changes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].TimestampNs < sorted[j].TimestampNs
+	})
+	return &AppTimeline{sorted}
+}
+
+// The foreground app at timestampNs, or "" if unknown.
+func (timeline *AppTimeline) AppAt(timestampNs int64) string {
+	i := sort.Search(len(timeline.changes), func(i int) bool {
+		return timeline.changes[i].TimestampNs > timestampNs
+	})
+	if i == 0 {
+		return ""
+	}
+	return timeline.changes[i-1].App
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ForegroundAppProcessor emits an AppChangeEvent every time the foreground app
+// changes.
+type ForegroundAppProcessor struct {
+	Source phonelab.Processor
+}
+
+func (proc *ForegroundAppProcessor) Process() <-chan interface{} {
+
+	outChan := make(chan interface{})
+
+	go func() {
+		inChan := proc.Source.Process()
+		tracker := NewForegroundAppTracker()
+
+		for iLog := range inChan {
+			if ll, ok := iLog.(*phonelab.Logline); ok {
+				if log, ok := ll.Payload.(*ActivityLifeCycleLog); ok {
+					if event := tracker.OnActivityLog(log, ll.TraceTime); event != nil {
+						outChan <- event
+					}
+				}
+			}
+		}
+		close(outChan)
+	}()
+
+	return outChan
+}
+
+type ForegroundAppProcessorGenerator struct{}
+
+func (g *ForegroundAppProcessorGenerator) GenerateProcessor(source *phonelab.PipelineSourceInstance,
+	kwargs map[string]interface{}) phonelab.Processor {
+
+	return &ForegroundAppProcessor{
+		Source: source.Processor,
+	}
+}
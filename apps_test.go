@@ -0,0 +1,103 @@
+package libphonelabgo
+
+import (
+	phonelab "github.com/shaseley/phonelab-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestActivityLifeCycleParser(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	parser := NewActivityLifeCycleParser()
+
+	payload := `{"Action":"onResume","AppName":"com.google.android.googlequicksearchbox","Pid":19114,"Uid":10035,"Tid":19114,"ParentActivity":"NULL","ActivityName":"com.google.android.googlequicksearchbox\/com.google.android.launcher.GEL","Time":1480665808277,"UpTime":184539039,"SessionID":"9601477b-416f-4616-bd50-7cacb6c0b90c","timestamp":1480665808277,"uptimeNanos":209160013425990,"LogFormat":"1.1"}`
+
+	expected := &ActivityLifeCycleLog{
+		PLLog: phonelab.PLLog{
+			LogFormat:   "1.1",
+			UptimeNanos: 209160013425990,
+			Timestamp:   1480665808277,
+		},
+		Action:         "onResume",
+		AppName:        "com.google.android.googlequicksearchbox",
+		Pid:            19114,
+		Uid:            10035,
+		Tid:            19114,
+		ParentActivity: "NULL",
+		ActivityName:   "com.google.android.googlequicksearchbox/com.google.android.launcher.GEL",
+		UpTimeMs:       184539039,
+		SessionId:      "9601477b-416f-4616-bd50-7cacb6c0b90c",
+	}
+
+	res, err := parser.Parse(payload)
+	require.Nil(err)
+
+	assert.Equal(expected, res)
+}
+
+func TestForegroundAppTracker(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	tracker := NewForegroundAppTracker()
+
+	log := func(action, app, activity string, upTimeMs int64) *ActivityLifeCycleLog {
+		return &ActivityLifeCycleLog{
+			Action:       action,
+			AppName:      app,
+			ActivityName: app + "/" + activity,
+			UpTimeMs:     upTimeMs,
+		}
+	}
+
+	event := tracker.OnActivityLog(log("onStart", "a", "Main", 100), 0.1)
+	assert.Nil(event)
+
+	event = tracker.OnActivityLog(log("onResume", "a", "Main", 101), 0.101)
+	assert.Equal(&AppChangeEvent{
+		TimestampNs: 101 * nsPerMs,
+		TraceTime:   0.101,
+		App:         "a",
+		Activity:    "a/Main",
+	}, event)
+
+	// Same app, different activity
+	assert.Nil(tracker.OnActivityLog(log("onPause", "a", "Main", 200), 0.2))
+	assert.Nil(tracker.OnActivityLog(log("onResume", "a", "Settings", 201), 0.201))
+	assert.Equal("a/Settings", tracker.CurActivity)
+
+	event = tracker.OnActivityLog(log("onResume", "b", "Main", 300), 0.3)
+	assert.NotNil(event)
+	assert.Equal("b", event.App)
+	assert.Equal("a", event.PrevApp)
+
+	timeline := NewAppTimeline([]*AppChangeEvent{
+		&AppChangeEvent{TimestampNs: 300, App: "b"},
+		&AppChangeEvent{TimestampNs: 100, App: "a"},
+	})
+
+	assert.Equal("", timeline.AppAt(50))
+	assert.Equal("a", timeline.AppAt(100))
+	assert.Equal("a", timeline.AppAt(299))
+	assert.Equal("b", timeline.AppAt(1000))
+}
+
+func TestInputStateMachineApp(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ism := NewInputStateMachine()
+	ism.OnAppChange(&AppChangeEvent{App: "a"})
+
+	ism.OnTouchEvent(&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 1000})
+	ism.OnAppChange(&AppChangeEvent{App: "b"})
+
+	// The measurement keeps the app it started in
+	res := ism.Finish(2000)
+	assert.NotNil(res)
+	assert.Equal("a", res.App)
+}
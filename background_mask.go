@@ -0,0 +1,294 @@
+package libphonelabgo
+
+import (
+	phonelab "github.com/shaseley/phonelab-go"
+	"sort"
+)
+
+// background_mask.go learns which grid cells change on their own (blinking
+// cursors, the status bar clock, ad carousels) by watching frame diffs while
+// the user is idle, i.e. there's been no input for IdleMs. Those cells are
+// masked out before a diff is classified as a local or global response, so
+// background animation doesn't turn a local response into a global one.
+
+// BackgroundMask is the set of grid cells that change while idle, for one app
+// or a whole session.
+type BackgroundMask struct {
+	App string `json:"app,omitempty"`
+	// Idle diffs seen
+	Frames int `json:"frames"`
+	// Idle diffs each grid position changed in
+	Counts map[int]int `json:"counts"`
+	// Masked grid positions
+	Masked []int `json:"masked"`
+
+	params *BackgroundMaskParams
+}
+
+type BackgroundMaskParams struct {
+	// Keep a separate mask for each foreground app
+	PerApp bool
+	// Fraction of idle diffs a cell must change in to be masked
+	Threshold float64
+	// Idle diffs needed before anything is masked
+	MinFrames int
+	// How long after an input diffs are idle again. Long enough for the
+	// response to the input to have finished animating.
+	IdleMs int64
+}
+
+// Diffs within this long after an input aren't idle.
+const DefaultBackgroundIdleMs = 3000
+
+func DefaultBackgroundMaskParams() *BackgroundMaskParams {
+	return &BackgroundMaskParams{
+		PerApp:    false,
+		Threshold: 0.5,
+		MinFrames: 20,
+		IdleMs:    DefaultBackgroundIdleMs,
+	}
+}
+
+func NewBackgroundMaskParams(kwargs map[string]interface{}) *BackgroundMaskParams {
+	params := DefaultBackgroundMaskParams()
+
+	if v, ok := kwargs["background_mask_per_app"]; ok {
+		params.PerApp = v.(bool)
+	}
+
+	if v, ok := kwargs["background_mask_threshold"]; ok {
+		switch t := v.(type) {
+		case int:
+			params.Threshold = float64(t)
+		case float64:
+			params.Threshold = t
+		}
+	}
+
+	if v, ok := kwargs["background_mask_min_frames"]; ok {
+		params.MinFrames = v.(int)
+	}
+
+	if v, ok := kwargs["background_mask_idle_ms"]; ok {
+		params.IdleMs = int64(v.(int))
+	}
+
+	return params
+}
+
+func newBackgroundMask(app string, params *BackgroundMaskParams) *BackgroundMask {
+	return &BackgroundMask{
+		App:    app,
+		Counts: make(map[int]int),
+		Masked: make([]int, 0),
+		params: params,
+	}
+}
+
+func (mask *BackgroundMask) IsMasked(pos int) bool {
+	if mask.Frames < mask.params.MinFrames || mask.Frames == 0 {
+		return false
+	}
+	return float64(mask.Counts[pos])/float64(mask.Frames) >= mask.params.Threshold
+}
+
+func (mask *BackgroundMask) onIdleDiff(diff *SFFrameDiff) {
+	mask.Frames += 1
+	for _, entry := range diff.GridEntries {
+		if entry.Value > 0.0 {
+			mask.Counts[entry.Position] += 1
+		}
+	}
+}
+
+// Update Masked from the counts.
+func (mask *BackgroundMask) finish() {
+	mask.Masked = make([]int, 0)
+	for pos := range mask.Counts {
+		if mask.IsMasked(pos) {
+			mask.Masked = append(mask.Masked, pos)
+		}
+	}
+	sort.Ints(mask.Masked)
+}
+
+// Share of the whole screen covered by a grid position.
+func gridPosArea(props *screenGridProps, pos int) float64 {
+	row, col := props.entryPosToGridPos(pos)
+	if row < 0 || col < 0 {
+		return 0.0
+	}
+	x0, y0, x1, y1 := props.cellRect(row, col)
+	return (x1 - x0) * (y1 - y0) / float64(props.screenW*props.screenH)
+}
+
+// Apply the mask to a diff. Returns the diff itself if nothing is masked,
+// otherwise a copy without the masked cells.
+func (mask *BackgroundMask) Apply(diff *FrameDiffSample) *FrameDiffSample {
+	if mask == nil || len(diff.GridEntries) == 0 {
+		return diff
+	}
+
+	props := allScreenGrids[0]
+	entries := make([]*GridEntry, 0, len(diff.GridEntries))
+	pct := diff.PctDiff
+
+	for _, entry := range diff.GridEntries {
+		if mask.IsMasked(entry.Position) {
+			pct -= entry.Value * gridPosArea(props, entry.Position)
+		} else {
+			entries = append(entries, entry)
+		}
+	}
+
+	if len(entries) == len(diff.GridEntries) {
+		return diff
+	}
+
+	if len(entries) == 0 || pct < 0.0 {
+		pct = 0.0
+	}
+
+	masked := *diff
+	masked.GridEntries = entries
+	masked.PctDiff = pct
+	(&masked.SFFrameDiff).initScreenGrid(props)
+
+	return &masked
+}
+
+// BackgroundMaskLearner keeps the masks for a session. Everything that masks
+// background uses it to decide what's idle, so they all agree.
+type BackgroundMaskLearner struct {
+	Params *BackgroundMaskParams
+
+	curApp      string
+	masks       map[string]*BackgroundMask
+	lastInputNs int64
+}
+
+func NewBackgroundMaskLearner(params *BackgroundMaskParams) *BackgroundMaskLearner {
+	if params == nil {
+		params = DefaultBackgroundMaskParams()
+	}
+	return &BackgroundMaskLearner{
+		Params:      params,
+		masks:       make(map[string]*BackgroundMask),
+		lastInputNs: InvalidResponseTime,
+	}
+}
+
+func (l *BackgroundMaskLearner) OnAppChange(event *AppChangeEvent) {
+	l.curApp = event.App
+}
+
+// The mask for the current app, or the session mask. Created if necessary.
+func (l *BackgroundMaskLearner) Mask() *BackgroundMask {
+	app := ""
+	if l.Params.PerApp {
+		app = l.curApp
+		if len(app) == 0 {
+			app = UnknownApp
+		}
+	}
+
+	mask, ok := l.masks[app]
+	if !ok {
+		mask = newBackgroundMask(app, l.Params)
+		l.masks[app] = mask
+	}
+	return mask
+}
+
+// Called for every input, whether or not it is measured.
+func (l *BackgroundMaskLearner) OnInput(event *TouchScreenEvent) {
+	l.lastInputNs = event.Timestamp
+}
+
+// Whether the user is idle at ts: no input yet, or none for IdleMs.
+func (l *BackgroundMaskLearner) IsIdle(ts int64) bool {
+	return l.lastInputNs == InvalidResponseTime || ts-l.lastInputNs > l.Params.IdleMs*nsPerMs
+}
+
+// Called for every diff. Learns from it if the user is idle.
+func (l *BackgroundMaskLearner) OnFrameDiff(diff *FrameDiffSample) {
+	if l.IsIdle(diff.TimestampNs()) {
+		l.OnIdleDiff(diff)
+	}
+}
+
+// Learn from a diff known to be idle.
+func (l *BackgroundMaskLearner) OnIdleDiff(diff *FrameDiffSample) {
+	l.Mask().onIdleDiff(&diff.SFFrameDiff)
+}
+
+// Apply the current mask to a diff.
+func (l *BackgroundMaskLearner) Apply(diff *FrameDiffSample) *FrameDiffSample {
+	return l.Mask().Apply(diff)
+}
+
+// All masks, sorted by app.
+func (l *BackgroundMaskLearner) Masks() []*BackgroundMask {
+	apps := make([]string, 0, len(l.masks))
+	for app := range l.masks {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+
+	res := make([]*BackgroundMask, 0, len(apps))
+	for _, app := range apps {
+		mask := l.masks[app]
+		mask.finish()
+		res = append(res, mask)
+	}
+	return res
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// BackgroundMaskProcessor learns masks from a stream of TouchScreenEvents,
+// FrameDiffSamples and (optionally) AppChangeEvents, and sends them at the
+// end. It doesn't use the masks; it's for looking at what the state machine
+// would mask with the same parameters.
+type BackgroundMaskProcessor struct {
+	Source phonelab.Processor
+	Params *BackgroundMaskParams
+}
+
+func (proc *BackgroundMaskProcessor) Process() <-chan interface{} {
+	outChan := make(chan interface{})
+
+	go func() {
+		inChan := proc.Source.Process()
+		learner := NewBackgroundMaskLearner(proc.Params)
+
+		for iLog := range inChan {
+			switch t := iLog.(type) {
+			case *TouchScreenEvent:
+				learner.OnInput(t)
+			case *AppChangeEvent:
+				learner.OnAppChange(t)
+			case *FrameDiffSample:
+				learner.OnFrameDiff(t)
+			}
+		}
+
+		for _, mask := range learner.Masks() {
+			outChan <- mask
+		}
+		close(outChan)
+	}()
+
+	return outChan
+}
+
+type BackgroundMaskProcessorGenerator struct{}
+
+func (g *BackgroundMaskProcessorGenerator) GenerateProcessor(source *phonelab.PipelineSourceInstance,
+	kwargs map[string]interface{}) phonelab.Processor {
+
+	return &BackgroundMaskProcessor{
+		Source: source.Processor,
+		Params: NewBackgroundMaskParams(kwargs),
+	}
+}
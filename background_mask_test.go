@@ -0,0 +1,198 @@
+package libphonelabgo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// A blinking cursor near the upper right corner while idle, then a tap in the
+// lower left corner that changes both.
+func backgroundMaskTestLogs() []interface{} {
+	logs := make([]interface{}, 0)
+	for ts := int64(0); ts < 1000; ts += 100 {
+		logs = append(logs, heatmapTestDiff(ts, &GridEntry{59, 20.0}))
+	}
+	logs = append(logs,
+		&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 1000 * nsPerMs, X: 100, Y: 2500},
+		heatmapTestDiff(1100, &GridEntry{0, 100.0}, &GridEntry{59, 100.0}),
+	)
+	return logs
+}
+
+func TestBackgroundMask(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	params := DefaultBackgroundMaskParams()
+	params.MinFrames = 5
+	learner := NewBackgroundMaskLearner(params)
+
+	// Not enough frames yet
+	learner.OnIdleDiff(heatmapTestDiff(0, &GridEntry{59, 20.0}, &GridEntry{12, 20.0}))
+	assert.False(learner.Mask().IsMasked(59))
+
+	for ts := int64(100); ts < 1000; ts += 100 {
+		learner.OnIdleDiff(heatmapTestDiff(ts, &GridEntry{59, 20.0}))
+	}
+	assert.True(learner.Mask().IsMasked(59))
+	assert.False(learner.Mask().IsMasked(12))
+
+	diff := heatmapTestDiff(2000, &GridEntry{59, 50.0}, &GridEntry{12, 100.0})
+	masked := learner.Apply(diff)
+	assert.Equal([]*GridEntry{&GridEntry{12, 100.0}}, masked.GridEntries)
+	assert.InDelta(100.0/36.0, masked.PctDiff, 0.0001)
+	// The original is untouched
+	assert.Equal(2, len(diff.GridEntries))
+
+	// Only background
+	masked = learner.Apply(heatmapTestDiff(2100, &GridEntry{59, 50.0}))
+	assert.Equal(0.0, masked.PctDiff)
+
+	// Nothing masked, same diff back
+	diff = heatmapTestDiff(2200, &GridEntry{12, 50.0})
+	assert.True(diff == learner.Apply(diff))
+
+	masks := learner.Masks()
+	require.Equal(1, len(masks))
+	assert.Equal([]int{59}, masks[0].Masked)
+	assert.Equal(10, masks[0].Frames)
+
+	// The last column is half width
+	assert.InDelta(1.0/72.0, gridPosArea(allScreenGrids[0], 4), 0.0001)
+}
+
+func TestBackgroundMaskPerApp(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	params := DefaultBackgroundMaskParams()
+	params.PerApp = true
+	params.MinFrames = 1
+	learner := NewBackgroundMaskLearner(params)
+
+	learner.OnIdleDiff(heatmapTestDiff(0, &GridEntry{59, 20.0}))
+	learner.OnAppChange(&AppChangeEvent{App: "com.example"})
+	learner.OnIdleDiff(heatmapTestDiff(100, &GridEntry{12, 20.0}))
+
+	masks := learner.Masks()
+	require.Equal(2, len(masks))
+	assert.Equal(UnknownApp, masks[0].App)
+	assert.Equal([]int{59}, masks[0].Masked)
+	assert.Equal("com.example", masks[1].App)
+	assert.Equal([]int{12}, masks[1].Masked)
+}
+
+func TestInputStateMachineBackgroundMask(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Without the mask, the cursor makes the response neither local nor
+	// global.
+	res := collectAll(&InputStateMachineProcessor{
+		Source: &sliceSource{backgroundMaskTestLogs()},
+	})
+	require.Equal(1, len(res))
+	assert.False(res[0].(*InputEventResult).HasResponse())
+
+	res = collectAll(&InputStateMachineProcessor{
+		Source: &sliceSource{backgroundMaskTestLogs()},
+		Args: map[string]interface{}{
+			"mask_background":            true,
+			"background_mask_min_frames": 5,
+		},
+	})
+	require.Equal(2, len(res))
+
+	result := res[0].(*InputEventResult)
+	assert.True(result.HasLocalResponse())
+	assert.Equal(int64(100), result.TouchResponseMs())
+
+	mask := res[1].(*BackgroundMask)
+	assert.Equal([]int{59}, mask.Masked)
+}
+
+func TestInputDiffBackgroundMask(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	args := NewInputDiffProcessorArgs(map[string]interface{}{
+		"do_taps":                    true,
+		"mask_background":            true,
+		"background_mask_min_frames": 5,
+	})
+
+	res := collectAll(&InputDiffProcessor{
+		Source: &sliceSource{backgroundMaskTestLogs()},
+		Args:   args,
+	})
+	require.Equal(2, len(res))
+
+	event := res[0].(*InputDiffEvent)
+	require.Equal(1, len(event.Diffs))
+	assert.Equal(1, event.Diffs[0].NumMasked)
+	assert.Equal(1, event.Diffs[0].NumChanges)
+	assert.InDelta(100.0/36.0, event.Diffs[0].GlobalDiff, 0.0001)
+
+	assert.IsType(&BackgroundMask{}, res[1])
+}
+
+func TestBackgroundMaskSharedIdleRule(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// The response keeps animating after the state machine times out. It's
+	// within idle_ms of the tap, so it shouldn't be learned as background.
+	logs := backgroundMaskTestLogs()
+	for ts := int64(1600); ts < 3000; ts += 100 {
+		logs = append(logs, heatmapTestDiff(ts, &GridEntry{0, 20.0}))
+	}
+
+	args := map[string]interface{}{
+		"mask_background":            true,
+		"background_mask_min_frames": 5,
+		"do_taps":                    true,
+		"ui_timeout_ms":              500,
+	}
+
+	getMask := func(res []interface{}) *BackgroundMask {
+		for _, r := range res {
+			if mask, ok := r.(*BackgroundMask); ok {
+				return mask
+			}
+		}
+		require.FailNow("no mask")
+		return nil
+	}
+
+	ismMask := getMask(collectAll(&InputStateMachineProcessor{
+		Source: &sliceSource{logs},
+		Args:   args,
+	}))
+	diffMask := getMask(collectAll(&InputDiffProcessor{
+		Source: &sliceSource{logs},
+		Args:   NewInputDiffProcessorArgs(args),
+	}))
+	procMask := getMask(collectAll(&BackgroundMaskProcessor{
+		Source: &sliceSource{logs},
+		Params: NewBackgroundMaskParams(args),
+	}))
+
+	assert.Equal(10, ismMask.Frames)
+	assert.Equal([]int{59}, ismMask.Masked)
+	assert.Equal(ismMask.Counts, diffMask.Counts)
+	assert.Equal(ismMask.Counts, procMask.Counts)
+
+	// Once idle_ms has passed, it's background again
+	logs = append(logs, heatmapTestDiff(4100, &GridEntry{0, 20.0}))
+	procMask = getMask(collectAll(&BackgroundMaskProcessor{
+		Source: &sliceSource{logs},
+		Params: NewBackgroundMaskParams(args),
+	}))
+	assert.Equal(11, procMask.Frames)
+}
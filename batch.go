@@ -0,0 +1,490 @@
+package libphonelabgo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	phonelab "github.com/shaseley/phonelab-go"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+)
+
+// batch.go runs the same pipeline over many log files in parallel. The
+// pipeline is given as a YAML template, executed once per file with:
+//
+//   {{.File}}  the log file
+//   {{.Name}}  a file name safe version of the log file path
+//
+// The template must send its results to the "batch" data collector, e.g.
+//
+//   data_collector: {name: batch}
+//   source:
+//     type: files
+//     sources: ["{{.File}}"]
+//
+// Each file gets its own environment, and its results are written to
+// <OutputDir>/<Name>-<hash>.json, where the hash is of the full path. Files
+// whose output already exists are skipped when resuming, and their saved
+// results are merged with the new ones. By default each file runs in its own
+// process, so the program must call RunBatchChild (see Subprocess).
+
+const BatchCollectorName = "batch"
+
+const batchMergedFileName = "merged.json"
+
+// BatchFileOutput holds everything the pipeline sent for one file.
+type BatchFileOutput struct {
+	File     string                    `json:"file"`
+	Spinners []*SpinnerCollectorOutput `json:"spinners,omitempty"`
+	ISMStats *InputEventStats          `json:"ism_stats,omitempty"`
+	Data     []interface{}             `json:"data,omitempty"`
+	// Anything that couldn't be added
+	Errors []string `json:"errors,omitempty"`
+}
+
+func (out *BatchFileOutput) add(data interface{}) {
+	switch t := data.(type) {
+	case *SpinnerCollectorOutput:
+		if len(t.File) == 0 {
+			t.File = out.File
+		}
+		out.Spinners = append(out.Spinners, t)
+	case *InputEventStats:
+		if len(t.Source) == 0 {
+			t.Source = out.File
+		}
+		if out.ISMStats == nil {
+			out.ISMStats = t
+		} else if err := out.ISMStats.Merge(t); err != nil {
+			out.Errors = append(out.Errors, fmt.Sprintf("Cannot merge ISM stats: %v", err))
+		}
+	default:
+		out.Data = append(out.Data, t)
+	}
+}
+
+// batchCollector is registered as the "batch" data collector for each file.
+type batchCollector struct {
+	out *BatchFileOutput
+	sync.Mutex
+}
+
+func (c *batchCollector) OnData(data interface{}, info phonelab.PipelineSourceInfo) {
+	c.Lock()
+	defer c.Unlock()
+	c.out.add(data)
+}
+
+func (c *batchCollector) Finish() {}
+
+// BatchFailure records why a file could not be processed.
+type BatchFailure struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// BatchResult is the combined dataset for a batch.
+type BatchResult struct {
+	Files     int             `json:"files"`
+	Processed int             `json:"processed"`
+	Resumed   int             `json:"resumed"`
+	Failures  []*BatchFailure `json:"failures"`
+
+	Spinners []*SpinnerCollectorOutput `json:"spinners"`
+	ISMStats *InputEventStats          `json:"ism_stats,omitempty"`
+	// Everything else, in file order
+	Data []interface{} `json:"data,omitempty"`
+}
+
+func (res *BatchResult) merge(out *BatchFileOutput) {
+	res.Spinners = append(res.Spinners, out.Spinners...)
+	res.Data = append(res.Data, out.Data...)
+
+	for _, msg := range out.Errors {
+		res.Failures = append(res.Failures, &BatchFailure{
+			File:  out.File,
+			Error: msg,
+		})
+	}
+
+	if out.ISMStats != nil {
+		if res.ISMStats == nil {
+			res.ISMStats = NewInputEventStats(out.ISMStats.Accuracy)
+			res.ISMStats.Files = 0
+		}
+		if err := res.ISMStats.Merge(out.ISMStats); err != nil {
+			res.Failures = append(res.Failures, &BatchFailure{
+				File:  out.File,
+				Error: fmt.Sprintf("Cannot merge ISM stats: %v", err),
+			})
+		}
+	}
+}
+
+// BatchRunner runs a pipeline template over many files with a bounded pool
+// of workers. A failure in one file doesn't affect the others.
+type BatchRunner struct {
+	Template  string
+	Files     []string
+	OutputDir string
+	Workers   int
+	Resume    bool
+
+	// Run each file in a subprocess, so a panic anywhere in its pipeline,
+	// including in processor goroutines, only fails that file. On by
+	// default. The program must call RunBatchChild at the start of main, or
+	// Run fails. Turning it off runs files in this process, where only
+	// panics in the goroutine running the pipeline are caught and any other
+	// panic takes down the whole batch.
+	Subprocess bool
+	// Arguments for the subprocess, which is this program again
+	SubprocessArgs []string
+
+	// Creates the environment for each file. The "batch" data collector is
+	// added after. Defaults to an environment with everything in this
+	// library registered.
+	NewEnvironment func() *phonelab.Environment
+
+	// Runs a single pipeline. Replaced in tests.
+	run func(conf string, env *phonelab.Environment) []error
+}
+
+func NewBatchRunner(template string, files []string, outputDir string) *BatchRunner {
+	return &BatchRunner{
+		Template:   template,
+		Files:      files,
+		OutputDir:  outputDir,
+		Workers:    runtime.NumCPU(),
+		Resume:     true,
+		Subprocess: true,
+	}
+}
+
+func NewDefaultEnvironment() *phonelab.Environment {
+	env := phonelab.NewEnvironment()
+	AddParsers(env)
+	AddProcessors(env)
+	AddDataCollectors(env)
+	return env
+}
+
+func runPipelineConf(confString string, env *phonelab.Environment) []error {
+	conf, err := phonelab.RunnerConfFromString(confString)
+	if err != nil {
+		return []error{err}
+	}
+
+	runner, err := conf.ToRunner(env)
+	if err != nil {
+		return []error{err}
+	}
+
+	return runner.Run()
+}
+
+// Where the results for a file are saved. Different paths can sanitize to the
+// same name, e.g. /a/b.log and /a_b.log, so a hash of the full path is added.
+func (br *BatchRunner) OutputPath(file string) string {
+	h := fnv.New32a()
+	h.Write([]byte(file))
+	name := fmt.Sprintf("%v-%08x.json", sanitizeFileName(strings.TrimPrefix(file, "/")), h.Sum32())
+	return filepath.Join(br.OutputDir, name)
+}
+
+// Execute the template for a file.
+func batchConf(tmpl *template.Template, file string) (string, error) {
+	var buf bytes.Buffer
+	args := struct {
+		File string
+		Name string
+	}{file, sanitizeFileName(strings.TrimPrefix(file, "/"))}
+
+	if err := tmpl.Execute(&buf, args); err != nil {
+		return "", fmt.Errorf("Error executing template: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// Run the pipeline for a file. Panics in the calling goroutine are turned
+// into errors, but a panic in any other goroutine, e.g. a processor's, still
+// takes down the process.
+func runBatchConf(conf, file string, newEnv func() *phonelab.Environment,
+	run func(string, *phonelab.Environment) []error) (out *BatchFileOutput, err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			out = nil
+			err = fmt.Errorf("Panic: %v", r)
+		}
+	}()
+
+	if newEnv == nil {
+		newEnv = NewDefaultEnvironment
+	}
+	env := newEnv()
+
+	out = &BatchFileOutput{File: file}
+	env.DataCollectors[BatchCollectorName] = func(kwargs map[string]interface{}) phonelab.DataCollector {
+		return &batchCollector{out: out}
+	}
+
+	if run == nil {
+		run = runPipelineConf
+	}
+
+	if errs := run(conf, env); len(errs) > 0 {
+		msgs := make([]string, 0, len(errs))
+		for _, e := range errs {
+			msgs = append(msgs, e.Error())
+		}
+		return nil, fmt.Errorf("%v", strings.Join(msgs, "; "))
+	}
+
+	return out, nil
+}
+
+// Process a single file, in this process or a subprocess.
+func (br *BatchRunner) runFile(tmpl *template.Template, file string) (*BatchFileOutput, error) {
+	conf, err := batchConf(tmpl, file)
+	if err != nil {
+		return nil, err
+	}
+
+	if br.Subprocess {
+		return br.runSubprocess(conf, file)
+	}
+	return runBatchConf(conf, file, br.NewEnvironment, br.run)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Subprocesses
+
+// The subprocess gets the pipeline conf on stdin, and these in its
+// environment.
+const (
+	batchChildFileEnv   = "PHONELAB_BATCH_FILE"
+	batchChildOutputEnv = "PHONELAB_BATCH_OUTPUT"
+)
+
+// Prefix for errors the subprocess writes to stderr
+const batchChildErrorPrefix = "batch error: "
+
+// Set once RunBatchChild has been called, so Run knows subprocesses will work.
+var batchChildReady int32
+
+func setBatchChildReady() {
+	atomic.StoreInt32(&batchChildReady, 1)
+}
+
+func isBatchChildReady() bool {
+	return atomic.LoadInt32(&batchChildReady) != 0
+}
+
+// Run a file in a copy of this program, which must call RunBatchChild.
+func (br *BatchRunner) runSubprocess(conf, file string) (*BatchFileOutput, error) {
+	tmp, err := ioutil.TempFile(br.OutputDir, "child")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(os.Args[0], br.SubprocessArgs...)
+	cmd.Env = append(os.Environ(),
+		batchChildFileEnv+"="+file,
+		batchChildOutputEnv+"="+tmp.Name())
+	cmd.Stdin = strings.NewReader(conf)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = &stderr
+
+	if err = cmd.Run(); err != nil {
+		return nil, batchChildError(err, stderr.String())
+	}
+
+	return loadBatchFileOutput(tmp.Name())
+}
+
+// Pick the useful part out of a failed subprocess's stderr: its own error,
+// or the panic.
+func batchChildError(err error, stderr string) error {
+	last := ""
+	for _, line := range strings.Split(stderr, "\n") {
+		if strings.HasPrefix(line, batchChildErrorPrefix) {
+			return fmt.Errorf("%v", strings.TrimPrefix(line, batchChildErrorPrefix))
+		} else if strings.HasPrefix(line, "panic: ") {
+			return fmt.Errorf("Panic: %v", strings.TrimPrefix(line, "panic: "))
+		} else if len(strings.TrimSpace(line)) > 0 {
+			last = line
+		}
+	}
+	if len(last) > 0 {
+		return fmt.Errorf("%v: %v", err, last)
+	}
+	return err
+}
+
+// RunBatchChild runs the file a BatchRunner with Subprocess set handed to this
+// process, then exits. It returns right away if this isn't a batch
+// subprocess, so call it first thing in main. newEnv is as in
+// BatchRunner.NewEnvironment, and can be nil.
+func RunBatchChild(newEnv func() *phonelab.Environment) {
+	runBatchChild(newEnv, runPipelineConf)
+}
+
+func runBatchChild(newEnv func() *phonelab.Environment,
+	run func(string, *phonelab.Environment) []error) {
+
+	setBatchChildReady()
+
+	output := os.Getenv(batchChildOutputEnv)
+	if len(output) == 0 {
+		return
+	}
+
+	conf, err := ioutil.ReadAll(os.Stdin)
+	if err == nil {
+		var out *BatchFileOutput
+		if out, err = runBatchConf(string(conf), os.Getenv(batchChildFileEnv), newEnv, run); err == nil {
+			err = writeJSONFile(output, out)
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v%v\n", batchChildErrorPrefix, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Write the output to a temp file first, so an interrupted batch never leaves
+// a partial output behind to be picked up by a resume.
+func writeJSONFile(path string, v interface{}) error {
+	bytes, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, bytes, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func loadBatchFileOutput(path string) (*BatchFileOutput, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &BatchFileOutput{}
+	if err = json.Unmarshal(bytes, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Run the batch and return the merged results, which are also written to
+// <OutputDir>/merged.json.
+func (br *BatchRunner) Run() (*BatchResult, error) {
+	tmpl, err := template.New("batch").Parse(br.Template)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid batch template: %v", err)
+	}
+
+	// Otherwise every file would fail the same way
+	if br.Subprocess && !isBatchChildReady() {
+		return nil, fmt.Errorf("Batch subprocesses need RunBatchChild to be called at the start of main")
+	}
+
+	if err = os.MkdirAll(br.OutputDir, 0755); err != nil {
+		return nil, err
+	}
+
+	workers := br.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	type fileResult struct {
+		out     *BatchFileOutput
+		resumed bool
+		err     error
+	}
+
+	results := make([]*fileResult, len(br.Files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				file := br.Files[i]
+				path := br.OutputPath(file)
+
+				if br.Resume {
+					if _, err := os.Stat(path); err == nil {
+						out, err := loadBatchFileOutput(path)
+						results[i] = &fileResult{out: out, resumed: true, err: err}
+						continue
+					}
+				}
+
+				out, err := br.runFile(tmpl, file)
+				if err == nil {
+					err = writeJSONFile(path, out)
+				}
+				results[i] = &fileResult{out: out, err: err}
+			}
+		}()
+	}
+
+	for i := range br.Files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Merge in file order, so the output doesn't depend on scheduling
+	merged := &BatchResult{
+		Files:    len(br.Files),
+		Failures: make([]*BatchFailure, 0),
+		Spinners: make([]*SpinnerCollectorOutput, 0),
+	}
+
+	for i, res := range results {
+		if res.err != nil {
+			merged.Failures = append(merged.Failures, &BatchFailure{
+				File:  br.Files[i],
+				Error: res.err.Error(),
+			})
+			continue
+		}
+
+		if res.resumed {
+			merged.Resumed += 1
+		} else {
+			merged.Processed += 1
+		}
+		merged.merge(res.out)
+	}
+
+	if err = writeJSONFile(filepath.Join(br.OutputDir, batchMergedFileName), merged); err != nil {
+		return merged, err
+	}
+
+	return merged, nil
+}
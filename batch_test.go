@@ -0,0 +1,273 @@
+package libphonelabgo
+
+import (
+	"errors"
+	phonelab "github.com/shaseley/phonelab-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Pretends to run a pipeline, based on the file in the conf.
+func fakeBatchRun(calls *int, mu *sync.Mutex) func(string, *phonelab.Environment) []error {
+	return func(conf string, env *phonelab.Environment) []error {
+		mu.Lock()
+		*calls += 1
+		mu.Unlock()
+
+		file := strings.TrimSpace(strings.TrimPrefix(conf, "file:"))
+
+		switch file {
+		case "bad.log":
+			return []error{errors.New("bad log")}
+		case "panic.log":
+			panic("oops")
+		case "goroutine.log":
+			// Like a panic in a processor
+			done := make(chan bool)
+			go func() {
+				panic("processor oops")
+			}()
+			<-done
+		}
+
+		c := env.DataCollectors[BatchCollectorName](nil)
+		c.OnData(&SpinnerCollectorOutput{
+			Spinners: []*Spinner{&Spinner{DurationMs: 100}},
+		}, &testSourceInfo{file})
+
+		stats := NewInputEventStats(0.01)
+		stats.Add(statsTestResult(TouchScreenEventTap, TapEventFinishTimeout, 100, ""))
+		stats.Finalize()
+		c.OnData(stats, &testSourceInfo{file})
+
+		c.OnData(&FrameRefreshEvent{SysTimeNs: 1}, &testSourceInfo{file})
+		c.Finish()
+		return nil
+	}
+}
+
+func TestBatchRunner(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "batch")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	calls := 0
+	var mu sync.Mutex
+
+	files := []string{"a.log", "bad.log", "b.log", "panic.log", "/data/c.log"}
+	br := NewBatchRunner("file: {{.File}}", files, dir)
+	br.Workers = 3
+	br.Subprocess = false
+	br.run = fakeBatchRun(&calls, &mu)
+
+	res, err := br.Run()
+	require.Nil(err)
+
+	assert.Equal(5, calls)
+	assert.Equal(5, res.Files)
+	assert.Equal(3, res.Processed)
+	assert.Equal(0, res.Resumed)
+	require.Equal(2, len(res.Failures))
+	assert.Equal("bad.log", res.Failures[0].File)
+	assert.Equal("bad log", res.Failures[0].Error)
+	assert.Equal("panic.log", res.Failures[1].File)
+	assert.Contains(res.Failures[1].Error, "oops")
+
+	// Merged in file order
+	require.Equal(3, len(res.Spinners))
+	assert.Equal("a.log", res.Spinners[0].File)
+	assert.Equal("b.log", res.Spinners[1].File)
+	assert.Equal("/data/c.log", res.Spinners[2].File)
+	assert.Equal(3, res.ISMStats.Files)
+	assert.Equal(int64(3), res.ISMStats.Get(StatsGroupAll, StatsGroupAll).Count)
+	assert.Equal(3, len(res.Data))
+
+	// Outputs
+	_, err = os.Stat(br.OutputPath("/data/c.log"))
+	assert.Nil(err)
+	assert.True(strings.HasPrefix(br.OutputPath("/data/c.log"), filepath.Join(dir, "data_c.log-")))
+	assert.NotEqual(br.OutputPath("/a/b.log"), br.OutputPath("/a_b.log"))
+	_, err = os.Stat(br.OutputPath("bad.log"))
+	assert.True(os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "merged.json"))
+	assert.Nil(err)
+
+	out, err := loadBatchFileOutput(br.OutputPath("a.log"))
+	require.Nil(err)
+	assert.Equal(1, len(out.Data))
+
+	// Resume only runs the failed files, and still merges everything
+	calls = 0
+	res, err = br.Run()
+	require.Nil(err)
+
+	assert.Equal(2, calls)
+	assert.Equal(0, res.Processed)
+	assert.Equal(3, res.Resumed)
+	assert.Equal(2, len(res.Failures))
+	assert.Equal(3, len(res.Spinners))
+	assert.Equal(int64(3), res.ISMStats.Get(StatsGroupAll, StatsGroupAll).Count)
+
+	// Without resume, everything runs again
+	calls = 0
+	br.Resume = false
+	_, err = br.Run()
+	require.Nil(err)
+	assert.Equal(5, calls)
+
+	// Bad templates fail early
+	br.Template = "{{.File"
+	_, err = br.Run()
+	assert.NotNil(err)
+}
+
+func TestBatchRunnerNotWired(t *testing.T) {
+	assert := assert.New(t)
+
+	if isBatchChildReady() {
+		t.Skip("RunBatchChild was already called")
+	}
+
+	// Subprocesses are the default, and need RunBatchChild
+	br := NewBatchRunner("file: {{.File}}", []string{"a.log"}, "")
+	assert.True(br.Subprocess)
+	_, err := br.Run()
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "RunBatchChild")
+}
+
+func TestBatchOutputErrors(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	out := &BatchFileOutput{File: "a.log"}
+	out.add(NewInputEventStats(0.01))
+
+	// Stats with a different accuracy can't be merged
+	other := NewInputEventStats(0.05)
+	other.Add(statsTestResult(TouchScreenEventTap, TapEventFinishTimeout, 100, ""))
+	out.add(other)
+	out.add(&FrameRefreshEvent{SysTimeNs: 1})
+
+	require.Equal(1, len(out.Errors))
+	assert.Equal(1, len(out.Data))
+
+	res := &BatchResult{}
+	res.merge(out)
+	require.Equal(1, len(res.Failures))
+	assert.Equal("a.log", res.Failures[0].File)
+	assert.Contains(res.Failures[0].Error, "ISM stats")
+	assert.Equal(1, len(res.Data))
+}
+
+// Not a real test: this is the subprocess for TestBatchRunnerSubprocess.
+func TestBatchRunnerChild(t *testing.T) {
+	calls := 0
+	var mu sync.Mutex
+	runBatchChild(nil, fakeBatchRun(&calls, &mu))
+}
+
+func TestBatchRunnerSubprocess(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "batch")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	files := []string{"a.log", "goroutine.log", "bad.log", "panic.log", "b.log"}
+	br := NewBatchRunner("file: {{.File}}", files, dir)
+	br.SubprocessArgs = []string{"-test.run=^TestBatchRunnerChild$"}
+	// The test binary can't call RunBatchChild in main
+	setBatchChildReady()
+
+	res, err := br.Run()
+	require.Nil(err)
+
+	assert.Equal(2, res.Processed)
+	require.Equal(3, len(res.Failures))
+	assert.Equal("goroutine.log", res.Failures[0].File)
+	assert.Equal("Panic: processor oops", res.Failures[0].Error)
+	assert.Equal("bad log", res.Failures[1].Error)
+	assert.Equal("Panic: oops", res.Failures[2].Error)
+
+	require.Equal(2, len(res.Spinners))
+	assert.Equal("a.log", res.Spinners[0].File)
+	assert.Equal("b.log", res.Spinners[1].File)
+	assert.Equal(int64(2), res.ISMStats.Get(StatsGroupAll, StatsGroupAll).Count)
+
+	// Only the outputs and the merged results are left behind
+	entries, err := ioutil.ReadDir(dir)
+	require.Nil(err)
+	assert.Equal(3, len(entries))
+}
+
+func TestBatchRunnerSpinners(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "batch")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	tmpl := `
+data_collector: {name: batch}
+source:
+  type: files
+  sources: ["{{.File}}"]
+
+sink:
+  name: main
+  args: &spinner_args
+    min: 0.001
+    max: 4.000
+    algo: voting
+
+processors:
+  - name: diffstream
+    generator: framediffs
+    has_logstream: true
+    parsers:
+      - &SF SurfaceFlinger
+    filters:
+      - type: simple
+        filter: *SF
+
+  - name: detector
+    generator: spinners
+    has_logstream: false
+    inputs:
+      - name: diffstream
+
+  - name: main
+    generator: spinner_collector
+    inputs:
+      - name: detector
+        args: *spinner_args
+`
+
+	br := NewBatchRunner(tmpl, []string{"./test/test.log"}, dir)
+	br.Workers = 2
+	br.Subprocess = false
+
+	res, err := br.Run()
+	require.Nil(err)
+
+	assert.Equal(1, res.Processed)
+	assert.Equal(0, len(res.Failures))
+	require.Equal(1, len(res.Spinners))
+	assert.Equal("./test/test.log", res.Spinners[0].File)
+}
@@ -27,6 +27,19 @@ func AddParsers(env *phonelab.Environment) {
 
 	// InputServiceManager
 	env.RegisterParserGenerator("InputMethodService-LifeCycle-QoE", NewIMSLifeCycleParser)
+
+	// Activities
+	env.RegisterParserGenerator("Activity-LifeCycle-QoE", NewActivityLifeCycleParser)
+
+	// App-side jank
+	env.RegisterParserGenerator("OpenGLRenderer", NewOpenGLRendererParser)
+	env.RegisterParserGenerator("Choreographer", NewChoreographerParser)
+
+	// User actions
+	env.RegisterParserGenerator("KeyEvent-UserAction-QoE", NewKeyEventUserActionParser)
+
+	// Spinner ground truth
+	env.RegisterParserGenerator("Spinner-State-QoE", NewSpinnerStateParser)
 }
 
 // Add all known processors to the enviroment. Any arguments needed for the
@@ -39,9 +52,17 @@ func AddProcessors(env *phonelab.Environment) {
 	env.Processors["spinners"] = &SpinnerAlgoGenerator{}
 	env.Processors["spinner_stitcher"] = &SpinnerStitcherGen{}
 	env.Processors["spinner_collector"] = &SpinnerCollectorGenerator{}
+	env.Processors["spinner_hmm_train"] = &SpinnerHMMTrainProcessorGenerator{}
+
+	// Spinners + Input
+	env.Processors["user_waits"] = &UserWaitProcessorGenerator{}
+
+	// Frustration signals
+	env.Processors["frustration"] = &FrustrationProcessorGenerator{}
 
 	// Input
 	env.Processors["input_gestures"] = &InputProcessorGenerator{}
+	env.Processors["dispatch_latency"] = &DispatchLatencyProcessorGenerator{}
 
 	// Input + Diffs
 	env.Processors["input_diffs"] = &InputDiffProcessorGenerator{}
@@ -52,7 +73,51 @@ func AddProcessors(env *phonelab.Environment) {
 	// Frame samples
 	env.Processors["frametimes"] = &FrameRefreshEmitterGen{}
 
+	// Frame pacing
+	env.Processors["frame_pacing"] = &FramePacingProcessorGenerator{}
+
+	// Frame diff heatmaps
+	env.Processors["input_heatmaps"] = &InputHeatmapProcessorGenerator{}
+
+	// Foreground app changes
+	env.Processors["foreground_app"] = &ForegroundAppProcessorGenerator{}
+
 	// Input state machine
 	env.Processors["input_state_machine"] =
 		&phonelab.ProcessorGenWrapper{GenerateISMProcessor}
+
+	// Soft keyboard
+	env.Processors["keyboard_events"] = &KeyboardEventProcessorGenerator{}
+	env.Processors["keyboard_latency"] = &KeyboardLatencyProcessorGenerator{}
+	env.Processors["keystrokes"] = &KeystrokeClassifierGenerator{}
+	env.Processors["typing"] = &TypingProcessorGenerator{}
+
+	// Per-device input state machines
+	env.Processors["device_ism"] = &DeviceISMProcessorGenerator{}
+
+	// App vs. compositor jank
+	env.Processors["app_jank"] = &AppJankEmitterGenerator{}
+	env.Processors["jank_attribution"] = &JankAttributionProcessorGenerator{}
+
+	// Background animation masks
+	env.Processors["background_mask"] = &BackgroundMaskProcessorGenerator{}
+
+	// Input state machine stats
+	env.Processors["ism_stats"] = &InputEventStatsProcessorGenerator{}
+}
+
+// Add all known data collectors to the environment.
+func AddDataCollectors(env *phonelab.Environment) {
+	env.DataCollectors["dumb"] = func(kwargs map[string]interface{}) phonelab.DataCollector {
+		return NewDumbCollectorFromArgs(kwargs)
+	}
+	env.DataCollectors["chrome_trace"] = func(kwargs map[string]interface{}) phonelab.DataCollector {
+		return NewChromeTraceCollector(kwargs)
+	}
+	env.DataCollectors["session_report"] = func(kwargs map[string]interface{}) phonelab.DataCollector {
+		return NewSessionReportCollector(kwargs)
+	}
+	env.DataCollectors["ism_stats"] = func(kwargs map[string]interface{}) phonelab.DataCollector {
+		return NewInputEventStatsCollector(kwargs)
+	}
 }
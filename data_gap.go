@@ -0,0 +1,79 @@
+package libphonelabgo
+
+import (
+	"fmt"
+)
+
+// data_gap.go has the types used to signal missing data in the streams we
+// unpack from SurfaceFlinger logs.
+
+// Streams that can report gaps
+const (
+	DataGapStreamFrameDiffs = "framediffs"
+	DataGapStreamFrameTimes = "frametimes"
+)
+
+// How downstream algorithms treat results that overlap a gap
+const (
+	// Keep the result, but flag it as incomplete.
+	GapPolicyMark = "mark"
+	// Drop the result entirely.
+	GapPolicyAbort = "abort"
+	// Pretend the gap isn't there. This is the old behavior.
+	GapPolicyIgnore = "ignore"
+)
+
+// DataGap marks a hole in a SurfaceFlinger stream, detected through a jump in
+// the log tokens. Nothing is known about the screen between StartNs and EndNs,
+// which are the timestamps of the last sample before and the first sample
+// after the gap.
+type DataGap struct {
+	Stream         string  `json:"stream"`
+	PrevToken      int64   `json:"prev_token"`
+	NewToken       int64   `json:"new_token"`
+	StartNs        int64   `json:"start_ns"`
+	EndNs          int64   `json:"end_ns"`
+	TraceTimeStart float64 `json:"trace_time_start"`
+	TraceTimeEnd   float64 `json:"trace_time_end"`
+}
+
+func (gap *DataGap) MonotonicTimestamp() float64 {
+	if GlobalConf.UseSysTime {
+		return float64(gap.StartNs) / nsPerSecF
+	} else {
+		return gap.TraceTimeStart
+	}
+}
+
+// Number of logs that went missing.
+func (gap *DataGap) MissingTokens() int64 {
+	return gap.NewToken - gap.PrevToken - 1
+}
+
+func (gap *DataGap) DurationMs() int64 {
+	return (gap.EndNs - gap.StartNs) / nsPerMs
+}
+
+// Whether or not the gap overlaps the interval [startNs, endNs]. An endNs <= 0
+// means the interval is still open.
+func (gap *DataGap) Overlaps(startNs, endNs int64) bool {
+	if endNs > 0 && gap.StartNs > endNs {
+		return false
+	}
+	return gap.EndNs >= startNs
+}
+
+// Get the gap policy from kwargs, falling back to the default. Panics on an
+// unknown policy.
+func gapPolicyFromArgs(kwargs map[string]interface{}, key string, def string) string {
+	if v, ok := kwargs[key]; ok {
+		if policy, ok := v.(string); ok {
+			switch policy {
+			case GapPolicyMark, GapPolicyAbort, GapPolicyIgnore:
+				return policy
+			}
+		}
+		panic(fmt.Sprintf("Unknown %v: %v", key, v))
+	}
+	return def
+}
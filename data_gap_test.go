@@ -0,0 +1,233 @@
+package libphonelabgo
+
+import (
+	phonelab "github.com/shaseley/phonelab-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// sliceSource is a Processor that emits a fixed set of logs.
+type sliceSource struct {
+	logs []interface{}
+}
+
+func (src *sliceSource) Process() <-chan interface{} {
+	outChan := make(chan interface{})
+	go func() {
+		for _, log := range src.logs {
+			outChan <- log
+		}
+		close(outChan)
+	}()
+	return outChan
+}
+
+func collectAll(proc phonelab.Processor) []interface{} {
+	res := make([]interface{}, 0)
+	for log := range proc.Process() {
+		res = append(res, log)
+	}
+	return res
+}
+
+func diffLogline(token int64, timestamps ...int64) *phonelab.Logline {
+	log := &SFFrameDiffLog{
+		Token: token,
+		Diffs: make([]*SFFrameDiff, 0, len(timestamps)),
+	}
+	for _, ts := range timestamps {
+		log.Diffs = append(log.Diffs, &SFFrameDiff{Timestamp: ts, PctDiff: 1.0})
+	}
+	return &phonelab.Logline{Payload: log}
+}
+
+func TestFrameDiffEmitterGap(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	emitter := &FrameDiffEmitter{
+		Source: &sliceSource{[]interface{}{
+			diffLogline(10, 100, 200),
+			diffLogline(11, 300),
+			// Tokens 12 and 13 are missing
+			diffLogline(14, 5000, 5100),
+		}},
+		InterlaceZerosMs: 40,
+	}
+
+	var gap *DataGap
+	prevMs := int64(0)
+
+	for _, log := range collectAll(emitter) {
+		switch t := log.(type) {
+		case *DataGap:
+			require.Nil(gap)
+			gap = t
+		case *FrameDiffSample:
+			if prevMs > 0 && gap == nil {
+				assert.True(prevMs+80 >= t.Timestamp)
+			} else if prevMs > 0 {
+				// Nothing should be made up inside the gap
+				assert.False(t.Inserted && t.Timestamp < 5000)
+			}
+			prevMs = t.Timestamp
+		}
+	}
+
+	require.NotNil(gap)
+	assert.Equal(DataGapStreamFrameDiffs, gap.Stream)
+	assert.Equal(int64(11), gap.PrevToken)
+	assert.Equal(int64(14), gap.NewToken)
+	assert.Equal(int64(2), gap.MissingTokens())
+	assert.Equal(int64(300*nsPerMs), gap.StartNs)
+	assert.Equal(int64(5000*nsPerMs), gap.EndNs)
+	assert.Equal(int64(4700), gap.DurationMs())
+}
+
+func TestFrameRefreshEmitterGap(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	emitter := &FrameRefreshEmitter{
+		Source: &sliceSource{[]interface{}{
+			&phonelab.Logline{Payload: &SFFrameTimesLog{Token: 1, Times: []int64{100, 200}}},
+			&phonelab.Logline{Payload: &SFFrameTimesLog{Token: 2, Times: []int64{300}}},
+			&phonelab.Logline{Payload: &SFFrameTimesLog{Token: 4, Times: []int64{900}}},
+		}},
+	}
+
+	res := collectAll(emitter)
+	require.Equal(5, len(res))
+
+	gap, ok := res[3].(*DataGap)
+	require.True(ok)
+	assert.Equal(DataGapStreamFrameTimes, gap.Stream)
+	assert.Equal(int64(300), gap.StartNs)
+	assert.Equal(int64(900), gap.EndNs)
+}
+
+func gapTestISM(policy string) *InputStateMachine {
+	ism := NewInputStateMachine()
+	ism.Params.GapPolicy = policy
+	ism.OnTouchEvent(&TouchScreenEvent{
+		What:      TouchScreenEventTap,
+		Timestamp: 100 * nsPerMs,
+	})
+	return ism
+}
+
+func TestISMDataGap(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	gap := &DataGap{
+		StartNs: 150 * nsPerMs,
+		EndNs:   900 * nsPerMs,
+	}
+
+	// Mark
+	ism := gapTestISM(GapPolicyMark)
+	assert.Nil(ism.OnDataGap(gap))
+	res := ism.Finish(1000 * nsPerMs)
+	require.NotNil(res)
+	assert.True(res.Incomplete)
+
+	// Abort
+	ism = gapTestISM(GapPolicyAbort)
+	assert.Nil(ism.OnDataGap(gap))
+	assert.Equal(InputStateWaitInput, ism.curState)
+	assert.Nil(ism.Finish(1000 * nsPerMs))
+
+	// Ignore
+	ism = gapTestISM(GapPolicyIgnore)
+	assert.Nil(ism.OnDataGap(gap))
+	res = ism.Finish(1000 * nsPerMs)
+	require.NotNil(res)
+	assert.False(res.Incomplete)
+
+	// A gap before the input doesn't matter
+	ism = gapTestISM(GapPolicyMark)
+	assert.Nil(ism.OnDataGap(&DataGap{StartNs: 10 * nsPerMs, EndNs: 50 * nsPerMs}))
+	res = ism.Finish(1000 * nsPerMs)
+	require.NotNil(res)
+	assert.False(res.Incomplete)
+}
+
+func TestVotingSpinnerDataGap(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	for _, policy := range []string{GapPolicyMark, GapPolicyAbort} {
+		algo := NewVotingSpinnerAlgo(&SpinnerAlgoConf{
+			Min:         0.1,
+			Max:         4.0,
+			NumVotesIn:  2,
+			NumVotesOut: 2,
+			GapPolicy:   policy,
+		})
+
+		for _, ts := range []int64{100, 150, 200, 250} {
+			assert.Nil(algo.Handle(&FrameDiffSample{
+				SFFrameDiff: SFFrameDiff{Timestamp: ts, PctDiff: 1.0},
+			}))
+		}
+		require.True(algo.state.isSpinner)
+
+		res := algo.Handle(&DataGap{StartNs: 250 * nsPerMs, EndNs: 2000 * nsPerMs})
+		assert.False(algo.state.isSpinner)
+
+		if policy == GapPolicyAbort {
+			assert.Nil(res)
+			continue
+		}
+
+		spinner, ok := res.(*Spinner)
+		require.True(ok)
+		assert.True(spinner.Incomplete)
+		assert.Equal(int64(100), spinner.StartTimeMs)
+		assert.Equal(int64(250), spinner.EndTimeMs)
+		assert.Equal(int64(150), spinner.DurationMs)
+	}
+}
+
+func TestSpinnerDataGapDefault(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	// Without a policy, spinners carry on through gaps as they always have
+	algo := NewVotingSpinnerAlgo(&SpinnerAlgoConf{
+		Min:         0.1,
+		Max:         4.0,
+		NumVotesIn:  2,
+		NumVotesOut: 2,
+	})
+	for _, ts := range []int64{100, 150, 200, 250} {
+		algo.Handle(&FrameDiffSample{
+			SFFrameDiff: SFFrameDiff{Timestamp: ts, PctDiff: 1.0},
+		})
+	}
+	assert.Nil(algo.Handle(&DataGap{StartNs: 250 * nsPerMs, EndNs: 2000 * nsPerMs}))
+	assert.True(algo.state.isSpinner)
+	assert.Equal("", NewSpinnerAlgoConf(map[string]interface{}{}).GapPolicy)
+}
+
+func TestGapPolicyFromArgs(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	assert.Equal(GapPolicyMark, gapPolicyFromArgs(map[string]interface{}{}, "gap_policy", GapPolicyMark))
+	assert.Equal(GapPolicyAbort, gapPolicyFromArgs(map[string]interface{}{"gap_policy": "abort"},
+		"gap_policy", GapPolicyMark))
+
+	assert.Panics(func() {
+		gapPolicyFromArgs(map[string]interface{}{"gap_policy": "drop"}, "gap_policy", GapPolicyMark)
+	})
+	assert.Panics(func() {
+		gapPolicyFromArgs(map[string]interface{}{"gap_policy": 1}, "gap_policy", GapPolicyMark)
+	})
+}
@@ -0,0 +1,311 @@
+package libphonelabgo
+
+import (
+	phonelab "github.com/shaseley/phonelab-go"
+	"reflect"
+)
+
+// device.go splits a mixed stream of loglines into per-device streams. Every
+// logline starts with the device UUID, so concatenated logs from several
+// devices can be handled correctly, as long as each device gets its own state
+// (gesture detector, time sync offsets, state machine, ...). Each device gets
+// its own copy of a sub-pipeline, and everything it emits is tagged with the
+// device id.
+
+// DeviceData tags a result with the device it came from.
+type DeviceData struct {
+	DeviceId string      `json:"device_id"`
+	Data     interface{} `json:"data"`
+}
+
+// Anything that can be ordered in time. Most of the results in this library
+// implement this.
+type monotonicTimestamper interface {
+	MonotonicTimestamp() float64
+}
+
+// chanProcessor adapts a channel to a phonelab.Processor.
+type chanProcessor struct {
+	ch <-chan interface{}
+}
+
+func (p *chanProcessor) Process() <-chan interface{} {
+	return p.ch
+}
+
+// How many items each tee branch and each device's input can hold before the
+// sender blocks.
+const DefaultTeeBufferSize = 1024
+
+// NewTee sends everything from source to n processors. Each branch can fall
+// up to DefaultTeeBufferSize items behind the others; after that, the tee
+// waits for it. If the branches are merged again, use a TimestampMerger with
+// MaxPending set, or a quiet branch can stall the tee.
+func NewTee(source phonelab.Processor, n int) []phonelab.Processor {
+	inputs := make([]chan interface{}, n)
+	branches := make([]phonelab.Processor, n)
+
+	for i := 0; i < n; i++ {
+		inputs[i] = make(chan interface{}, DefaultTeeBufferSize)
+		branches[i] = &chanProcessor{inputs[i]}
+	}
+
+	go func() {
+		for v := range source.Process() {
+			for _, in := range inputs {
+				in <- v
+			}
+		}
+		for _, in := range inputs {
+			close(in)
+		}
+	}()
+
+	return branches
+}
+
+// TimestampMerger merges the output of several processors by timestamp.
+// Each source must already be in order. Anything without a timestamp is sent
+// as soon as it arrives.
+//
+// With MaxPending 0 the merge is strict, and the output is in order. That
+// means waiting for every source's next item, so one quiet source holds back
+// the rest. If they share a tee, the tee fills up waiting on the merge, and
+// the quiet source never gets the input it needs.
+//
+// With MaxPending set, once a source has that many items waiting and another
+// has none, the oldest waiting item is sent anyway. The output is then only
+// mostly in order: if the quiet source later sends something older, it is
+// sent late. Late items are counted in a TimestampMergerStats sent at the end.
+type TimestampMerger struct {
+	Sources []phonelab.Processor
+	// Items held per source. 0 means a strict merge.
+	MaxPending int
+}
+
+// TimestampMergerStats counts items a TimestampMerger with MaxPending sent
+// out of order.
+type TimestampMergerStats struct {
+	Sent int `json:"sent"`
+	Late int `json:"late"`
+	// How far behind the latest item sent the worst late item was
+	MaxLateMs float64 `json:"max_late_ms"`
+}
+
+func (m *TimestampMerger) Process() <-chan interface{} {
+	outChan := make(chan interface{})
+
+	go func() {
+		inputs := make([]<-chan interface{}, len(m.Sources))
+		for i, source := range m.Sources {
+			inputs[i] = source.Process()
+		}
+
+		limit := m.MaxPending
+		if limit <= 0 {
+			limit = 1
+		}
+
+		queues := make([][]monotonicTimestamper, len(inputs))
+		open := len(inputs)
+
+		stats := &TimestampMergerStats{}
+		lastTs := 0.0
+
+		// Send the oldest queued item, if the merge can go ahead.
+		next := func() bool {
+			oldest := -1
+			waiting := false
+			full := false
+			for i, queue := range queues {
+				if len(queue) == 0 {
+					waiting = waiting || inputs[i] != nil
+					continue
+				}
+				full = full || len(queue) >= limit
+				if oldest < 0 || queue[0].MonotonicTimestamp() < queues[oldest][0].MonotonicTimestamp() {
+					oldest = i
+				}
+			}
+			if oldest < 0 || (waiting && !(full && m.MaxPending > 0)) {
+				return false
+			}
+
+			item := queues[oldest][0]
+			if ts := item.MonotonicTimestamp(); stats.Sent > 0 && ts < lastTs {
+				stats.Late += 1
+				if lateMs := (lastTs - ts) * msPerSecF; lateMs > stats.MaxLateMs {
+					stats.MaxLateMs = lateMs
+				}
+			} else {
+				lastTs = ts
+			}
+			stats.Sent += 1
+
+			outChan <- item
+			queues[oldest][0] = nil
+			queues[oldest] = queues[oldest][1:]
+			return true
+		}
+
+		for open > 0 {
+			for next() {
+			}
+
+			// Read from every source that has room
+			cases := make([]reflect.SelectCase, 0, len(inputs))
+			indexes := make([]int, 0, len(inputs))
+			for i, in := range inputs {
+				if in != nil && len(queues[i]) < limit {
+					cases = append(cases, reflect.SelectCase{
+						Dir:  reflect.SelectRecv,
+						Chan: reflect.ValueOf(in),
+					})
+					indexes = append(indexes, i)
+				}
+			}
+
+			chosen, v, ok := reflect.Select(cases)
+			i := indexes[chosen]
+			if !ok {
+				inputs[i] = nil
+				open -= 1
+			} else if ts, isTs := v.Interface().(monotonicTimestamper); isTs {
+				queues[i] = append(queues[i], ts)
+			} else {
+				outChan <- v.Interface()
+			}
+		}
+
+		for next() {
+		}
+
+		if m.MaxPending > 0 {
+			outChan <- stats
+		}
+
+		close(outChan)
+	}()
+
+	return outChan
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Builds the sub-pipeline for one device.
+type DevicePipelineFunc func(deviceId string, source phonelab.Processor) phonelab.Processor
+
+// DeviceDemuxProcessor splits its source by Logline.DeviceId and runs a
+// separate sub-pipeline for each device. Results are sent as DeviceData.
+// Anything that isn't a logline goes to the device of the most recent
+// logline.
+type DeviceDemuxProcessor struct {
+	Source      phonelab.Processor
+	NewPipeline DevicePipelineFunc
+}
+
+func (demux *DeviceDemuxProcessor) Process() <-chan interface{} {
+	outChan := make(chan interface{})
+
+	go func() {
+		inChan := demux.Source.Process()
+
+		inputs := make(map[string]chan interface{})
+		done := make(chan bool)
+		curDevice := ""
+
+		deviceInput := func(deviceId string) chan interface{} {
+			if in, ok := inputs[deviceId]; ok {
+				return in
+			}
+
+			in := make(chan interface{}, DefaultTeeBufferSize)
+			inputs[deviceId] = in
+
+			pipeline := demux.NewPipeline(deviceId, &chanProcessor{in})
+
+			go func() {
+				for res := range pipeline.Process() {
+					outChan <- &DeviceData{
+						DeviceId: deviceId,
+						Data:     res,
+					}
+				}
+				done <- true
+			}()
+
+			return in
+		}
+
+		for iLog := range inChan {
+			if ll, ok := iLog.(*phonelab.Logline); ok && ll != nil {
+				curDevice = ll.DeviceId
+			}
+			deviceInput(curDevice) <- iLog
+		}
+
+		for _, in := range inputs {
+			close(in)
+		}
+		for range inputs {
+			<-done
+		}
+
+		close(outChan)
+	}()
+
+	return outChan
+}
+
+// Per-device input state machine: time sync, gestures, frame diffs, frame
+// times, foreground apps and keyboard events all feed a separate state
+// machine. Taps on the soft keyboard are classified as keystrokes. The
+// branches are merged with MaxPending, so the TimestampMergerStats are sent
+// along with the results; if any items were late, results around them may be
+// off.
+func NewDeviceISMPipeline(kwargs map[string]interface{}) DevicePipelineFunc {
+	return func(deviceId string, source phonelab.Processor) phonelab.Processor {
+		branches := NewTee(&TimeSyncPreprocessor{Source: source}, 5)
+
+		merged := &TimestampMerger{
+			MaxPending: DefaultTeeBufferSize,
+			Sources: []phonelab.Processor{
+				&InputProcessor{
+					TouchSlop:     TouchSlopScaled,
+					Source:        branches[0],
+					KeySource:     NewKeySource(kwargs),
+					NonHumanInput: NewNonHumanInput(kwargs),
+				},
+				NewFrameDiffEmitter(branches[1], kwargs),
+				&FrameRefreshEmitter{
+					Source: branches[2],
+				},
+				&ForegroundAppProcessor{
+					Source: branches[3],
+				},
+				&KeyboardEventProcessor{
+					Source: branches[4],
+				},
+			},
+		}
+
+		return &InputStateMachineProcessor{
+			Source: &KeystrokeClassifier{
+				Source:       merged,
+				KeyboardRows: DefaultKeyboardLatencyParams().KeyboardRows,
+			},
+			Args: kwargs,
+		}
+	}
+}
+
+type DeviceISMProcessorGenerator struct{}
+
+func (g *DeviceISMProcessorGenerator) GenerateProcessor(source *phonelab.PipelineSourceInstance,
+	kwargs map[string]interface{}) phonelab.Processor {
+
+	return &DeviceDemuxProcessor{
+		Source:      source.Processor,
+		NewPipeline: NewDeviceISMPipeline(kwargs),
+	}
+}
@@ -0,0 +1,257 @@
+package libphonelabgo
+
+import (
+	phonelab "github.com/shaseley/phonelab-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimestampMerger(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	a := &sliceSource{[]interface{}{
+		&FrameRefreshEvent{TraceTimeAdj: 1.0},
+		&FrameRefreshEvent{TraceTimeAdj: 4.0},
+	}}
+	b := &sliceSource{[]interface{}{
+		"no timestamp",
+		&FrameRefreshEvent{TraceTimeAdj: 2.0},
+		&FrameRefreshEvent{TraceTimeAdj: 3.0},
+		&FrameRefreshEvent{TraceTimeAdj: 5.0},
+	}}
+	c := &sliceSource{[]interface{}{}}
+
+	res := collectAll(&TimestampMerger{Sources: []phonelab.Processor{a, b, c}})
+	assert.Equal(6, len(res))
+	assert.Equal("no timestamp", res[0])
+
+	prev := 0.0
+	for _, v := range res[1:] {
+		ts := v.(*FrameRefreshEvent).TraceTimeAdj
+		assert.True(ts > prev)
+		prev = ts
+	}
+}
+
+func TestTee(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	branches := NewTee(&sliceSource{[]interface{}{1, 2, 3}}, 2)
+
+	// Draining one branch first must not block on the other
+	assert.Equal([]interface{}{1, 2, 3}, collectAll(branches[1]))
+	assert.Equal([]interface{}{1, 2, 3}, collectAll(branches[0]))
+}
+
+// Sends FrameRefreshEvents at 1s, 2s, ... and counts how many were taken.
+type countingSource struct {
+	n    int
+	sent int64
+}
+
+func (src *countingSource) Process() <-chan interface{} {
+	outChan := make(chan interface{})
+	go func() {
+		for i := 1; i <= src.n; i++ {
+			outChan <- &FrameRefreshEvent{TraceTimeAdj: float64(i)}
+			atomic.AddInt64(&src.sent, 1)
+		}
+		close(outChan)
+	}()
+	return outChan
+}
+
+// Only passes on the last item, like an input processor that sees no input.
+// With first set, passes on the first item instead, but only at the end.
+type quietProcessor struct {
+	source phonelab.Processor
+	first  bool
+}
+
+func (p *quietProcessor) Process() <-chan interface{} {
+	out := make(chan interface{})
+	go func() {
+		var keep interface{}
+		for v := range p.source.Process() {
+			if keep == nil || !p.first {
+				keep = v
+			}
+		}
+		if keep != nil {
+			out <- keep
+		}
+		close(out)
+	}()
+	return out
+}
+
+func TestTeeBackpressure(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	source := &countingSource{n: 10 * DefaultTeeBufferSize}
+	branches := NewTee(source, 2)
+
+	// Branch 1 is never read, so the tee stops once its buffer is full
+	in := branches[0].Process()
+	for i := 0; i < DefaultTeeBufferSize; i++ {
+		<-in
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.True(atomic.LoadInt64(&source.sent) <= int64(DefaultTeeBufferSize+2))
+}
+
+func TestTimestampMergerQuietBranch(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	n := 5 * DefaultTeeBufferSize
+	branches := NewTee(&countingSource{n: n}, 2)
+
+	done := make(chan []interface{})
+	go func() {
+		done <- collectAll(&TimestampMerger{
+			Sources:    []phonelab.Processor{branches[0], &quietProcessor{source: branches[1]}},
+			MaxPending: DefaultTeeBufferSize,
+		})
+	}()
+
+	select {
+	case res := <-done:
+		require.Equal(n+2, len(res))
+		prev := 0.0
+		for _, v := range res[:n+1] {
+			ts := v.(*FrameRefreshEvent).TraceTimeAdj
+			assert.True(ts >= prev)
+			prev = ts
+		}
+		assert.Equal(&TimestampMergerStats{Sent: n + 1}, res[n+1])
+	case <-time.After(10 * time.Second):
+		assert.Fail("Merge stalled")
+	}
+}
+
+func TestTimestampMergerLate(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// The quiet branch's only item is older than everything sent before it
+	n := 5 * DefaultTeeBufferSize
+	branches := NewTee(&countingSource{n: n}, 2)
+
+	res := collectAll(&TimestampMerger{
+		Sources:    []phonelab.Processor{branches[0], &quietProcessor{source: branches[1], first: true}},
+		MaxPending: DefaultTeeBufferSize,
+	})
+	require.Equal(n+2, len(res))
+
+	stats := res[n+1].(*TimestampMergerStats)
+	assert.Equal(n+1, stats.Sent)
+	assert.Equal(1, stats.Late)
+	assert.True(stats.MaxLateMs > 0.0)
+}
+
+// Counts the items for a device
+type deviceCounter struct {
+	source phonelab.Processor
+}
+
+func (c *deviceCounter) Process() <-chan interface{} {
+	out := make(chan interface{})
+	go func() {
+		count := 0
+		for range c.source.Process() {
+			count += 1
+		}
+		out <- count
+		close(out)
+	}()
+	return out
+}
+
+func TestDeviceDemux(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	source := &sliceSource{[]interface{}{
+		&phonelab.Logline{DeviceId: "a"},
+		&phonelab.Logline{DeviceId: "b"},
+		"follows b",
+		&phonelab.Logline{DeviceId: "a"},
+		&phonelab.Logline{DeviceId: "c"},
+		&phonelab.Logline{DeviceId: "a"},
+	}}
+
+	demux := &DeviceDemuxProcessor{
+		Source: source,
+		NewPipeline: func(deviceId string, source phonelab.Processor) phonelab.Processor {
+			return &deviceCounter{source}
+		},
+	}
+
+	counts := make(map[string]int)
+	for _, v := range collectAll(demux) {
+		data := v.(*DeviceData)
+		counts[data.DeviceId] = data.Data.(int)
+	}
+
+	assert.Equal(map[string]int{"a": 3, "b": 2, "c": 1}, counts)
+}
+
+func deviceTouchLog(deviceId string, action int, tsMs int64) *phonelab.Logline {
+	return &phonelab.Logline{
+		DeviceId:  deviceId,
+		TraceTime: float64(tsMs) / 1000.0,
+		Payload: &IFMotionEventLog{
+			Action:    action,
+			Timestamp: tsMs * nsPerMs,
+			PointerData: []*IFPointerData{
+				&IFPointerData{
+					Id:   0,
+					XPos: 700.0,
+					YPos: 200.0,
+				},
+			},
+		},
+	}
+}
+
+func TestDeviceISM(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Interleaved taps from two devices would look like a multi-touch
+	// gesture in a single stream.
+	source := &sliceSource{[]interface{}{
+		deviceTouchLog("a", ACTION_DOWN, 1000),
+		deviceTouchLog("b", ACTION_DOWN, 1010),
+		deviceTouchLog("a", ACTION_UP, 1050),
+		deviceTouchLog("b", ACTION_UP, 1060),
+	}}
+
+	demux := &DeviceDemuxProcessor{
+		Source:      source,
+		NewPipeline: NewDeviceISMPipeline(nil),
+	}
+
+	results := make(map[string]*InputEventResult)
+	for _, v := range collectAll(demux) {
+		data := v.(*DeviceData)
+		if res, ok := data.Data.(*InputEventResult); ok {
+			results[data.DeviceId] = res
+		}
+	}
+
+	require.Equal(2, len(results))
+	assert.Equal(TouchScreenEventTap, results["a"].EventType)
+	assert.Equal(TouchScreenEventTap, results["b"].EventType)
+	assert.NotEqual(results["a"].TimestampNs, results["b"].TimestampNs)
+}
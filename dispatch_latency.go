@@ -0,0 +1,219 @@
+package libphonelabgo
+
+import (
+	phonelab "github.com/shaseley/phonelab-go"
+	"sort"
+)
+
+// dispatch_latency.go measures how long input takes to get from the kernel to
+// InputDispatcher. The InputDispatcher logs have the kernel's event time, and
+// the log line has the trace time it was dispatched at. The two are on
+// different monotonic clocks, so this needs the TimeSyncPreprocessor upstream.
+
+// Input types latencies are grouped by. Pointer downs and ups count as downs
+// and ups.
+const (
+	DispatchTypeMotionDown  = "motion_down"
+	DispatchTypeMotionMove  = "motion_move"
+	DispatchTypeMotionUp    = "motion_up"
+	DispatchTypeMotionOther = "motion_other"
+	DispatchTypeKeyDown     = "key_down"
+	DispatchTypeKeyUp       = "key_up"
+)
+
+func motionDispatchType(event *IFMotionEventLog) string {
+	switch event.GetMaskedAction() {
+	case ACTION_DOWN, ACTION_POINTER_DOWN:
+		return DispatchTypeMotionDown
+	case ACTION_MOVE:
+		return DispatchTypeMotionMove
+	case ACTION_UP, ACTION_POINTER_UP:
+		return DispatchTypeMotionUp
+	default:
+		return DispatchTypeMotionOther
+	}
+}
+
+func keyDispatchType(event *IFKeyEventLog) string {
+	if event.Action == KEY_ACTION_DOWN {
+		return DispatchTypeKeyDown
+	}
+	return DispatchTypeKeyUp
+}
+
+// dispatchClock converts dispatch trace times to the event clock.
+type dispatchClock struct {
+	// Add this to sys time to get trace time.
+	offsetNs int64
+	synced   bool
+}
+
+func (clock *dispatchClock) onTimeSync(msg *TimeSyncMsg) {
+	clock.offsetNs = msg.OffsetNs
+	clock.synced = true
+}
+
+// Returns the dispatch latency of an event logged at traceTime, or false if
+// there hasn't been a TimeSyncMsg yet.
+func (clock *dispatchClock) latencyNs(traceTime float64, timestampNs int64) (int64, bool) {
+	if !clock.synced {
+		return 0, false
+	}
+	return int64(traceTime*nsPerSecF) - clock.offsetNs - timestampNs, true
+}
+
+// DispatchLatencySample is the dispatch latency of one InputDispatcher event.
+type DispatchLatencySample struct {
+	Type        string  `json:"type"`
+	TimestampNs int64   `json:"timestamp_ns"`
+	TraceTime   float64 `json:"tracetime"`
+	LatencyNs   int64   `json:"latency_ns"`
+}
+
+func (sample *DispatchLatencySample) MonotonicTimestamp() float64 {
+	if GlobalConf.UseSysTime {
+		return float64(sample.TimestampNs) / nsPerSecF
+	} else {
+		return sample.TraceTime
+	}
+}
+
+type DispatchLatencyStats struct {
+	Events int `json:"events"`
+	// Events before the first TimeSyncMsg, which can't be measured
+	Unsynced int `json:"unsynced"`
+	// Events dispatched before they happened, i.e. the clocks were off.
+	// These aren't in the summaries.
+	Negative int `json:"negative"`
+	// Latency in ms for each type
+	LatencyMs map[string]*MetricSummary `json:"latency_ms"`
+}
+
+// DispatchLatencyAnalyzer summarizes dispatch latencies by type.
+type DispatchLatencyAnalyzer struct {
+	stats    *DispatchLatencyStats
+	sketches map[string]*QuantileSketch
+}
+
+func NewDispatchLatencyAnalyzer() *DispatchLatencyAnalyzer {
+	return &DispatchLatencyAnalyzer{
+		stats:    &DispatchLatencyStats{},
+		sketches: make(map[string]*QuantileSketch),
+	}
+}
+
+func (a *DispatchLatencyAnalyzer) OnSample(sample *DispatchLatencySample) {
+	a.stats.Events += 1
+	if sample.LatencyNs < 0 {
+		a.stats.Negative += 1
+		return
+	}
+
+	sketch, ok := a.sketches[sample.Type]
+	if !ok {
+		sketch = NewQuantileSketch(DefaultSketchAccuracy)
+		a.sketches[sample.Type] = sketch
+	}
+	sketch.Add(float64(sample.LatencyNs) / nsPerMsF)
+}
+
+// Count an event that couldn't be measured.
+func (a *DispatchLatencyAnalyzer) OnUnsynced() {
+	a.stats.Events += 1
+	a.stats.Unsynced += 1
+}
+
+func (a *DispatchLatencyAnalyzer) Stats() *DispatchLatencyStats {
+	a.stats.LatencyMs = make(map[string]*MetricSummary)
+	types := make([]string, 0, len(a.sketches))
+	for t := range a.sketches {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		a.stats.LatencyMs[t] = NewMetricSummary(a.sketches[t])
+	}
+	return a.stats
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// DispatchLatencyProcessor measures the dispatch latency of InputDispatcher
+// motion and key events. Samples are only sent with EmitSamples, since there
+// is one for every move. The DispatchLatencyStats are sent at the end.
+type DispatchLatencyProcessor struct {
+	Source      phonelab.Processor
+	EmitSamples bool
+}
+
+func (proc *DispatchLatencyProcessor) Process() <-chan interface{} {
+	outChan := make(chan interface{})
+
+	go func() {
+		inChan := proc.Source.Process()
+
+		clock := &dispatchClock{}
+		analyzer := NewDispatchLatencyAnalyzer()
+
+		onEvent := func(ll *phonelab.Logline, what string, timestampNs int64) {
+			latencyNs, ok := clock.latencyNs(ll.TraceTime, timestampNs)
+			if !ok {
+				analyzer.OnUnsynced()
+				return
+			}
+			sample := &DispatchLatencySample{
+				Type:        what,
+				TimestampNs: timestampNs,
+				TraceTime:   ll.TraceTime,
+				LatencyNs:   latencyNs,
+			}
+			analyzer.OnSample(sample)
+			if proc.EmitSamples {
+				outChan <- sample
+			}
+		}
+
+		for iLog := range inChan {
+			// The timesync preprocessor sends offsets on their own
+			if msg, ok := iLog.(*TimeSyncMsg); ok {
+				clock.onTimeSync(msg)
+				continue
+			}
+
+			ll, ok := iLog.(*phonelab.Logline)
+			if !ok || ll == nil {
+				continue
+			}
+
+			switch t := ll.Payload.(type) {
+			case *TimeSyncMsg:
+				clock.onTimeSync(t)
+			case *IFMotionEventLog:
+				onEvent(ll, motionDispatchType(t), t.Timestamp)
+			case *IFKeyEventLog:
+				onEvent(ll, keyDispatchType(t), t.Timestamp)
+			}
+		}
+
+		outChan <- analyzer.Stats()
+		close(outChan)
+	}()
+
+	return outChan
+}
+
+type DispatchLatencyProcessorGenerator struct{}
+
+func (g *DispatchLatencyProcessorGenerator) GenerateProcessor(source *phonelab.PipelineSourceInstance,
+	kwargs map[string]interface{}) phonelab.Processor {
+
+	emitSamples := false
+	if v, ok := kwargs["emit_samples"]; ok {
+		emitSamples = v.(bool)
+	}
+
+	return &DispatchLatencyProcessor{
+		Source:      &TimeSyncPreprocessor{Source: source.Processor},
+		EmitSamples: emitSamples,
+	}
+}
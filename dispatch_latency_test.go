@@ -0,0 +1,108 @@
+package libphonelabgo
+
+import (
+	phonelab "github.com/shaseley/phonelab-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// The trace clock is 500ms ahead of the event clock.
+func dispatchTestLogs() []interface{} {
+	motion := func(tsMs int64, action int, traceMs int64) *phonelab.Logline {
+		return &phonelab.Logline{
+			TraceTime: float64(traceMs) / msPerSecF,
+			Payload: &IFMotionEventLog{
+				Timestamp:   tsMs * nsPerMs,
+				Action:      action,
+				PolicyFlags: 0x62000000,
+				PointerData: []*IFPointerData{&IFPointerData{XPos: 500.0, YPos: 500.0}},
+			},
+		}
+	}
+
+	return []interface{}{
+		// Can't be measured yet
+		motion(100, ACTION_DOWN, 610),
+		motion(150, ACTION_UP, 660),
+		&TimeSyncMsg{OffsetNs: 500 * nsPerMs},
+		motion(1000, ACTION_DOWN, 1504),
+		motion(1020, ACTION_MOVE, 1530),
+		motion(1050, ACTION_UP, 1558),
+		&phonelab.Logline{
+			TraceTime: 2.512,
+			Payload: &IFKeyEventLog{
+				Timestamp: 2000 * nsPerMs,
+				Action:    KEY_ACTION_UP,
+				KeyCode:   KEYCODE_BACK,
+			},
+		},
+		// The clocks were off
+		motion(3000, ACTION_DOWN, 3490),
+	}
+}
+
+func TestDispatchLatencyProcessor(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	res := collectAll(&DispatchLatencyProcessor{
+		Source:      &sliceSource{dispatchTestLogs()},
+		EmitSamples: true,
+	})
+	require.Equal(6, len(res))
+
+	sample := res[0].(*DispatchLatencySample)
+	assert.Equal(DispatchTypeMotionDown, sample.Type)
+	assert.Equal(int64(1000*nsPerMs), sample.TimestampNs)
+	assert.InDelta(4*nsPerMs, sample.LatencyNs, 1000)
+	assert.Equal(DispatchTypeMotionMove, res[1].(*DispatchLatencySample).Type)
+	assert.Equal(DispatchTypeKeyUp, res[3].(*DispatchLatencySample).Type)
+	assert.True(res[4].(*DispatchLatencySample).LatencyNs < 0)
+
+	stats := res[5].(*DispatchLatencyStats)
+	assert.Equal(7, stats.Events)
+	assert.Equal(2, stats.Unsynced)
+	assert.Equal(1, stats.Negative)
+	require.Equal(4, len(stats.LatencyMs))
+	assert.Equal(int64(1), stats.LatencyMs[DispatchTypeMotionUp].Count)
+	assert.InDelta(8.0, stats.LatencyMs[DispatchTypeMotionUp].Max, 0.1)
+	assert.InDelta(12.0, stats.LatencyMs[DispatchTypeKeyUp].Max, 0.1)
+
+	// Only the stats without samples
+	res = collectAll(&DispatchLatencyProcessor{Source: &sliceSource{dispatchTestLogs()}})
+	require.Equal(1, len(res))
+	assert.Equal(7, res[0].(*DispatchLatencyStats).Events)
+}
+
+func TestDispatchLatencyInputEvents(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	events := make([]*TouchScreenEvent, 0)
+	for _, r := range collectAll(&InputProcessor{
+		TouchSlop: TouchSlopScaled,
+		Source:    &sliceSource{dispatchTestLogs()},
+	}) {
+		if event, ok := r.(*TouchScreenEvent); ok {
+			events = append(events, event)
+		}
+	}
+	require.Equal(3, len(events))
+
+	// Before the first time sync
+	assert.Equal(int64(0), events[0].DispatchLatencyNs)
+	// From the up
+	assert.InDelta(8*nsPerMs, events[1].DispatchLatencyNs, 1000)
+	assert.Equal(TouchScreenEventKey, events[2].What)
+	assert.InDelta(12*nsPerMs, events[2].DispatchLatencyNs, 1000)
+
+	res := NewInputEventResult(events[1])
+	assert.Equal(events[1].DispatchLatencyNs, res.DispatchLatencyNs)
+	assert.Equal(int64(InvalidResponseDuration), res.DispatchResponseMs())
+	res.LocalResponse.StartNs = res.TimestampNs + 50*nsPerMs
+	assert.Equal(int64(50), res.TouchResponseMs())
+	assert.Equal(int64(42), res.DispatchResponseMs())
+}
@@ -4,35 +4,180 @@ import (
 	"encoding/json"
 	"fmt"
 	phonelab "github.com/shaseley/phonelab-go"
+	"io"
 	"io/ioutil"
 	"os"
 	"sync"
 )
 
+// DumbCollector collects everything it is sent. With the default JSON format,
+// everything is buffered and written as one array in Finish. The JSON Lines
+// and CSV formats stream records out as they arrive instead. CSV files only
+// hold one kind of record, so CSV to stdout (no Filename) takes the first kind
+// it sees and drops the rest.
 type DumbCollector struct {
 	Data            []interface{}
 	CheckFunc       func(interface{}) bool
 	PersistOnFinish bool
 	Filename        string
+
+	// Streaming options
+	Format    string
+	Gzip      bool
+	PerSource bool
+
+	writers map[string]RecordWriter
+	// Keys dropped because they'd be mixed into stdout
+	rejected map[string]bool
+	// Defaults to os.Stdout
+	stdout io.Writer
 	sync.Mutex
 }
 
 func NewDumbCollector() *DumbCollector {
 	return &DumbCollector{
-		Data: make([]interface{}, 0),
+		Data:    make([]interface{}, 0),
+		Format:  OutputFormatJSON,
+		writers: make(map[string]RecordWriter),
 	}
 }
 
+// Create a DumbCollector from yaml args, so it can be used as a pipeline data
+// collector.
+func NewDumbCollectorFromArgs(kwargs map[string]interface{}) *DumbCollector {
+	dc := NewDumbCollector()
+	dc.PersistOnFinish = true
+
+	if v, ok := kwargs["filename"]; ok {
+		dc.Filename, _ = v.(string)
+	}
+
+	if v, ok := kwargs["format"]; ok {
+		if format, ok := v.(string); ok {
+			switch format {
+			case OutputFormatJSON, OutputFormatJSONLines, OutputFormatCSV:
+				dc.Format = format
+			default:
+				fmt.Fprintf(os.Stderr, "Warning: unknown format '%v', using json\n", format)
+			}
+		}
+	}
+
+	if v, ok := kwargs["gzip"]; ok {
+		dc.Gzip, _ = v.(bool)
+	}
+
+	if v, ok := kwargs["per_source"]; ok {
+		dc.PerSource, _ = v.(bool)
+	}
+
+	return dc
+}
+
+func (dc *DumbCollector) streaming() bool {
+	return dc.Format == OutputFormatJSONLines || dc.Format == OutputFormatCSV
+}
+
 func (dc *DumbCollector) OnData(data interface{}, info phonelab.PipelineSourceInfo) {
 	dc.Lock()
 	defer dc.Unlock()
 
-	if dc.CheckFunc == nil || dc.CheckFunc(data) {
+	if dc.CheckFunc != nil && !dc.CheckFunc(data) {
+		return
+	}
+
+	if !dc.streaming() {
 		dc.Data = append(dc.Data, data)
+		return
+	}
+
+	if err := dc.write(data, info); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing data: %v\n", err)
+	}
+}
+
+// Stream a record to the right writer, opening it if necessary.
+func (dc *DumbCollector) write(data interface{}, info phonelab.PipelineSourceInfo) error {
+	source := ""
+	if dc.PerSource && info != nil {
+		source = info.Context()
+	}
+
+	kind := ""
+	if dc.Format == OutputFormatCSV {
+		kind = RecordKind(data)
 	}
+
+	key := source + "\x00" + kind
+
+	w, ok := dc.writers[key]
+	if !ok {
+		if dc.Format == OutputFormatCSV && len(dc.Filename) == 0 && len(dc.writers) > 0 {
+			if dc.rejected[key] {
+				return nil
+			}
+			if dc.rejected == nil {
+				dc.rejected = make(map[string]bool)
+			}
+			dc.rejected[key] = true
+			return fmt.Errorf("CSV output to stdout can only hold one kind of record, dropping %v records. Set a filename to write each kind to its own file.", kind)
+		}
+
+		var err error
+		if w, err = dc.openWriter(source, kind); err != nil {
+			return err
+		}
+		if dc.writers == nil {
+			dc.writers = make(map[string]RecordWriter)
+		}
+		dc.writers[key] = w
+	}
+
+	return w.Write(data)
+}
+
+func (dc *DumbCollector) openWriter(source, kind string) (RecordWriter, error) {
+	var out io.WriteCloser
+
+	if len(dc.Filename) == 0 {
+		stdout := dc.stdout
+		if stdout == nil {
+			stdout = os.Stdout
+		}
+		out = nopWriteCloser{stdout}
+	} else {
+		name := qualifiedFileName(dc.Filename, dc.Gzip, source, kind)
+		file, err := os.Create(name)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating output file: %v", err)
+		}
+		out = file
+	}
+
+	if dc.Gzip {
+		out = newGzipWriteCloser(out)
+	}
+
+	if dc.Format == OutputFormatCSV {
+		return NewCSVWriter(out, kind), nil
+	}
+	return NewJSONLinesWriter(out), nil
 }
 
 func (dc *DumbCollector) Finish() {
+	dc.Lock()
+	defer dc.Unlock()
+
+	if dc.streaming() {
+		for key, w := range dc.writers {
+			if err := w.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error closing output: %v\n", err)
+			}
+			delete(dc.writers, key)
+		}
+		return
+	}
+
 	if dc.PersistOnFinish {
 		if err := dc.DumpJson(dc.Filename); err != nil {
 			fmt.Fprintf(os.Stderr, "Error persisting data: %v\n", err)
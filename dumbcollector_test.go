@@ -0,0 +1,225 @@
+package libphonelabgo
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testSourceInfo struct {
+	context string
+}
+
+func (info *testSourceInfo) Type() string {
+	return "test"
+}
+
+func (info *testSourceInfo) Context() string {
+	return info.context
+}
+
+func TestDumbCollectorArgs(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	dc := NewDumbCollectorFromArgs(map[string]interface{}{
+		"filename":   "out.csv",
+		"format":     "csv",
+		"gzip":       true,
+		"per_source": true,
+	})
+
+	assert.Equal("out.csv", dc.Filename)
+	assert.Equal(OutputFormatCSV, dc.Format)
+	assert.True(dc.Gzip)
+	assert.True(dc.PerSource)
+	assert.True(dc.PersistOnFinish)
+
+	dc = NewDumbCollectorFromArgs(map[string]interface{}{
+		"format": "xml",
+	})
+	assert.Equal(OutputFormatJSON, dc.Format)
+}
+
+func TestDumbCollectorJSONLines(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dumbcollector")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	dc := NewDumbCollectorFromArgs(map[string]interface{}{
+		"filename":   filepath.Join(dir, "out.jsonl"),
+		"format":     "jsonl",
+		"gzip":       true,
+		"per_source": true,
+	})
+
+	a := &testSourceInfo{"/data/device/a.log"}
+	b := &testSourceInfo{"/data/device/b.log"}
+
+	dc.OnData(&Spinner{StartTimeMs: 1, EndTimeMs: 2, DurationMs: 1}, a)
+	dc.OnData(&Spinner{StartTimeMs: 3, EndTimeMs: 5, DurationMs: 2}, a)
+	dc.OnData(&Spinner{StartTimeMs: 4, EndTimeMs: 8, DurationMs: 4}, b)
+	dc.Finish()
+
+	// Nothing is buffered
+	assert.Equal(0, len(dc.Data))
+
+	file, err := os.Open(filepath.Join(dir, "out-a.log-364e6871.jsonl.gz"))
+	require.Nil(err)
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	require.Nil(err)
+
+	scanner := bufio.NewScanner(gz)
+	spinners := make([]*Spinner, 0)
+	for scanner.Scan() {
+		var s *Spinner
+		require.Nil(json.Unmarshal(scanner.Bytes(), &s))
+		spinners = append(spinners, s)
+	}
+
+	require.Equal(2, len(spinners))
+	assert.Equal(int64(3), spinners[1].StartTimeMs)
+
+	_, err = os.Stat(filepath.Join(dir, "out-b.log-4e5ee6d0.jsonl.gz"))
+	assert.Nil(err)
+}
+
+func TestDumbCollectorCSV(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dumbcollector")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	dc := NewDumbCollectorFromArgs(map[string]interface{}{
+		"filename": filepath.Join(dir, "out.csv"),
+		"format":   "csv",
+	})
+
+	info := &testSourceInfo{"a.log"}
+
+	event := &TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 100 * nsPerMs, X: 10, Y: 20}
+	ism := NewInputStateMachine()
+	ism.OnTouchEvent(event)
+
+	dc.OnData(event, info)
+	dc.OnData(ism.Finish(500*nsPerMs), info)
+	dc.OnData(&FrameDiffSample{
+		SFFrameDiff: SFFrameDiff{
+			Timestamp: 120,
+			PctDiff:   1.5,
+			GridEntries: []*GridEntry{
+				&GridEntry{Position: 1, Value: 2.5},
+				&GridEntry{Position: 9, Value: 3},
+			},
+		},
+	}, info)
+	dc.Finish()
+
+	read := func(name string) [][]string {
+		file, err := os.Open(filepath.Join(dir, name))
+		require.Nil(err)
+		defer file.Close()
+		records, err := csv.NewReader(file).ReadAll()
+		require.Nil(err)
+		return records
+	}
+
+	touches := read("out-touch_event.csv")
+	require.Equal(2, len(touches))
+	assert.Equal([]string{"what", "timestamp", "tracetime", "x", "y", "code"}, touches[0])
+	assert.Equal("10", touches[1][3])
+
+	results := read("out-input_event_result.csv")
+	require.Equal(2, len(results))
+	assert.Equal("timestamp_ns", results[0][0])
+	assert.Equal("100000000", results[1][0])
+	assert.Equal("-1", results[1][4])
+
+	diffs := read("out-frame_diff.csv")
+	require.Equal(2, len(diffs))
+	assert.Equal("1:2.5;9:3", diffs[1][7])
+}
+
+func TestDumbCollectorCSVStdout(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dc := NewDumbCollectorFromArgs(map[string]interface{}{
+		"format": "csv",
+	})
+	out := &bytes.Buffer{}
+	dc.stdout = out
+
+	info := &testSourceInfo{"a.log"}
+	tap := &TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 100 * nsPerMs, X: 10, Y: 20}
+
+	// The first kind gets stdout, anything else would be mixed in with it
+	require.Nil(dc.write(tap, info))
+	assert.NotNil(dc.write(&Spinner{StartTimeMs: 1, EndTimeMs: 2}, info))
+	// Only reported once
+	assert.Nil(dc.write(&Spinner{StartTimeMs: 3, EndTimeMs: 4}, info))
+	require.Nil(dc.write(tap, info))
+	dc.Finish()
+
+	records, err := csv.NewReader(out).ReadAll()
+	require.Nil(err)
+	require.Equal(3, len(records))
+	assert.Equal("what", records[0][0])
+}
+
+func TestDumbCollectorSameBaseName(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dumbcollector")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	dc := NewDumbCollectorFromArgs(map[string]interface{}{
+		"filename":   filepath.Join(dir, "out.jsonl"),
+		"format":     "jsonl",
+		"per_source": true,
+	})
+
+	// Per-device logs usually have the same name
+	dc.OnData(&Spinner{StartTimeMs: 1, EndTimeMs: 2}, &testSourceInfo{"/dev1/logcat.gz"})
+	dc.OnData(&Spinner{StartTimeMs: 3, EndTimeMs: 4}, &testSourceInfo{"/dev2/logcat.gz"})
+	dc.Finish()
+
+	for _, name := range []string{"out-logcat-156d5e8a.jsonl", "out-logcat-fa4baeef.jsonl"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		require.Nil(err)
+		lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+		assert.Equal(1, len(lines), name)
+	}
+}
+
+func TestQualifiedFileName(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	assert.Equal("out.json", qualifiedFileName("out.json", false))
+	assert.Equal("out.json.gz", qualifiedFileName("out.json.gz", true))
+	assert.Equal("dir/out-x.log-7bfeefdf-spinner.csv.gz",
+		qualifiedFileName("dir/out.csv", true, "/some/path/x.log", "spinner"))
+	assert.Equal("dir.d/out-a_b", qualifiedFileName("dir.d/out", false, "a b"))
+}
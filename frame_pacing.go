@@ -0,0 +1,408 @@
+package libphonelabgo
+
+import (
+	phonelab "github.com/shaseley/phonelab-go"
+	"math"
+	"sort"
+)
+
+// frame_pacing.go analyses the SurfaceFlinger frame times. Since we know
+// exactly when each frame was presented, we can infer the display refresh
+// (vsync) period and express each inter-frame delta as a number of missed
+// vsyncs, which is a much better jank measure than a fixed threshold.
+
+// Frame jank classes
+const (
+	FrameJankNone = iota
+	FrameJankSingleDrop
+	FrameJankMultiDrop
+	FrameJankLongStall
+)
+
+// Jank models used by the InputStateMachine
+const (
+	JankModelThreshold = "threshold"
+	JankModelVsync     = "vsync"
+)
+
+const (
+	defaultVsyncPeriodNs = int64(16666667)
+	minVsyncPeriodNs     = int64(4 * nsPerMs)
+	maxVsyncPeriodNs     = int64(50 * nsPerMs)
+)
+
+// FramePacingParams control vsync inference, jank classification and
+// windowed reporting.
+type FramePacingParams struct {
+	// Fixed vsync period. If 0, the period is inferred from the frame times.
+	VsyncPeriodNs int64
+	// Number of recent inter-frame deltas used to infer the vsync period.
+	InferenceFrames int
+	// Minimum number of deltas needed before trusting the inferred period.
+	MinInferenceFrames int
+	// Number of missed vsyncs that make a frame a long stall.
+	LongStallVsyncs int
+	// Inter-frame deltas above this are considered idle screen time rather
+	// than dropped frames. This only applies to windowed reporting.
+	IdleThresholdMs int64
+	// Length of a reporting window, in frame time.
+	WindowMs int64
+	// Whether or not to emit a FrameJank for each janky frame.
+	EmitJank bool
+}
+
+// Create a new FramePacingParams with the default settings.
+func DefaultFramePacingParams() *FramePacingParams {
+	return &FramePacingParams{
+		VsyncPeriodNs:      0,
+		InferenceFrames:    120,
+		MinInferenceFrames: 10,
+		LongStallVsyncs:    6,
+		IdleThresholdMs:    1000,
+		WindowMs:           1000,
+		EmitJank:           true,
+	}
+}
+
+func NewFramePacingParams(kwargs map[string]interface{}) *FramePacingParams {
+	params := DefaultFramePacingParams()
+
+	if v, ok := kwargs["vsync_period_ms"]; ok {
+		switch t := v.(type) {
+		case int:
+			params.VsyncPeriodNs = int64(t) * nsPerMs
+		case float64:
+			params.VsyncPeriodNs = int64(t * nsPerMsF)
+		}
+	}
+
+	if v, ok := kwargs["inference_frames"]; ok {
+		params.InferenceFrames, _ = v.(int)
+	}
+
+	if v, ok := kwargs["long_stall_vsyncs"]; ok {
+		params.LongStallVsyncs, _ = v.(int)
+	}
+
+	if v, ok := kwargs["idle_threshold_ms"]; ok {
+		if t, ok := v.(int); ok {
+			params.IdleThresholdMs = int64(t)
+		}
+	}
+
+	if v, ok := kwargs["window_ms"]; ok {
+		if t, ok := v.(int); ok {
+			params.WindowMs = int64(t)
+		}
+	}
+
+	if v, ok := kwargs["emit_jank"]; ok {
+		params.EmitJank, _ = v.(bool)
+	}
+
+	return params
+}
+
+// FrameJank is a single janky frame, i.e. one that was presented one or more
+// vsyncs later than expected.
+type FrameJank struct {
+	TimestampNs   int64   `json:"timestamp_ns"`
+	DeltaMs       float64 `json:"delta_ms"`
+	VsyncPeriodMs float64 `json:"vsync_period_ms"`
+	MissedVsyncs  int     `json:"missed_vsyncs"`
+	Class         int     `json:"class"`
+}
+
+func (j *FrameJank) MonotonicTimestamp() float64 {
+	return float64(j.TimestampNs) / nsPerSecF
+}
+
+// FramePacingWindow summarizes the frame times over one reporting window.
+type FramePacingWindow struct {
+	StartNs       int64   `json:"start_ns"`
+	EndNs         int64   `json:"end_ns"`
+	NumFrames     int     `json:"num_frames"`
+	VsyncPeriodMs float64 `json:"vsync_period_ms"`
+	FrameTimeP50  float64 `json:"frame_time_p50_ms"`
+	FrameTimeP90  float64 `json:"frame_time_p90_ms"`
+	FrameTimeP95  float64 `json:"frame_time_p95_ms"`
+	FrameTimeP99  float64 `json:"frame_time_p99_ms"`
+	FrameTimeMax  float64 `json:"frame_time_max_ms"`
+	SingleDrops   int     `json:"single_drops"`
+	MultiDrops    int     `json:"multi_drops"`
+	LongStalls    int     `json:"long_stalls"`
+	MissedVsyncs  int     `json:"missed_vsyncs"`
+}
+
+func (w *FramePacingWindow) MonotonicTimestamp() float64 {
+	return float64(w.StartNs) / nsPerSecF
+}
+
+func (w *FramePacingWindow) JankyFrames() int {
+	return w.SingleDrops + w.MultiDrops + w.LongStalls
+}
+
+// FramePacingAnalyzer infers the vsync period and classifies frames. It can be
+// used directly, as the InputStateMachine does, or through the
+// FramePacingProcessor.
+type FramePacingAnalyzer struct {
+	Params *FramePacingParams
+
+	// Vsync inference
+	deltas   []int64
+	next     int
+	periodNs int64
+	dirty    bool
+
+	prevNs int64
+
+	// Current window
+	window       *FramePacingWindow
+	windowDeltas []float64
+}
+
+func NewFramePacingAnalyzer(params *FramePacingParams) *FramePacingAnalyzer {
+	if params == nil {
+		params = DefaultFramePacingParams()
+	}
+	return &FramePacingAnalyzer{
+		Params: params,
+		deltas: make([]int64, 0, params.InferenceFrames),
+	}
+}
+
+// Observe adds an inter-frame delta to the vsync inference. Deltas that can't
+// possibly be a small multiple of a refresh period are ignored.
+func (a *FramePacingAnalyzer) Observe(deltaNs int64) {
+	if deltaNs < minVsyncPeriodNs || deltaNs > 4*maxVsyncPeriodNs || a.Params.InferenceFrames <= 0 {
+		return
+	}
+
+	if len(a.deltas) < a.Params.InferenceFrames {
+		a.deltas = append(a.deltas, deltaNs)
+	} else {
+		a.deltas[a.next] = deltaNs
+		a.next = (a.next + 1) % len(a.deltas)
+	}
+	a.dirty = true
+}
+
+// VsyncPeriodNs returns the configured vsync period, or the inferred one if
+// none was configured. Until enough frames have been seen, this is the
+// 60Hz period.
+func (a *FramePacingAnalyzer) VsyncPeriodNs() int64 {
+	if a.Params.VsyncPeriodNs > 0 {
+		return a.Params.VsyncPeriodNs
+	}
+
+	if len(a.deltas) < a.Params.MinInferenceFrames {
+		return defaultVsyncPeriodNs
+	}
+
+	if a.dirty || a.periodNs == 0 {
+		a.periodNs = inferVsyncPeriod(a.deltas)
+		a.dirty = false
+	}
+	return a.periodNs
+}
+
+// Infer the vsync period from a set of inter-frame deltas. The bulk of frames
+// on a smooth display are presented one period apart, so we take a low
+// percentile as a rough estimate and then average the deltas close to it.
+func inferVsyncPeriod(deltas []int64) int64 {
+	sorted := make([]int64, len(deltas))
+	copy(sorted, deltas)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rough := sorted[len(sorted)/10]
+
+	sum, count := int64(0), int64(0)
+	for _, d := range sorted {
+		if d > rough+rough/4 {
+			break
+		}
+		if d >= rough-rough/4 {
+			sum += d
+			count += 1
+		}
+	}
+
+	period := rough
+	if count > 0 {
+		period = sum / count
+	}
+
+	if period < minVsyncPeriodNs {
+		period = minVsyncPeriodNs
+	} else if period > maxVsyncPeriodNs {
+		period = maxVsyncPeriodNs
+	}
+	return period
+}
+
+// Classify returns the number of vsyncs missed by a frame presented deltaNs
+// after the previous one, along with the jank class.
+func (a *FramePacingAnalyzer) Classify(deltaNs int64) (missed int, class int) {
+	period := a.VsyncPeriodNs()
+
+	missed = int(math.Floor(float64(deltaNs)/float64(period)+0.5)) - 1
+	if missed < 0 {
+		missed = 0
+	}
+
+	switch {
+	case missed == 0:
+		class = FrameJankNone
+	case missed == 1:
+		class = FrameJankSingleDrop
+	case missed < a.Params.LongStallVsyncs:
+		class = FrameJankMultiDrop
+	default:
+		class = FrameJankLongStall
+	}
+	return
+}
+
+// OnFrame updates the analyzer with a new frame presentation time. It returns
+// the jank detail if the frame was janky, and the previous window if this
+// frame started a new one.
+func (a *FramePacingAnalyzer) OnFrame(timestampNs int64) (*FrameJank, *FramePacingWindow) {
+	var jank *FrameJank
+	var done *FramePacingWindow
+
+	if a.window != nil && a.Params.WindowMs > 0 &&
+		timestampNs-a.window.StartNs >= a.Params.WindowMs*nsPerMs {
+
+		done = a.finishWindow()
+	}
+
+	if a.window == nil {
+		a.window = &FramePacingWindow{StartNs: timestampNs}
+		a.windowDeltas = a.windowDeltas[:0]
+	}
+
+	a.window.EndNs = timestampNs
+	a.window.NumFrames += 1
+
+	if a.prevNs > 0 && timestampNs > a.prevNs {
+		deltaNs := timestampNs - a.prevNs
+
+		if a.Params.IdleThresholdMs <= 0 || deltaNs < a.Params.IdleThresholdMs*nsPerMs {
+			a.Observe(deltaNs)
+
+			missed, class := a.Classify(deltaNs)
+			a.windowDeltas = append(a.windowDeltas, float64(deltaNs)/nsPerMsF)
+			a.window.MissedVsyncs += missed
+
+			switch class {
+			case FrameJankSingleDrop:
+				a.window.SingleDrops += 1
+			case FrameJankMultiDrop:
+				a.window.MultiDrops += 1
+			case FrameJankLongStall:
+				a.window.LongStalls += 1
+			}
+
+			if class != FrameJankNone {
+				jank = &FrameJank{
+					TimestampNs:   timestampNs,
+					DeltaMs:       float64(deltaNs) / nsPerMsF,
+					VsyncPeriodMs: float64(a.VsyncPeriodNs()) / nsPerMsF,
+					MissedVsyncs:  missed,
+					Class:         class,
+				}
+			}
+		}
+	}
+	a.prevNs = timestampNs
+
+	return jank, done
+}
+
+// Finish returns the final, partial, window, if there is one.
+func (a *FramePacingAnalyzer) Finish() *FramePacingWindow {
+	if a.window == nil {
+		return nil
+	}
+	return a.finishWindow()
+}
+
+func (a *FramePacingAnalyzer) finishWindow() *FramePacingWindow {
+	w := a.window
+	a.window = nil
+
+	w.VsyncPeriodMs = float64(a.VsyncPeriodNs()) / nsPerMsF
+
+	if len(a.windowDeltas) > 0 {
+		sort.Float64s(a.windowDeltas)
+		w.FrameTimeP50 = percentileSorted(a.windowDeltas, 0.50)
+		w.FrameTimeP90 = percentileSorted(a.windowDeltas, 0.90)
+		w.FrameTimeP95 = percentileSorted(a.windowDeltas, 0.95)
+		w.FrameTimeP99 = percentileSorted(a.windowDeltas, 0.99)
+		w.FrameTimeMax = a.windowDeltas[len(a.windowDeltas)-1]
+	}
+
+	return w
+}
+
+// Nearest-rank percentile of an already sorted slice.
+func percentileSorted(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0.0
+	}
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	} else if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// FramePacingProcessor runs a FramePacingAnalyzer over a FrameRefreshEvent
+// stream and emits FrameJank and FramePacingWindow results.
+type FramePacingProcessor struct {
+	Source phonelab.Processor
+	Params *FramePacingParams
+}
+
+func (proc *FramePacingProcessor) Process() <-chan interface{} {
+	outChan := make(chan interface{})
+	inChan := proc.Source.Process()
+
+	go func() {
+		analyzer := NewFramePacingAnalyzer(proc.Params)
+
+		for iLog := range inChan {
+			if event, ok := iLog.(*FrameRefreshEvent); ok && event != nil {
+				jank, window := analyzer.OnFrame(event.SysTimeNs)
+				if window != nil {
+					outChan <- window
+				}
+				if jank != nil && proc.Params.EmitJank {
+					outChan <- jank
+				}
+			}
+		}
+
+		if window := analyzer.Finish(); window != nil {
+			outChan <- window
+		}
+		close(outChan)
+	}()
+
+	return outChan
+}
+
+type FramePacingProcessorGenerator struct{}
+
+func (g *FramePacingProcessorGenerator) GenerateProcessor(source *phonelab.PipelineSourceInstance,
+	kwargs map[string]interface{}) phonelab.Processor {
+
+	return &FramePacingProcessor{
+		Source: source.Processor,
+		Params: NewFramePacingParams(kwargs),
+	}
+}
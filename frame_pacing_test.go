@@ -0,0 +1,149 @@
+package libphonelabgo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestInferVsyncPeriod(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	analyzer := NewFramePacingAnalyzer(nil)
+
+	// Not enough frames yet, default to 60Hz
+	assert.Equal(defaultVsyncPeriodNs, analyzer.VsyncPeriodNs())
+
+	// 90Hz display, with some dropped frames mixed in
+	period := int64(11111111)
+	for i := 0; i < 100; i++ {
+		delta := period + int64(i%5)*10000
+		if i%7 == 0 {
+			delta = 2 * period
+		}
+		analyzer.Observe(delta)
+	}
+
+	inferred := analyzer.VsyncPeriodNs()
+	assert.InDelta(float64(period), float64(inferred), float64(100000))
+}
+
+func TestFramePacingClassify(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	params := DefaultFramePacingParams()
+	params.VsyncPeriodNs = 16 * nsPerMs
+	params.LongStallVsyncs = 4
+	analyzer := NewFramePacingAnalyzer(params)
+
+	tests := []struct {
+		deltaMs int64
+		missed  int
+		class   int
+	}{
+		{10, 0, FrameJankNone},
+		{16, 0, FrameJankNone},
+		{20, 0, FrameJankNone},
+		{32, 1, FrameJankSingleDrop},
+		{48, 2, FrameJankMultiDrop},
+		{64, 3, FrameJankMultiDrop},
+		{80, 4, FrameJankLongStall},
+		{500, 30, FrameJankLongStall},
+	}
+
+	for _, test := range tests {
+		missed, class := analyzer.Classify(test.deltaMs * nsPerMs)
+		assert.Equal(test.missed, missed, "delta %v", test.deltaMs)
+		assert.Equal(test.class, class, "delta %v", test.deltaMs)
+	}
+}
+
+func TestFramePacingWindows(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	params := DefaultFramePacingParams()
+	params.VsyncPeriodNs = 16 * nsPerMs
+	params.WindowMs = 1000
+	analyzer := NewFramePacingAnalyzer(params)
+
+	jank := make([]*FrameJank, 0)
+	windows := make([]*FramePacingWindow, 0)
+
+	deltas := []int64{16, 16, 32, 16, 48, 16, 120, 16, 5000, 16, 16}
+	ts := int64(1000) * nsPerMs
+
+	for i := -1; i < len(deltas); i++ {
+		if i >= 0 {
+			ts += deltas[i] * nsPerMs
+		}
+		j, w := analyzer.OnFrame(ts)
+		if j != nil {
+			jank = append(jank, j)
+		}
+		if w != nil {
+			windows = append(windows, w)
+		}
+	}
+
+	if w := analyzer.Finish(); w != nil {
+		windows = append(windows, w)
+	}
+
+	// The 5s delta is idle time, not jank
+	require.Equal(3, len(jank))
+	assert.Equal(FrameJankSingleDrop, jank[0].Class)
+	assert.Equal(FrameJankMultiDrop, jank[1].Class)
+	assert.Equal(FrameJankLongStall, jank[2].Class)
+	assert.Equal(7, jank[2].MissedVsyncs)
+
+	require.Equal(2, len(windows))
+	assert.Equal(9, windows[0].NumFrames)
+	assert.Equal(1, windows[0].SingleDrops)
+	assert.Equal(1, windows[0].MultiDrops)
+	assert.Equal(1, windows[0].LongStalls)
+	assert.Equal(3, windows[0].JankyFrames())
+	assert.Equal(16.0, windows[0].FrameTimeP50)
+	assert.Equal(120.0, windows[0].FrameTimeMax)
+
+	// The window after the idle period only has the short deltas
+	assert.Equal(3, windows[1].NumFrames)
+	assert.Equal(0, windows[1].JankyFrames())
+	assert.Equal(16.0, windows[1].FrameTimeMax)
+}
+
+func TestISMVsyncJank(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ism := NewInputStateMachine()
+	ism.Params.JankModel = JankModelVsync
+	ism.Params.JankOnFrameUpdate = true
+	ism.Params.VsyncPeriodNs = 16 * nsPerMs
+
+	res := ism.OnTouchEvent(&TouchScreenEvent{
+		What:      TouchScreenEventTap,
+		Timestamp: 100 * nsPerMs,
+	})
+	require.Nil(res)
+
+	for _, ms := range []int64{110, 126, 142, 174, 190, 350} {
+		ism.OnFrameRefresh(&FrameRefreshEvent{SysTimeNs: ms * nsPerMs})
+	}
+
+	res = ism.Finish(400 * nsPerMs)
+	require.NotNil(res)
+	require.Equal(2, len(res.Jank))
+
+	assert.Equal(int64(174*nsPerMs), res.Jank[0].TimestampNs)
+	assert.Equal(1, res.Jank[0].MissedVsyncs)
+	assert.Equal(FrameJankSingleDrop, res.Jank[0].Class)
+
+	assert.Equal(int64(160), res.Jank[1].JankAmount)
+	assert.Equal(9, res.Jank[1].MissedVsyncs)
+	assert.Equal(FrameJankLongStall, res.Jank[1].Class)
+}
@@ -1,7 +1,6 @@
 package libphonelabgo
 
 import (
-	"fmt"
 	phonelab "github.com/shaseley/phonelab-go"
 )
 
@@ -33,21 +32,45 @@ func (emitter *FrameRefreshEmitter) Process() <-chan interface{} {
 		// Add this to diff timestamps to get trace timestamp.
 		curOffsetNs := int64(0)
 		prevToken := int64(-1)
+		lastTs := int64(0)
+
+		var pendingGap *DataGap
 
 		for iLog := range inChan {
+			// The timesync preprocessor sends offsets on their own
+			if msg, ok := iLog.(*TimeSyncMsg); ok {
+				curOffsetNs = msg.OffsetNs
+				continue
+			}
+
 			if ll, ok := iLog.(*phonelab.Logline); ok {
 				switch t := ll.Payload.(type) {
 
 				case *SFFrameTimesLog:
 					{
-						// Check token for things that don't look quite right
-						if prevToken >= 0 && prevToken+1 != t.Token {
-							fmt.Printf("Warning: Missing tokens. Prev = %v, New = %v\n", prevToken, t.Token)
+						// Missing tokens means missing frames, which would
+						// otherwise look like one really long frame.
+						if prevToken >= 0 && prevToken+1 != t.Token && pendingGap == nil {
+							pendingGap = &DataGap{
+								Stream:         DataGapStreamFrameTimes,
+								PrevToken:      prevToken,
+								NewToken:       t.Token,
+								StartNs:        lastTs,
+								TraceTimeStart: float64(lastTs+curOffsetNs) / nsPerSecF,
+							}
 						}
 						prevToken = t.Token
 
 						// Unpack
 						for _, sysTs := range t.Times {
+							if pendingGap != nil {
+								pendingGap.EndNs = sysTs
+								pendingGap.TraceTimeEnd = float64(sysTs+curOffsetNs) / nsPerSecF
+								outChan <- pendingGap
+								pendingGap = nil
+							}
+							lastTs = sysTs
+
 							outChan <- &FrameRefreshEvent{
 								SysTimeNs:    sysTs,
 								TraceTimeAdj: float64(sysTs+curOffsetNs) / nsPerSecF,
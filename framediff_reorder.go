@@ -0,0 +1,164 @@
+package libphonelabgo
+
+import (
+	"sort"
+)
+
+// framediff_reorder.go has the reorder/dedup stage used by the
+// FrameDiffEmitter. Diff logs are flushed in batches, and batches can be
+// re-logged or arrive out of order, e.g. around a SurfaceFlinger restart.
+// Downstream algorithms assume monotonic timestamps, so we hold samples for a
+// bounded window and release them in timestamp order.
+
+// A good reorder window, for pipelines that enable it. The stage is off by
+// default.
+const DefaultReorderWindowMs = 500
+
+// FrameDiffStreamStats reports what the FrameDiffEmitter had to fix up. It is
+// sent once, at the end of the stream, if the reorder stage is enabled.
+type FrameDiffStreamStats struct {
+	Samples          int `json:"samples"`
+	Emitted          int `json:"emitted"`
+	Reordered        int `json:"reordered"`
+	Duplicates       int `json:"duplicates"`
+	LateDropped      int `json:"late_dropped"`
+	DuplicateBatches int `json:"duplicate_batches"`
+	Restarts         int `json:"restarts"`
+	Gaps             int `json:"gaps"`
+}
+
+type frameDiffReorderBuffer struct {
+	windowMs int64
+
+	// Held samples, sorted by timestamp
+	pending   []*FrameDiffSample
+	pendingTs map[int64]bool
+
+	// Recently released timestamps, for telling duplicates from late samples
+	releasedTs    map[int64]bool
+	lastReleaseMs int64
+	maxSeenMs     int64
+
+	stats *FrameDiffStreamStats
+}
+
+func newFrameDiffReorderBuffer(windowMs int64, stats *FrameDiffStreamStats) *frameDiffReorderBuffer {
+	return &frameDiffReorderBuffer{
+		windowMs:      windowMs,
+		pending:       make([]*FrameDiffSample, 0),
+		pendingTs:     make(map[int64]bool),
+		releasedTs:    make(map[int64]bool),
+		lastReleaseMs: -1,
+		stats:         stats,
+	}
+}
+
+// For a batch whose token went backwards: SurfaceFlinger restarted if the
+// batch has samples newer than anything we've seen. The clock keeps going
+// across a restart, but a re-logged or out of order batch only has old
+// samples.
+func (buf *frameDiffReorderBuffer) isRestart(log *SFFrameDiffLog) bool {
+	for _, diff := range log.Diffs {
+		if diff.Timestamp > buf.maxSeenMs {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a batch is a re-log, i.e. it has samples and we've seen all of them.
+func (buf *frameDiffReorderBuffer) isDuplicate(log *SFFrameDiffLog) bool {
+	if len(log.Diffs) == 0 {
+		return false
+	}
+	for _, diff := range log.Diffs {
+		if !buf.pendingTs[diff.Timestamp] && !buf.releasedTs[diff.Timestamp] {
+			return false
+		}
+	}
+	return true
+}
+
+// Add a sample to the buffer. Duplicates and samples that show up after we've
+// already released newer ones are dropped.
+func (buf *frameDiffReorderBuffer) push(sample *FrameDiffSample) {
+	buf.stats.Samples += 1
+	ts := sample.Timestamp
+
+	if buf.pendingTs[ts] || buf.releasedTs[ts] {
+		buf.stats.Duplicates += 1
+		return
+	}
+
+	if ts <= buf.lastReleaseMs {
+		buf.stats.LateDropped += 1
+		return
+	}
+
+	if ts > buf.maxSeenMs {
+		buf.maxSeenMs = ts
+	}
+
+	// Common case: in order
+	n := len(buf.pending)
+	if n == 0 || buf.pending[n-1].Timestamp < ts {
+		buf.pending = append(buf.pending, sample)
+	} else {
+		buf.stats.Reordered += 1
+		i := sort.Search(n, func(i int) bool { return buf.pending[i].Timestamp > ts })
+		buf.pending = append(buf.pending, nil)
+		copy(buf.pending[i+1:], buf.pending[i:])
+		buf.pending[i] = sample
+	}
+	buf.pendingTs[ts] = true
+}
+
+// Release the samples that are older than the window.
+func (buf *frameDiffReorderBuffer) pop() []*FrameDiffSample {
+	i := 0
+	for i < len(buf.pending) && buf.pending[i].Timestamp <= buf.maxSeenMs-buf.windowMs {
+		i += 1
+	}
+	return buf.release(i)
+}
+
+// Release everything.
+func (buf *frameDiffReorderBuffer) flush() []*FrameDiffSample {
+	return buf.release(len(buf.pending))
+}
+
+// Release everything and start over, so nothing after a restart is taken for
+// a late sample or duplicate from before it.
+func (buf *frameDiffReorderBuffer) reset() []*FrameDiffSample {
+	res := buf.flush()
+	buf.releasedTs = make(map[int64]bool)
+	buf.lastReleaseMs = -1
+	buf.maxSeenMs = 0
+	return res
+}
+
+func (buf *frameDiffReorderBuffer) release(count int) []*FrameDiffSample {
+	if count == 0 {
+		return nil
+	}
+
+	res := make([]*FrameDiffSample, count)
+	copy(res, buf.pending[:count])
+	buf.pending = buf.pending[count:]
+
+	for _, sample := range res {
+		delete(buf.pendingTs, sample.Timestamp)
+		buf.releasedTs[sample.Timestamp] = true
+	}
+	buf.lastReleaseMs = res[count-1].Timestamp
+	buf.stats.Emitted += count
+
+	// Only remember released timestamps within the window
+	for ts := range buf.releasedTs {
+		if ts < buf.lastReleaseMs-buf.windowMs {
+			delete(buf.releasedTs, ts)
+		}
+	}
+
+	return res
+}
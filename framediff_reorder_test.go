@@ -0,0 +1,158 @@
+package libphonelabgo
+
+import (
+	phonelab "github.com/shaseley/phonelab-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func runReorderTest(t *testing.T, windowMs int64, logs ...interface{}) ([]int64, *FrameDiffStreamStats) {
+	emitter := &FrameDiffEmitter{
+		Source:          &sliceSource{logs},
+		ReorderWindowMs: windowMs,
+	}
+
+	timestamps := make([]int64, 0)
+	var stats *FrameDiffStreamStats
+
+	for _, log := range collectAll(emitter) {
+		switch typed := log.(type) {
+		case *FrameDiffSample:
+			timestamps = append(timestamps, typed.Timestamp)
+		case *FrameDiffStreamStats:
+			require.Nil(t, stats)
+			stats = typed
+		}
+	}
+
+	require.NotNil(t, stats)
+	return timestamps, stats
+}
+
+func TestFrameDiffReorder(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	timestamps, stats := runReorderTest(t, 500,
+		diffLogline(1, 100, 300, 200),
+		diffLogline(2, 250, 400),
+		diffLogline(3, 1000, 1100),
+	)
+
+	assert.Equal([]int64{100, 200, 250, 300, 400, 1000, 1100}, timestamps)
+	assert.Equal(7, stats.Samples)
+	assert.Equal(7, stats.Emitted)
+	assert.Equal(2, stats.Reordered)
+	assert.Equal(0, stats.Duplicates)
+}
+
+func TestFrameDiffDedup(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	timestamps, stats := runReorderTest(t, 500,
+		diffLogline(1, 100, 200, 200),
+		diffLogline(2, 300, 400),
+		// Re-logged batch
+		diffLogline(2, 300, 400),
+		diffLogline(3, 1000, 1100),
+		// Way too late to fix
+		diffLogline(4, 150, 1200),
+	)
+
+	assert.Equal([]int64{100, 200, 300, 400, 1000, 1100, 1200}, timestamps)
+	assert.Equal(3, stats.Duplicates)
+	assert.Equal(1, stats.DuplicateBatches)
+	assert.Equal(1, stats.LateDropped)
+	assert.Equal(0, stats.Restarts)
+	assert.Equal(0, stats.Gaps)
+}
+
+func TestFrameDiffRestart(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	logs := []interface{}{
+		diffLogline(500, 100, 200),
+		diffLogline(501, 300),
+		// SurfaceFlinger restarted, tokens start over
+		diffLogline(0, 5000, 5100),
+		diffLogline(1, 5200),
+	}
+
+	timestamps, stats := runReorderTest(t, 500, logs...)
+
+	assert.Equal([]int64{100, 200, 300, 5000, 5100, 5200}, timestamps)
+	assert.Equal(1, stats.Restarts)
+	assert.Equal(0, stats.Gaps)
+	assert.Equal(0, stats.DuplicateBatches)
+}
+
+func TestFrameDiffReorderDisabled(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	emitter := &FrameDiffEmitter{
+		Source: &sliceSource{[]interface{}{
+			diffLogline(1, 100, 300, 200),
+		}},
+	}
+
+	timestamps := make([]int64, 0)
+	for _, log := range collectAll(emitter) {
+		_, isStats := log.(*FrameDiffStreamStats)
+		assert.False(isStats)
+
+		if sample, ok := log.(*FrameDiffSample); ok {
+			timestamps = append(timestamps, sample.Timestamp)
+		}
+	}
+
+	assert.Equal([]int64{100, 300, 200}, timestamps)
+}
+
+func TestFrameDiffReorderDefaultOff(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	emitter := (&FrameDiffEmitterGenerator{}).GenerateProcessor(
+		&phonelab.PipelineSourceInstance{}, map[string]interface{}{}).(*FrameDiffEmitter)
+	assert.Equal(int64(0), emitter.ReorderWindowMs)
+
+	emitter = (&FrameDiffEmitterGenerator{}).GenerateProcessor(&phonelab.PipelineSourceInstance{},
+		map[string]interface{}{"reorder_window_ms": DefaultReorderWindowMs}).(*FrameDiffEmitter)
+	assert.Equal(int64(DefaultReorderWindowMs), emitter.ReorderWindowMs)
+
+	// Pipelines built in code, e.g. device_ism, read the same kwargs
+	assert.Equal(int64(0), NewFrameDiffEmitter(nil, nil).ReorderWindowMs)
+	emitter = NewFrameDiffEmitter(nil, map[string]interface{}{"reorder_window_ms": 100, "interlace": 50})
+	assert.Equal(int64(100), emitter.ReorderWindowMs)
+	assert.Equal(int64(50), emitter.InterlaceZerosMs)
+}
+
+func TestFrameDiffRestartVsStale(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	timestamps, stats := runReorderTest(t, 500,
+		diffLogline(500, 100, 200),
+		diffLogline(501, 300),
+		// Empty, so neither a restart nor a duplicate
+		diffLogline(499),
+		// Out of order, but still in the window
+		diffLogline(498, 50, 250),
+		diffLogline(0, 5000, 5100),
+		// Re-logged after the restart
+		diffLogline(0, 5000, 5100),
+		diffLogline(1, 5200),
+	)
+
+	assert.Equal([]int64{50, 100, 200, 250, 300, 5000, 5100, 5200}, timestamps)
+	assert.Equal(1, stats.Restarts)
+	assert.Equal(1, stats.DuplicateBatches)
+	assert.Equal(2, stats.Duplicates)
+	assert.Equal(0, stats.LateDropped)
+	assert.Equal(2, stats.Reordered)
+	assert.Equal(0, stats.Gaps)
+}
@@ -12,16 +12,33 @@ type FrameDiffEmitterGenerator struct{}
 func (g *FrameDiffEmitterGenerator) GenerateProcessor(source *phonelab.PipelineSourceInstance,
 	kwargs map[string]interface{}) phonelab.Processor {
 
+	return NewFrameDiffEmitter(source.Processor, kwargs)
+}
+
+// Create a FrameDiffEmitter from the framediffs kwargs, so pipelines built in
+// code behave the same as the generator.
+func NewFrameDiffEmitter(source phonelab.Processor, kwargs map[string]interface{}) *FrameDiffEmitter {
 	interlace := 0
 	if val, ok := kwargs["interlace"]; ok {
 		if interlace, ok = val.(int); !ok {
-			fmt.Printf("Warning: wrong type for 'interlace' (*T)\n", interlace)
+			fmt.Printf("Warning: wrong type for 'interlace' (%T)\n", val)
+		}
+	}
+
+	// Off unless asked for, since it delays samples and adds the stats to
+	// the stream. DefaultReorderWindowMs is a good value.
+	reorderWindow := 0
+	if val, ok := kwargs["reorder_window_ms"]; ok {
+		if reorderWindow, ok = val.(int); !ok {
+			fmt.Printf("Warning: wrong type for 'reorder_window_ms' (%T)\n", val)
+			reorderWindow = 0
 		}
 	}
 
 	return &FrameDiffEmitter{
-		Source:           source.Processor,
+		Source:           source,
 		InterlaceZerosMs: int64(interlace),
+		ReorderWindowMs:  int64(reorderWindow),
 	}
 }
 
@@ -39,10 +56,12 @@ func (sample *FrameDiffSample) MonotonicTimestamp() float64 {
 	}
 }
 
-// State tracker/unpacker
+// State tracker/unpacker. If ReorderWindowMs > 0, samples are held for up to
+// that long so they can be sorted and de-duplicated before being sent.
 type FrameDiffEmitter struct {
 	Source           phonelab.Processor
 	InterlaceZerosMs int64
+	ReorderWindowMs  int64
 }
 
 func (emitter *FrameDiffEmitter) Process() <-chan interface{} {
@@ -60,57 +79,133 @@ func (emitter *FrameDiffEmitter) Process() <-chan interface{} {
 
 		var prevDiff *SFFrameDiff
 
+		// Set when we detect missing tokens, and sent just before the first
+		// sample after the gap.
+		var pendingGap *DataGap
+
+		stats := &FrameDiffStreamStats{}
+		var reorder *frameDiffReorderBuffer
+		if emitter.ReorderWindowMs > 0 {
+			reorder = newFrameDiffReorderBuffer(emitter.ReorderWindowMs, stats)
+		}
+
+		// Interlace zeros if nec., and send a sample.
+		emit := func(newDiff *FrameDiffSample) {
+			// SurfaceFlinger doesn't swap buffers if no new buffers have been commited,
+			// which means we don't always get diffs if the screen hasn't changed.
+			// This adds dummy 0.00 diff entries to help downstream algorithms that expect
+			// all diffs to be in the stream.
+
+			for emitter.InterlaceZerosMs > 0 && lastTsMs > 0 && prevDiff != nil && newDiff.Timestamp-lastTsMs > 2*emitter.InterlaceZerosMs {
+				newTsMs := lastTsMs + emitter.InterlaceZerosMs
+				inserted := &FrameDiffSample{
+					SFFrameDiff: SFFrameDiff{
+						Timestamp: newTsMs,
+						PctDiff:   float64(0.0),
+						HasColor:  prevDiff.HasColor,
+						Mode:      prevDiff.Mode,
+					},
+					TraceTimeAdj: adjustTimestampMsToS(newTsMs, curOffsetMs),
+					Inserted:     true,
+				}
+				lastTsMs = newTsMs
+				outChan <- inserted
+			}
+
+			lastTsMs = newDiff.Timestamp
+
+			outChan <- newDiff
+
+			prevDiff = &newDiff.SFFrameDiff
+		}
+
+		emitAll := func(samples []*FrameDiffSample) {
+			for _, sample := range samples {
+				emit(sample)
+			}
+		}
+
 		for iLog := range inChan {
+			// The timesync preprocessor sends offsets on their own
+			if msg, ok := iLog.(*TimeSyncMsg); ok {
+				curOffsetMs = msg.OffsetNs / nsPerMs
+				continue
+			}
+
 			if ll, ok := iLog.(*phonelab.Logline); ok {
 				switch t := ll.Payload.(type) {
 
 				case *SFFrameDiffLog:
 					{
-						// Check token for things that don't look quite right
-						if prevToken >= 0 && prevToken+1 != t.Token {
-							fmt.Printf("Warning: Missing tokens. Prev = %v, New = %v\n", prevToken, t.Token)
+						// A token that goes backwards is either a SurfaceFlinger
+						// restart, which resets the tokens, or a stale batch
+						// that was re-logged or arrived out of order. Stale
+						// samples still go through the reorder stage, which
+						// drops the ones it has seen.
+						staleBatch := false
+
+						if reorder != nil && prevToken >= 0 && t.Token <= prevToken {
+							if reorder.isRestart(t) {
+								stats.Restarts += 1
+								emitAll(reorder.reset())
+								// No interlacing across the restart
+								lastTsMs = 0
+								prevDiff = nil
+							} else {
+								staleBatch = true
+								if reorder.isDuplicate(t) {
+									stats.DuplicateBatches += 1
+								}
+							}
+						} else if prevToken >= 0 && prevToken+1 != t.Token && pendingGap == nil {
+							// Missing tokens means missing diffs. We can't say
+							// anything about the screen during that time, so
+							// don't pretend the stream is contiguous.
+							if reorder != nil {
+								emitAll(reorder.flush())
+							}
+							pendingGap = &DataGap{
+								Stream:         DataGapStreamFrameDiffs,
+								PrevToken:      prevToken,
+								NewToken:       t.Token,
+								StartNs:        lastTsMs * nsPerMs,
+								TraceTimeStart: adjustTimestampMsToS(lastTsMs, curOffsetMs),
+							}
+							stats.Gaps += 1
+							// No interlacing across the gap
+							lastTsMs = 0
+							prevDiff = nil
+						}
+
+						if !staleBatch {
+							prevToken = t.Token
 						}
-						prevToken = t.Token
 
-						// Unpack, adjust timestamps, interlace zeros if nec., and send each entry
+						// Unpack, adjust timestamps, and send (or buffer) each entry
 						for _, diff := range t.Diffs {
 
+							if pendingGap != nil {
+								pendingGap.EndNs = diff.TimestampNs()
+								pendingGap.TraceTimeEnd = adjustTimestampMsToS(diff.Timestamp, curOffsetMs)
+								outChan <- pendingGap
+								pendingGap = nil
+							}
+
 							newDiff := &FrameDiffSample{
 								SFFrameDiff:  *diff,
 								TraceTimeAdj: adjustTimestampMsToS(diff.Timestamp, curOffsetMs),
 								Inserted:     false,
 							}
 
-							// SurfaceFlinger doesn't swap buffers if no new buffers have been commited,
-							// which means we don't always get diffs if the screen hasn't changed.
-							// This adds dummy 0.00 diff entries to help downstream algorithms that expect
-							// all diffs to be in the stream.
-
-							for emitter.InterlaceZerosMs > 0 && lastTsMs > 0 && prevDiff != nil && newDiff.Timestamp-lastTsMs > 2*emitter.InterlaceZerosMs {
-								newTsMs := lastTsMs + emitter.InterlaceZerosMs
-								inserted := &FrameDiffSample{
-									SFFrameDiff: SFFrameDiff{
-										Timestamp: newTsMs,
-										PctDiff:   float64(0.0),
-										HasColor:  prevDiff.HasColor,
-										Mode:      prevDiff.Mode,
-									},
-									TraceTimeAdj: adjustTimestampMsToS(newTsMs, curOffsetMs),
-									Inserted:     true,
-								}
-								lastTsMs = newTsMs
-								outChan <- inserted
-							}
-
-							lastTsMs = newDiff.Timestamp
-
-							outChan <- &FrameDiffSample{
-								SFFrameDiff:  *diff,
-								TraceTimeAdj: adjustTimestampMsToS(diff.Timestamp, curOffsetMs),
-								Inserted:     false,
+							if reorder != nil {
+								reorder.push(newDiff)
+							} else {
+								emit(newDiff)
 							}
+						}
 
-							prevDiff = diff
+						if reorder != nil {
+							emitAll(reorder.pop())
 						}
 					}
 				case *TimeSyncMsg:
@@ -121,6 +216,12 @@ func (emitter *FrameDiffEmitter) Process() <-chan interface{} {
 				}
 			}
 		}
+
+		if reorder != nil {
+			emitAll(reorder.flush())
+			outChan <- stats
+		}
+
 		close(outChan)
 	}()
 
@@ -0,0 +1,318 @@
+package libphonelabgo
+
+import (
+	phonelab "github.com/shaseley/phonelab-go"
+	"math"
+	"sort"
+)
+
+// frustration.go looks for signs that the user got frustrated: tapping the
+// same spot over and over with nothing happening (rage taps), leaving with
+// BACK or HOME while waiting (abandonment), and taps that never got any
+// response (dead taps).
+
+const (
+	FrustrationRageTap     = "rage_tap"
+	FrustrationAbandonment = "abandonment"
+	FrustrationDeadTap     = "dead_tap"
+)
+
+// Keys that leave the current screen.
+func isLeaveKey(code int) bool {
+	return code == KEYCODE_HOME || code == KEYCODE_BACK || code == KEYCODE_APP_SWITCH
+}
+
+type FrustrationLocation struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+type FrustrationSignal struct {
+	Type        string  `json:"type"`
+	TimestampNs int64   `json:"timestamp_ns"`
+	TraceTime   float64 `json:"tracetime"`
+	App         string  `json:"app,omitempty"`
+	// Where the tap was; the first tap for rage taps. Not set for keys.
+	Location *FrustrationLocation `json:"location,omitempty"`
+	// Rage taps: how many taps
+	Taps int `json:"taps,omitempty"`
+	// Abandonment: the key pressed, and what the user was waiting on
+	KeyCode int      `json:"key_code,omitempty"`
+	Spinner *Spinner `json:"spinner,omitempty"`
+	// The result of the input that triggered the signal: the last rage tap,
+	// the dead tap, or the input whose response was abandoned.
+	Result *InputEventResult `json:"result,omitempty"`
+}
+
+func (signal *FrustrationSignal) MonotonicTimestamp() float64 {
+	if GlobalConf.UseSysTime {
+		return float64(signal.TimestampNs) / nsPerSecF
+	} else {
+		return signal.TraceTime
+	}
+}
+
+type FrustrationStats struct {
+	RageTaps     int `json:"rage_taps"`
+	Abandonments int `json:"abandonments"`
+	DeadTaps     int `json:"dead_taps"`
+}
+
+type FrustrationParams struct {
+	// Taps within this long of each other can be rage taps
+	RageTapWindowMs int64
+	// How many taps it takes
+	RageTapMinTaps int
+	// How close to the first tap, in pixels
+	RageTapRadiusPx float64
+}
+
+func DefaultFrustrationParams() *FrustrationParams {
+	return &FrustrationParams{
+		RageTapWindowMs: 1000,
+		RageTapMinTaps:  3,
+		RageTapRadiusPx: 100.0,
+	}
+}
+
+func NewFrustrationParams(kwargs map[string]interface{}) *FrustrationParams {
+	params := DefaultFrustrationParams()
+
+	if v, ok := kwargs["rage_tap_window_ms"]; ok {
+		params.RageTapWindowMs = int64(v.(int))
+	}
+
+	if v, ok := kwargs["rage_tap_min_taps"]; ok {
+		params.RageTapMinTaps = v.(int)
+	}
+
+	if v, ok := kwargs["rage_tap_radius_px"]; ok {
+		switch t := v.(type) {
+		case int:
+			params.RageTapRadiusPx = float64(t)
+		case float64:
+			params.RageTapRadiusPx = t
+		}
+	}
+
+	return params
+}
+
+// DetectFrustration finds frustration signals in the inputs, their results,
+// and the spinners over the same time. App changes are used for the app if the
+// results don't have it. Nothing needs to be sorted. Returns the signals in
+// time order.
+func DetectFrustration(events []*TouchScreenEvent, results []*InputEventResult,
+	spinners []*Spinner, appChanges []*AppChangeEvent, params *FrustrationParams) []*FrustrationSignal {
+
+	if params == nil {
+		params = DefaultFrustrationParams()
+	}
+
+	sortedEvents := make([]*TouchScreenEvent, len(events))
+	copy(sortedEvents, events)
+	sort.SliceStable(sortedEvents, func(i, j int) bool {
+		return sortedEvents[i].Timestamp < sortedEvents[j].Timestamp
+	})
+
+	// Results are matched to their input by timestamp
+	type resultKey struct {
+		what int
+		ts   int64
+	}
+	resultFor := make(map[resultKey]*InputEventResult)
+	for _, res := range results {
+		resultFor[resultKey{res.EventType, res.TimestampNs}] = res
+	}
+
+	timeline := NewAppTimeline(appChanges)
+	appFor := func(ts int64, res *InputEventResult) string {
+		if res != nil && len(res.App) > 0 {
+			return res.App
+		}
+		return timeline.AppAt(ts)
+	}
+
+	signals := make([]*FrustrationSignal, 0)
+
+	// Rage taps: a run of unanswered taps near the same spot
+	rageRun := make([]*TouchScreenEvent, 0)
+	flushRage := func() {
+		if len(rageRun) >= params.RageTapMinTaps {
+			first, last := rageRun[0], rageRun[len(rageRun)-1]
+			res := resultFor[resultKey{last.What, last.Timestamp}]
+			signals = append(signals, &FrustrationSignal{
+				Type:        FrustrationRageTap,
+				TimestampNs: first.Timestamp,
+				TraceTime:   first.TraceTime,
+				App:         appFor(first.Timestamp, res),
+				Location:    &FrustrationLocation{first.X, first.Y},
+				Taps:        len(rageRun),
+				Result:      res,
+			})
+		}
+		rageRun = rageRun[:0]
+	}
+
+	for _, event := range sortedEvents {
+		if event.What != TouchScreenEventTap {
+			continue
+		}
+		res := resultFor[resultKey{event.What, event.Timestamp}]
+
+		// Taps without a result were cut short by the next input, so they
+		// didn't get a response either.
+		if res != nil && res.HasLocalResponse() {
+			flushRage()
+			continue
+		}
+
+		if len(rageRun) > 0 {
+			first, prev := rageRun[0], rageRun[len(rageRun)-1]
+			if event.Timestamp-prev.Timestamp > params.RageTapWindowMs*nsPerMs ||
+				math.Hypot(event.X-first.X, event.Y-first.Y) > params.RageTapRadiusPx {
+				flushRage()
+			}
+		}
+		rageRun = append(rageRun, event)
+	}
+	flushRage()
+
+	// Dead taps: timed out without any response
+	for _, event := range sortedEvents {
+		if event.What != TouchScreenEventTap {
+			continue
+		}
+		res := resultFor[resultKey{event.What, event.Timestamp}]
+		if res == nil || res.HasResponse() || res.FinishType != TapEventFinishTimeout {
+			continue
+		}
+		signals = append(signals, &FrustrationSignal{
+			Type:        FrustrationDeadTap,
+			TimestampNs: event.Timestamp,
+			TraceTime:   event.TraceTime,
+			App:         appFor(event.Timestamp, res),
+			Location:    &FrustrationLocation{event.X, event.Y},
+			Result:      res,
+		})
+	}
+
+	// Abandonment: leaving during a spinner, or while an earlier input was
+	// still waiting on a response. Keys don't end a measurement, so the
+	// response could still have come after.
+	for _, event := range sortedEvents {
+		if event.What != TouchScreenEventKey || !isLeaveKey(event.Code) {
+			continue
+		}
+
+		var spinner *Spinner
+		for _, s := range spinners {
+			if s.StartTimeMs*nsPerMs <= event.Timestamp && event.Timestamp <= s.EndTimeMs*nsPerMs {
+				spinner = s
+				break
+			}
+		}
+
+		var waiting *InputEventResult
+		for _, res := range results {
+			if res.TimestampNs < event.Timestamp && event.Timestamp <= res.FinishNs &&
+				!respondedBy(res, event.Timestamp) &&
+				(waiting == nil || res.TimestampNs > waiting.TimestampNs) {
+				waiting = res
+			}
+		}
+
+		if spinner == nil && waiting == nil {
+			continue
+		}
+
+		signals = append(signals, &FrustrationSignal{
+			Type:        FrustrationAbandonment,
+			TimestampNs: event.Timestamp,
+			TraceTime:   event.TraceTime,
+			App:         appFor(event.Timestamp, waiting),
+			KeyCode:     event.Code,
+			Spinner:     spinner,
+			Result:      waiting,
+		})
+	}
+
+	sort.SliceStable(signals, func(i, j int) bool {
+		return signals[i].TimestampNs < signals[j].TimestampNs
+	})
+
+	return signals
+}
+
+// Whether the result had any response by ts.
+func respondedBy(res *InputEventResult, ts int64) bool {
+	return (res.HasLocalResponse() && res.LocalResponse.StartNs <= ts) ||
+		(res.HasGlobalResponse() && res.GlobalResponse.StartNs <= ts)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// FrustrationProcessor looks for frustration signals in TouchScreenEvents,
+// InputEventResults, Spinners and AppChangeEvents from the same source.
+// Inputs can arrive in any order, so everything is held until the end, then
+// the FrustrationSignals are sent in time order, followed by the
+// FrustrationStats.
+type FrustrationProcessor struct {
+	Source phonelab.Processor
+	Params *FrustrationParams
+}
+
+func (proc *FrustrationProcessor) Process() <-chan interface{} {
+	outChan := make(chan interface{})
+
+	go func() {
+		inChan := proc.Source.Process()
+
+		events := make([]*TouchScreenEvent, 0)
+		results := make([]*InputEventResult, 0)
+		spinners := make([]*Spinner, 0)
+		appChanges := make([]*AppChangeEvent, 0)
+
+		for iLog := range inChan {
+			switch t := iLog.(type) {
+			case *TouchScreenEvent:
+				events = append(events, t)
+			case *InputEventResult:
+				results = append(results, t)
+			case *Spinner:
+				spinners = append(spinners, t)
+			case *AppChangeEvent:
+				appChanges = append(appChanges, t)
+			}
+		}
+
+		stats := &FrustrationStats{}
+		for _, signal := range DetectFrustration(events, results, spinners, appChanges, proc.Params) {
+			switch signal.Type {
+			case FrustrationRageTap:
+				stats.RageTaps += 1
+			case FrustrationAbandonment:
+				stats.Abandonments += 1
+			case FrustrationDeadTap:
+				stats.DeadTaps += 1
+			}
+			outChan <- signal
+		}
+		outChan <- stats
+
+		close(outChan)
+	}()
+
+	return outChan
+}
+
+type FrustrationProcessorGenerator struct{}
+
+func (g *FrustrationProcessorGenerator) GenerateProcessor(source *phonelab.PipelineSourceInstance,
+	kwargs map[string]interface{}) phonelab.Processor {
+
+	return &FrustrationProcessor{
+		Source: source.Processor,
+		Params: NewFrustrationParams(kwargs),
+	}
+}
@@ -0,0 +1,123 @@
+package libphonelabgo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func frustrationTestTap(tsMs int64, x, y float64) *TouchScreenEvent {
+	return &TouchScreenEvent{What: TouchScreenEventTap, Timestamp: tsMs * nsPerMs, X: x, Y: y}
+}
+
+// A result with no response that finished at finishMs.
+func frustrationTestResult(event *TouchScreenEvent, finishMs int64, finishType int) *InputEventResult {
+	res := NewInputEventResult(event)
+	res.FinishNs = finishMs * nsPerMs
+	res.FinishType = finishType
+	return res
+}
+
+func TestDetectFrustrationRageTaps(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	taps := []*TouchScreenEvent{
+		frustrationTestTap(1000, 500, 500),
+		frustrationTestTap(1300, 520, 510),
+		frustrationTestTap(1600, 490, 480),
+		// Too far away
+		frustrationTestTap(1900, 1200, 2000),
+	}
+	results := make([]*InputEventResult, 0)
+	for i, tap := range taps {
+		if i+1 < len(taps) {
+			results = append(results, frustrationTestResult(tap,
+				taps[i+1].Timestamp/nsPerMs, TapEventFinishShortCircuit))
+		}
+	}
+
+	signals := DetectFrustration(taps, results, nil,
+		[]*AppChangeEvent{&AppChangeEvent{TimestampNs: 0, App: "com.example"}}, nil)
+	require.Equal(1, len(signals))
+
+	signal := signals[0]
+	assert.Equal(FrustrationRageTap, signal.Type)
+	assert.Equal(3, signal.Taps)
+	assert.Equal(int64(1000*nsPerMs), signal.TimestampNs)
+	assert.Equal(&FrustrationLocation{500, 500}, signal.Location)
+	assert.Equal("com.example", signal.App)
+	assert.True(signal.Result == results[2])
+
+	// A local response breaks it up
+	results[1].LocalResponse.StartNs = 1400 * nsPerMs
+	signals = DetectFrustration(taps, results, nil, nil, nil)
+	assert.Equal(0, len(signals))
+
+	// Too slow
+	params := DefaultFrustrationParams()
+	params.RageTapWindowMs = 200
+	results[1].LocalResponse.StartNs = InvalidResponseTime
+	signals = DetectFrustration(taps, results, nil, nil, params)
+	assert.Equal(0, len(signals))
+}
+
+func TestDetectFrustrationDeadTapsAndAbandonment(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tap := frustrationTestTap(1000, 500, 500)
+	dead := frustrationTestResult(tap, 6000, TapEventFinishTimeout)
+	dead.App = "com.example"
+
+	back := &TouchScreenEvent{What: TouchScreenEventKey, Timestamp: 3000 * nsPerMs, Code: KEYCODE_BACK}
+	// Not a key that leaves
+	volume := &TouchScreenEvent{What: TouchScreenEventKey, Timestamp: 3500 * nsPerMs, Code: KEYCODE_VOLUME_UP}
+	spinner := &Spinner{StartTimeMs: 7000, EndTimeMs: 9000}
+	home := &TouchScreenEvent{What: TouchScreenEventKey, Timestamp: 8000 * nsPerMs, Code: KEYCODE_HOME}
+
+	signals := DetectFrustration([]*TouchScreenEvent{home, volume, back, tap},
+		[]*InputEventResult{dead}, []*Spinner{spinner}, nil, nil)
+	require.Equal(3, len(signals))
+
+	assert.Equal(FrustrationDeadTap, signals[0].Type)
+	assert.Equal("com.example", signals[0].App)
+	assert.True(signals[0].Result == dead)
+
+	assert.Equal(FrustrationAbandonment, signals[1].Type)
+	assert.Equal(KEYCODE_BACK, signals[1].KeyCode)
+	assert.True(signals[1].Result == dead)
+	assert.Nil(signals[1].Spinner)
+	assert.Nil(signals[1].Location)
+
+	assert.Equal(FrustrationAbandonment, signals[2].Type)
+	assert.Equal(KEYCODE_HOME, signals[2].KeyCode)
+	assert.True(signals[2].Spinner == spinner)
+	assert.Nil(signals[2].Result)
+
+	// Once there's a response, BACK isn't abandoning anything
+	dead.GlobalResponse.StartNs = 2000 * nsPerMs
+	signals = DetectFrustration([]*TouchScreenEvent{back, tap},
+		[]*InputEventResult{dead}, nil, nil, nil)
+	assert.Equal(0, len(signals))
+}
+
+func TestFrustrationProcessor(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tap := frustrationTestTap(1000, 500, 500)
+	res := collectAll(&FrustrationProcessor{
+		Source: &sliceSource{[]interface{}{
+			frustrationTestResult(tap, 6000, TapEventFinishTimeout),
+			tap,
+		}},
+		Params: NewFrustrationParams(map[string]interface{}{}),
+	})
+	require.Equal(2, len(res))
+	assert.Equal(FrustrationDeadTap, res[0].(*FrustrationSignal).Type)
+	assert.Equal(&FrustrationStats{DeadTaps: 1}, res[1])
+}
@@ -0,0 +1,549 @@
+package libphonelabgo
+
+import (
+	"bufio"
+	"fmt"
+	phonelab "github.com/shaseley/phonelab-go"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// heatmap.go renders the frame diff grid as heatmaps. Each grid cell maps to
+// a rectangle on the screen, using the same screenGridProps mapping as the
+// local diff calculations. Note that the last column only partially covers
+// the screen (1440 is not a multiple of 320).
+
+// Heatmap output formats
+const (
+	HeatmapFormatPNG = "png"
+	HeatmapFormatSVG = "svg"
+	HeatmapFormatGIF = "gif"
+)
+
+// How diffs are combined over an interval
+const (
+	HeatmapAggregateMean = "mean"
+	HeatmapAggregateMax  = "max"
+)
+
+const (
+	defaultHeatmapScale  = 0.25
+	heatmapTouchRadiusPx = 60.0
+	heatmapColorLevels   = 64
+	minGIFDelayCs        = 2
+)
+
+// Heatmap is a per-cell grid of values (0-100), with an optional touch point
+// overlay.
+type Heatmap struct {
+	Values [][]float64
+	Label  string
+
+	// Time of the (last) diff, in ns
+	TimestampNs int64
+
+	// Touch overlay, in screen pixels. Hollow if the touch hasn't happened
+	// yet in an animated sequence.
+	Touch       *TouchScreenEvent
+	TouchFuture bool
+
+	props *screenGridProps
+}
+
+func newHeatmap(props *screenGridProps) *Heatmap {
+	values := make([][]float64, props.rows)
+	for i := range values {
+		values[i] = make([]float64, props.cols)
+	}
+	return &Heatmap{
+		Values: values,
+		props:  props,
+	}
+}
+
+// Grid values for a diff. Out of range entries are ignored rather than
+// treated as fatal.
+func heatmapGridValues(diff *SFFrameDiff, props *screenGridProps) [][]float64 {
+	if diff.Grid != nil && diff.Grid.grid != nil {
+		return diff.Grid.grid
+	}
+
+	values := newHeatmap(props).Values
+	for _, entry := range diff.GridEntries {
+		if row, col := props.entryPosToGridPos(entry.Position); row >= 0 && col >= 0 {
+			values[row][col] = entry.Value
+		}
+	}
+	return values
+}
+
+// Create a heatmap of a single diff.
+func NewDiffHeatmap(diff *SFFrameDiff) *Heatmap {
+	props := allScreenGrids[0]
+	hm := newHeatmap(props)
+
+	for row, cols := range heatmapGridValues(diff, props) {
+		copy(hm.Values[row], cols)
+	}
+	hm.TimestampNs = diff.TimestampNs()
+	hm.Label = fmt.Sprintf("%v ms: %.2f%%", diff.Timestamp, diff.PctDiff)
+
+	return hm
+}
+
+// Create a heatmap that combines all diffs in [startNs, endNs].
+func NewAggregateHeatmap(diffs []*FrameDiffSample, startNs, endNs int64, how string) *Heatmap {
+	props := allScreenGrids[0]
+	hm := newHeatmap(props)
+	count := 0
+
+	for _, diff := range diffs {
+		ts := diff.TimestampNs()
+		if ts < startNs || ts > endNs {
+			continue
+		}
+
+		count += 1
+		hm.TimestampNs = ts
+
+		for row, cols := range heatmapGridValues(&diff.SFFrameDiff, props) {
+			for col, v := range cols {
+				if how == HeatmapAggregateMax {
+					hm.Values[row][col] = math.Max(hm.Values[row][col], v)
+				} else {
+					hm.Values[row][col] += v
+				}
+			}
+		}
+	}
+
+	if how != HeatmapAggregateMax && count > 0 {
+		for _, cols := range hm.Values {
+			for col := range cols {
+				cols[col] /= float64(count)
+			}
+		}
+	}
+
+	hm.Label = fmt.Sprintf("%v of %v diffs", how, count)
+
+	return hm
+}
+
+// Create an animated sequence of heatmaps around an input event. Every frame
+// has the touch point overlaid.
+func NewInputHeatmapSequence(event *TouchScreenEvent, diffs []*FrameDiffSample, beforeMs, afterMs int64) []*Heatmap {
+	startNs := event.Timestamp - beforeMs*nsPerMs
+	endNs := event.Timestamp + afterMs*nsPerMs
+
+	frames := make([]*Heatmap, 0)
+	for _, diff := range diffs {
+		ts := diff.TimestampNs()
+		if ts < startNs || ts > endNs {
+			continue
+		}
+
+		hm := NewDiffHeatmap(&diff.SFFrameDiff)
+		hm.Touch = event
+		hm.TouchFuture = ts < event.Timestamp
+		hm.Label = fmt.Sprintf("%+d ms: %.2f%%", (ts-event.Timestamp)/nsPerMs, diff.PctDiff)
+		frames = append(frames, hm)
+	}
+
+	return frames
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Rendering
+
+// Screen rectangle covered by a cell, in screen pixels.
+func (props *screenGridProps) cellRect(row, col int) (x0, y0, x1, y1 float64) {
+	x0 = float64(col) * props.pixelsPerWH
+	y0 = float64(row) * props.pixelsPerWH
+	x1 = math.Min(x0+props.pixelsPerWH, float64(props.screenW))
+	y1 = math.Min(y0+props.pixelsPerWH, float64(props.screenH))
+	return
+}
+
+func heatmapScale(scale float64) float64 {
+	if scale <= 0 {
+		return defaultHeatmapScale
+	}
+	return scale
+}
+
+// Colormap stops, from no change to full change
+var heatmapStops = []color.RGBA{
+	{0x10, 0x0c, 0x2e, 0xff},
+	{0x5c, 0x12, 0x6e, 0xff},
+	{0xba, 0x36, 0x55, 0xff},
+	{0xf6, 0x8d, 0x1e, 0xff},
+	{0xfc, 0xff, 0xa4, 0xff},
+}
+
+var (
+	heatmapGridColor  = color.RGBA{0x80, 0x80, 0x80, 0xff}
+	heatmapTouchColor = color.RGBA{0x00, 0xe0, 0xff, 0xff}
+	heatmapRingColor  = color.RGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+// Map a value in [0, 100] to a color.
+func heatmapColor(v float64) color.RGBA {
+	f := math.Max(0, math.Min(1, v/100.0)) * float64(len(heatmapStops)-1)
+	i := int(f)
+	if i >= len(heatmapStops)-1 {
+		return heatmapStops[len(heatmapStops)-1]
+	}
+	frac := f - float64(i)
+	lo, hi := heatmapStops[i], heatmapStops[i+1]
+	mix := func(a, b uint8) uint8 {
+		return uint8(float64(a) + (float64(b)-float64(a))*frac + 0.5)
+	}
+	return color.RGBA{mix(lo.R, hi.R), mix(lo.G, hi.G), mix(lo.B, hi.B), 0xff}
+}
+
+// The palette used for GIFs: colormap levels followed by the overlay colors.
+func heatmapPalette() color.Palette {
+	palette := make(color.Palette, 0, heatmapColorLevels+3)
+	for i := 0; i < heatmapColorLevels; i++ {
+		palette = append(palette, heatmapColor(float64(i)*100.0/float64(heatmapColorLevels-1)))
+	}
+	return append(palette, heatmapGridColor, heatmapTouchColor, heatmapRingColor)
+}
+
+// Draw the heatmap with set(x, y, c), where c is the colormap level, or one
+// of the overlay colors past the last level.
+func (hm *Heatmap) draw(scale float64, set func(x, y, c int)) (int, int) {
+	props := hm.props
+	w := int(math.Ceil(float64(props.screenW) * scale))
+	h := int(math.Ceil(float64(props.screenH) * scale))
+
+	for row := 0; row < props.rows; row++ {
+		for col := 0; col < props.cols; col++ {
+			x0, y0, x1, y1 := props.cellRect(row, col)
+			level := int(math.Max(0, math.Min(1, hm.Values[row][col]/100.0))*float64(heatmapColorLevels-1) + 0.5)
+
+			px0, py0 := int(x0*scale), int(y0*scale)
+			px1, py1 := int(math.Ceil(x1*scale)), int(math.Ceil(y1*scale))
+			for y := py0; y < py1 && y < h; y++ {
+				for x := px0; x < px1 && x < w; x++ {
+					if x == px0 || y == py0 {
+						set(x, y, heatmapColorLevels)
+					} else {
+						set(x, y, level)
+					}
+				}
+			}
+		}
+	}
+
+	if hm.Touch != nil {
+		cx, cy := hm.Touch.X*scale, hm.Touch.Y*scale
+		r := math.Max(3, heatmapTouchRadiusPx*scale)
+		for y := int(cy - r - 1); y <= int(cy+r+1); y++ {
+			for x := int(cx - r - 1); x <= int(cx+r+1); x++ {
+				if x < 0 || y < 0 || x >= w || y >= h {
+					continue
+				}
+				d := math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy)
+				if d > r {
+					continue
+				}
+				if d > r-1.5 {
+					set(x, y, heatmapColorLevels+2)
+				} else if !hm.TouchFuture {
+					set(x, y, heatmapColorLevels+1)
+				}
+			}
+		}
+	}
+
+	return w, h
+}
+
+// Render the heatmap at the given scale (output pixels per screen pixel).
+func (hm *Heatmap) Image(scale float64) *image.Paletted {
+	scale = heatmapScale(scale)
+	props := hm.props
+	w := int(math.Ceil(float64(props.screenW) * scale))
+	h := int(math.Ceil(float64(props.screenH) * scale))
+
+	img := image.NewPaletted(image.Rect(0, 0, w, h), heatmapPalette())
+	hm.draw(scale, func(x, y, c int) {
+		img.SetColorIndex(x, y, uint8(c))
+	})
+	return img
+}
+
+func (hm *Heatmap) WritePNG(w io.Writer, scale float64) error {
+	return png.Encode(w, hm.Image(scale))
+}
+
+func (hm *Heatmap) WriteSVG(w io.Writer, scale float64) error {
+	scale = heatmapScale(scale)
+	props := hm.props
+	bw := bufio.NewWriter(w)
+
+	width := float64(props.screenW) * scale
+	height := float64(props.screenH) * scale
+
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %v %v">`+"\n",
+		width, height, props.screenW, props.screenH)
+
+	for row := 0; row < props.rows; row++ {
+		for col := 0; col < props.cols; col++ {
+			x0, y0, x1, y1 := props.cellRect(row, col)
+			c := heatmapColor(hm.Values[row][col])
+			fmt.Fprintf(bw, `<rect x="%.0f" y="%.0f" width="%.0f" height="%.0f" fill="#%02x%02x%02x" stroke="#808080" stroke-width="2"><title>%.2f</title></rect>`+"\n",
+				x0, y0, x1-x0, y1-y0, c.R, c.G, c.B, hm.Values[row][col])
+		}
+	}
+
+	if hm.Touch != nil {
+		fill := "#00e0ff"
+		if hm.TouchFuture {
+			fill = "none"
+		}
+		fmt.Fprintf(bw, `<circle cx="%.1f" cy="%.1f" r="%.0f" fill="%v" stroke="#ffffff" stroke-width="6"/>`+"\n",
+			hm.Touch.X, hm.Touch.Y, heatmapTouchRadiusPx, fill)
+	}
+
+	if len(hm.Label) > 0 {
+		fmt.Fprintf(bw, `<text x="20" y="80" font-family="sans-serif" font-size="64" fill="#ffffff">%v</text>`+"\n",
+			svgEscape(hm.Label))
+	}
+
+	fmt.Fprintln(bw, "</svg>")
+	return bw.Flush()
+}
+
+func svgEscape(s string) string {
+	res := make([]rune, 0, len(s))
+	for _, r := range s {
+		switch r {
+		case '<':
+			res = append(res, []rune("&lt;")...)
+		case '>':
+			res = append(res, []rune("&gt;")...)
+		case '&':
+			res = append(res, []rune("&amp;")...)
+		default:
+			res = append(res, r)
+		}
+	}
+	return string(res)
+}
+
+// Write a sequence of heatmaps as an animated GIF. Frame delays follow the
+// diff timestamps, so the animation plays back in real time.
+func WriteHeatmapGIF(w io.Writer, frames []*Heatmap, scale float64) error {
+	if len(frames) == 0 {
+		return fmt.Errorf("No heatmap frames")
+	}
+
+	anim := &gif.GIF{
+		Image: make([]*image.Paletted, 0, len(frames)),
+		Delay: make([]int, 0, len(frames)),
+	}
+
+	for i, frame := range frames {
+		delay := minGIFDelayCs
+		if i+1 < len(frames) {
+			// ns -> centiseconds
+			if cs := int((frames[i+1].TimestampNs - frame.TimestampNs) / (10 * nsPerMs)); cs > delay {
+				delay = cs
+			}
+		} else {
+			delay = 100
+		}
+
+		anim.Image = append(anim.Image, frame.Image(scale))
+		anim.Delay = append(anim.Delay, delay)
+	}
+
+	return gif.EncodeAll(w, anim)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// HeatmapFile is emitted by the InputHeatmapProcessor for each file written.
+type HeatmapFile struct {
+	Path        string `json:"path"`
+	TimestampNs int64  `json:"timestamp_ns"`
+	EventType   int    `json:"event_type"`
+	Frames      int    `json:"frames"`
+}
+
+// InputHeatmapProcessor writes a heatmap around every tap and scroll start.
+// With the GIF format it is an animated sequence, otherwise the diffs in the
+// window are aggregated into a single image.
+type InputHeatmapProcessor struct {
+	Source    phonelab.Processor
+	Dir       string
+	Format    string
+	Aggregate string
+	BeforeMs  int64
+	AfterMs   int64
+	Scale     float64
+}
+
+func (proc *InputHeatmapProcessor) write(event *TouchScreenEvent, diffs []*FrameDiffSample) (*HeatmapFile, error) {
+	name := fmt.Sprintf("heatmap-%v-%v.%v", TouchScreenEventName(event.What), event.Timestamp, proc.Format)
+	path := filepath.Join(proc.Dir, name)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &HeatmapFile{
+		Path:        path,
+		TimestampNs: event.Timestamp,
+		EventType:   event.What,
+	}
+
+	switch proc.Format {
+	case HeatmapFormatGIF:
+		frames := NewInputHeatmapSequence(event, diffs, proc.BeforeMs, proc.AfterMs)
+		res.Frames = len(frames)
+		if len(frames) == 0 {
+			// Still show where the touch was
+			hm := newHeatmap(allScreenGrids[0])
+			hm.Touch = event
+			frames = append(frames, hm)
+		}
+		err = WriteHeatmapGIF(file, frames, proc.Scale)
+	default:
+		hm := NewAggregateHeatmap(diffs, event.Timestamp-proc.BeforeMs*nsPerMs,
+			event.Timestamp+proc.AfterMs*nsPerMs, proc.Aggregate)
+		hm.Touch = event
+		res.Frames = 1
+		if proc.Format == HeatmapFormatSVG {
+			err = hm.WriteSVG(file, proc.Scale)
+		} else {
+			err = hm.WritePNG(file, proc.Scale)
+		}
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return res, err
+}
+
+func (proc *InputHeatmapProcessor) Process() <-chan interface{} {
+	outChan := make(chan interface{})
+
+	go func() {
+		inChan := proc.Source.Process()
+
+		// Recent diffs, and inputs waiting for the window to fill
+		diffs := make([]*FrameDiffSample, 0)
+		pending := make([]*TouchScreenEvent, 0)
+
+		flush := func(nowNs int64, all bool) {
+			remaining := pending[:0]
+			for _, event := range pending {
+				if all || nowNs > event.Timestamp+proc.AfterMs*nsPerMs {
+					if res, err := proc.write(event, diffs); err != nil {
+						fmt.Fprintf(os.Stderr, "Error writing heatmap: %v\n", err)
+					} else {
+						outChan <- res
+					}
+				} else {
+					remaining = append(remaining, event)
+				}
+			}
+			pending = remaining
+
+			// Drop diffs nobody can use anymore
+			oldestNs := nowNs - proc.BeforeMs*nsPerMs
+			for _, event := range pending {
+				if start := event.Timestamp - proc.BeforeMs*nsPerMs; start < oldestNs {
+					oldestNs = start
+				}
+			}
+			i := 0
+			for i < len(diffs) && diffs[i].TimestampNs() < oldestNs {
+				i += 1
+			}
+			diffs = diffs[i:]
+		}
+
+		for iLog := range inChan {
+			switch t := iLog.(type) {
+			case *TouchScreenEvent:
+				if t.What == TouchScreenEventTap || t.What == TouchScreenEventScrollStart ||
+					t.What == TouchScreenEventKeystroke {
+					pending = append(pending, t)
+				}
+			case *FrameDiffSample:
+				diffs = append(diffs, t)
+				flush(t.TimestampNs(), false)
+			}
+		}
+
+		flush(0, true)
+		close(outChan)
+	}()
+
+	return outChan
+}
+
+type InputHeatmapProcessorGenerator struct{}
+
+func (g *InputHeatmapProcessorGenerator) GenerateProcessor(source *phonelab.PipelineSourceInstance,
+	kwargs map[string]interface{}) phonelab.Processor {
+
+	proc := &InputHeatmapProcessor{
+		Source:    source.Processor,
+		Dir:       ".",
+		Format:    HeatmapFormatGIF,
+		Aggregate: HeatmapAggregateMean,
+		BeforeMs:  100,
+		AfterMs:   1000,
+		Scale:     defaultHeatmapScale,
+	}
+
+	if v, ok := kwargs["dir"]; ok {
+		proc.Dir = v.(string)
+	}
+
+	if v, ok := kwargs["format"]; ok {
+		switch format := v.(string); format {
+		case HeatmapFormatGIF, HeatmapFormatPNG, HeatmapFormatSVG:
+			proc.Format = format
+		default:
+			panic(fmt.Sprintf("Unknown heatmap format '%v'", format))
+		}
+	}
+
+	if v, ok := kwargs["aggregate"]; ok {
+		proc.Aggregate = v.(string)
+	}
+
+	if v, ok := kwargs["before_ms"]; ok {
+		proc.BeforeMs = int64(v.(int))
+	}
+
+	if v, ok := kwargs["after_ms"]; ok {
+		proc.AfterMs = int64(v.(int))
+	}
+
+	if v, ok := kwargs["scale"]; ok {
+		switch t := v.(type) {
+		case float64:
+			proc.Scale = t
+		case int:
+			proc.Scale = float64(t)
+		}
+	}
+
+	return proc
+}
@@ -0,0 +1,174 @@
+package libphonelabgo
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"image/gif"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func heatmapTestDiff(tsMs int64, entries ...*GridEntry) *FrameDiffSample {
+	sum := 0.0
+	for _, entry := range entries {
+		sum += entry.Value
+	}
+	return &FrameDiffSample{
+		SFFrameDiff: SFFrameDiff{
+			Timestamp:   tsMs,
+			PctDiff:     sum / 36.0,
+			GridWH:      8,
+			GridEntries: entries,
+		},
+	}
+}
+
+func TestDiffHeatmap(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	// Position 0 is the lower left corner of the SF grid
+	diff := heatmapTestDiff(100, &GridEntry{0, 50.0}, &GridEntry{4, 100.0}, &GridEntry{63, 10.0})
+	hm := NewDiffHeatmap(&diff.SFFrameDiff)
+
+	assert.Equal(8, len(hm.Values))
+	assert.Equal(5, len(hm.Values[0]))
+	assert.Equal(50.0, hm.Values[7][0])
+	assert.Equal(100.0, hm.Values[7][4])
+
+	// Out of range entries are ignored
+	total := 0.0
+	for _, cols := range hm.Values {
+		for _, v := range cols {
+			total += v
+		}
+	}
+	assert.Equal(150.0, total)
+
+	// The last column only covers part of the screen
+	x0, _, x1, _ := hm.props.cellRect(0, 4)
+	assert.Equal(1280.0, x0)
+	assert.Equal(1440.0, x1)
+
+	img := hm.Image(0.25)
+	assert.Equal(360, img.Bounds().Dx())
+	assert.Equal(640, img.Bounds().Dy())
+
+	// Bottom left cell is half way up the colormap, bottom right is the top
+	assert.Equal(heatmapColor(100.0), img.At(350, 600))
+	assert.Equal(heatmapColor(0.0), img.At(40, 40))
+
+	var buf bytes.Buffer
+	assert.Nil(hm.WritePNG(&buf, 0.1))
+	_, err := png.Decode(&buf)
+	assert.Nil(err)
+
+	buf.Reset()
+	assert.Nil(hm.WriteSVG(&buf, 0.1))
+	assert.Equal(40, strings.Count(buf.String(), "<rect"))
+}
+
+func TestAggregateHeatmap(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	diffs := []*FrameDiffSample{
+		heatmapTestDiff(100, &GridEntry{0, 20.0}),
+		heatmapTestDiff(200, &GridEntry{0, 40.0}),
+		heatmapTestDiff(300),
+		heatmapTestDiff(400, &GridEntry{0, 100.0}),
+	}
+
+	hm := NewAggregateHeatmap(diffs, 100*nsPerMs, 300*nsPerMs, HeatmapAggregateMean)
+	assert.Equal(20.0, hm.Values[7][0])
+	assert.Equal(300*nsPerMs, hm.TimestampNs)
+
+	hm = NewAggregateHeatmap(diffs, 0, 1000*nsPerMs, HeatmapAggregateMax)
+	assert.Equal(100.0, hm.Values[7][0])
+}
+
+func TestInputHeatmapSequence(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	event := &TouchScreenEvent{
+		What:      TouchScreenEventTap,
+		Timestamp: 1000 * nsPerMs,
+		X:         720,
+		Y:         1280,
+	}
+
+	diffs := []*FrameDiffSample{
+		heatmapTestDiff(800),
+		heatmapTestDiff(950, &GridEntry{0, 20.0}),
+		heatmapTestDiff(1050, &GridEntry{36, 100.0}),
+		heatmapTestDiff(1500),
+		heatmapTestDiff(2500),
+	}
+
+	frames := NewInputHeatmapSequence(event, diffs, 100, 1000)
+	require.Equal(3, len(frames))
+	assert.True(frames[0].TouchFuture)
+	assert.False(frames[1].TouchFuture)
+
+	// The touch is drawn in the center of the screen
+	img := frames[1].Image(0.25)
+	assert.Equal(heatmapTouchColor, img.At(180, 320))
+
+	var buf bytes.Buffer
+	require.Nil(WriteHeatmapGIF(&buf, frames, 0.1))
+
+	anim, err := gif.DecodeAll(&buf)
+	require.Nil(err)
+	assert.Equal(3, len(anim.Image))
+	assert.Equal([]int{10, 45, 100}, anim.Delay)
+
+	assert.NotNil(WriteHeatmapGIF(&buf, nil, 0.1))
+}
+
+func TestInputHeatmapProcessor(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "heatmaps")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	source := &sliceSource{[]interface{}{
+		heatmapTestDiff(900),
+		&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 1000 * nsPerMs, X: 100, Y: 100},
+		heatmapTestDiff(1100, &GridEntry{60, 50.0}),
+		&TouchScreenEvent{What: TouchScreenEventScroll, Timestamp: 1200 * nsPerMs},
+		heatmapTestDiff(2100),
+		&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 3000 * nsPerMs, X: 100, Y: 100},
+	}}
+
+	proc := &InputHeatmapProcessor{
+		Source:    source,
+		Dir:       dir,
+		Format:    HeatmapFormatPNG,
+		Aggregate: HeatmapAggregateMax,
+		BeforeMs:  100,
+		AfterMs:   1000,
+	}
+
+	res := collectAll(proc)
+	require.Equal(2, len(res))
+
+	for _, r := range res {
+		file := r.(*HeatmapFile)
+		assert.Equal(TouchScreenEventTap, file.EventType)
+
+		f, err := os.Open(file.Path)
+		require.Nil(err)
+		_, err = png.Decode(f)
+		f.Close()
+		assert.Nil(err)
+	}
+}
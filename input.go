@@ -1,12 +1,141 @@
 package libphonelabgo
 
 import (
+	"fmt"
 	phonelab "github.com/shaseley/phonelab-go"
 )
 
+// Where InputProcessor gets key events from. The InputDispatcher logs are the
+// default, but aren't enabled on every device. The framework's
+// KeyEvent-UserAction-QoE logs are only written for keys delivered to apps.
+const (
+	KeySourceDispatcher = iota
+	KeySourceUserAction
+	KeySourceBoth
+)
+
+var keySourceNames = map[string]int{
+	"dispatcher": KeySourceDispatcher,
+	"useraction": KeySourceUserAction,
+	"both":       KeySourceBoth,
+}
+
+// With KeySourceBoth, the same key in both feeds should have timestamps this
+// close. The user action logs only have millisecond resolution.
+const DefaultKeyMatchWindowMs = 100
+
+// KeyEventMismatch is sent when KeySourceBoth sees a key in one feed but not
+// the other. It is only sent once the match window has passed, so it is
+// usually behind the events around it.
+type KeyEventMismatch struct {
+	// The feed that had the key
+	Source    string  `json:"source"`
+	Code      int     `json:"code"`
+	Timestamp int64   `json:"timestamp"`
+	TraceTime float64 `json:"tracetime"`
+}
+
+// keyCrossChecker pairs up key events from the two feeds. The first one seen
+// is emitted, the second is only used to confirm it.
+type keyCrossChecker struct {
+	windowNs int64
+	// Excluded non-human keys are still paired up, so their twin in the other
+	// feed is dropped too, but they aren't reported as mismatches.
+	excludeNonHuman bool
+	// Unmatched keys from each feed
+	pending map[int][]*TouchScreenEvent
+}
+
+func newKeyCrossChecker(windowMs int64, excludeNonHuman bool) *keyCrossChecker {
+	return &keyCrossChecker{
+		windowNs:        windowMs * nsPerMs,
+		excludeNonHuman: excludeNonHuman,
+		pending: map[int][]*TouchScreenEvent{
+			KeySourceDispatcher: make([]*TouchScreenEvent, 0),
+			KeySourceUserAction: make([]*TouchScreenEvent, 0),
+		},
+	}
+}
+
+func keySourceName(source int) string {
+	for name, s := range keySourceNames {
+		if s == source {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// Report anything that can no longer be matched as of ts. Use ts < 0 to
+// flush everything.
+func (kc *keyCrossChecker) expire(ts int64) []*KeyEventMismatch {
+	res := make([]*KeyEventMismatch, 0)
+
+	for _, source := range []int{KeySourceDispatcher, KeySourceUserAction} {
+		keep := kc.pending[source][:0]
+		for _, event := range kc.pending[source] {
+			if ts < 0 || ts-event.Timestamp > kc.windowNs {
+				if event.NonHuman && kc.excludeNonHuman {
+					continue
+				}
+				res = append(res, &KeyEventMismatch{
+					Source:    keySourceName(source),
+					Code:      event.Code,
+					Timestamp: event.Timestamp,
+					TraceTime: event.TraceTime,
+				})
+			} else {
+				keep = append(keep, event)
+			}
+		}
+		kc.pending[source] = keep
+	}
+	return res
+}
+
+// Returns true if the event should be emitted, i.e. it hasn't been seen in
+// the other feed yet, and it (or its twin) isn't excluded.
+func (kc *keyCrossChecker) onKey(source int, event *TouchScreenEvent) bool {
+	other := KeySourceUserAction
+	if source == KeySourceUserAction {
+		other = KeySourceDispatcher
+	}
+
+	for i, cand := range kc.pending[other] {
+		dt := cand.Timestamp - event.Timestamp
+		if cand.Code == event.Code && dt <= kc.windowNs && dt >= -kc.windowNs {
+			kc.pending[other] = append(kc.pending[other][:i], kc.pending[other][i+1:]...)
+			return false
+		}
+	}
+
+	kc.pending[source] = append(kc.pending[source], event)
+	return !(event.NonHuman && kc.excludeNonHuman)
+}
+
+// What to do with input that didn't come from a person (see
+// InputPolicy.NonHuman, and KeyEventUserActionLog.NonHuman for the useraction
+// key source): tag it with TouchScreenEvent.NonHuman and pass it on, or drop
+// it.
+const (
+	NonHumanInputTag = iota
+	NonHumanInputExclude
+)
+
+var nonHumanInputNames = map[string]int{
+	"tag":     NonHumanInputTag,
+	"exclude": NonHumanInputExclude,
+}
+
 type InputProcessor struct {
 	TouchSlop int
 	Source    phonelab.Processor
+	// One of the KeySource constants
+	KeySource int
+	// Only used with KeySourceBoth. Defaults to DefaultKeyMatchWindowMs.
+	KeyMatchWindowMs int64
+	// One of the NonHumanInput constants
+	NonHumanInput int
 }
 
 func (p *InputProcessor) Process() <-chan interface{} {
@@ -17,34 +146,101 @@ func (p *InputProcessor) Process() <-chan interface{} {
 
 		detector := NewGestureDetector(p.TouchSlop)
 
+		windowMs := p.KeyMatchWindowMs
+		if windowMs <= 0 {
+			windowMs = DefaultKeyMatchWindowMs
+		}
+		checker := newKeyCrossChecker(windowMs, p.NonHumanInput == NonHumanInputExclude)
+
+		// Whether any part of the current gesture was non-human
+		gestureNonHuman := false
+
+		// Only set if there's a TimeSyncPreprocessor upstream
+		clock := &dispatchClock{}
+		dispatchLatencyNs := func(ll *phonelab.Logline, timestampNs int64) int64 {
+			if latencyNs, ok := clock.latencyNs(ll.TraceTime, timestampNs); ok && latencyNs > 0 {
+				return latencyNs
+			}
+			return 0
+		}
+
+		onKey := func(source int, event *TouchScreenEvent) {
+			switch p.KeySource {
+			case source:
+				if !event.NonHuman || p.NonHumanInput != NonHumanInputExclude {
+					outChan <- event
+				}
+			case KeySourceBoth:
+				for _, m := range checker.expire(event.Timestamp) {
+					outChan <- m
+				}
+				if checker.onKey(source, event) {
+					outChan <- event
+				}
+			}
+		}
+
 		for raw := range inChan {
-			if log, ok := raw.(*phonelab.Logline); ok && log != nil {
+			if msg, ok := raw.(*TimeSyncMsg); ok {
+				clock.onTimeSync(msg)
+			} else if log, ok := raw.(*phonelab.Logline); ok && log != nil {
 				switch typed := log.Payload.(type) {
 				case *IFKeyEventLog:
 					{
 						// Just emit the key event if it is an up
 						if typed.Action == KEY_ACTION_UP {
-							event := &TouchScreenEvent{
+							onKey(KeySourceDispatcher, &TouchScreenEvent{
 								What:      TouchScreenEventKey,
 								Timestamp: typed.Timestamp,
 								TraceTime: log.TraceTime,
 								Code:      typed.KeyCode,
-							}
-							outChan <- event
+								NonHuman:  typed.Policy().NonHuman(),
+
+								DispatchLatencyNs: dispatchLatencyNs(log, typed.Timestamp),
+							})
+						}
+					}
+				case *KeyEventUserActionLog:
+					{
+						if typed.Method == UserActionMethodKeyEvent && typed.KeyAction == KEY_ACTION_UP {
+							onKey(KeySourceUserAction, &TouchScreenEvent{
+								What:      TouchScreenEventKey,
+								Timestamp: typed.EventTimeMs * nsPerMs,
+								TraceTime: log.TraceTime,
+								Code:      typed.KeyCode,
+								NonHuman:  typed.NonHuman(),
+							})
 						}
 					}
 				case *IFMotionEventLog:
 					{
+						nonHuman := typed.Policy().NonHuman()
+						if typed.GetMaskedAction() == ACTION_DOWN {
+							gestureNonHuman = nonHuman
+						} else {
+							gestureNonHuman = gestureNonHuman || nonHuman
+						}
+
 						// Update the detector state
 						if outEvent, err := detector.OnTouchEvent(log.TraceTime, typed); err != nil {
 							panic(err)
 						} else if outEvent != nil {
-							outChan <- outEvent
+							outEvent.NonHuman = gestureNonHuman
+							outEvent.DispatchLatencyNs = dispatchLatencyNs(log, typed.Timestamp)
+							if !outEvent.NonHuman || p.NonHumanInput != NonHumanInputExclude {
+								outChan <- outEvent
+							}
 						}
 					}
 				}
 			}
 		}
+
+		if p.KeySource == KeySourceBoth {
+			for _, m := range checker.expire(-1) {
+				outChan <- m
+			}
+		}
 		close(outChan)
 	}()
 
@@ -57,7 +253,35 @@ func (ipg *InputProcessorGenerator) GenerateProcessor(source *phonelab.PipelineS
 	kwargs map[string]interface{}) phonelab.Processor {
 
 	return &InputProcessor{
-		TouchSlop: TouchSlopScaled,
-		Source:    source.Processor,
+		TouchSlop:     TouchSlopScaled,
+		Source:        source.Processor,
+		KeySource:     NewKeySource(kwargs),
+		NonHumanInput: NewNonHumanInput(kwargs),
+	}
+}
+
+// Get the key source from the "key_source" argument: "dispatcher" (default),
+// "useraction" or "both".
+func NewKeySource(kwargs map[string]interface{}) int {
+	if v, ok := kwargs["key_source"]; ok {
+		if source, ok := keySourceNames[v.(string)]; ok {
+			return source
+		} else {
+			panic(fmt.Sprintf("Unknown key_source: %v", v))
+		}
+	}
+	return KeySourceDispatcher
+}
+
+// Get what to do with non-human input from the "non_human_input" argument:
+// "tag" (default) or "exclude".
+func NewNonHumanInput(kwargs map[string]interface{}) int {
+	if v, ok := kwargs["non_human_input"]; ok {
+		if policy, ok := nonHumanInputNames[v.(string)]; ok {
+			return policy
+		} else {
+			panic(fmt.Sprintf("Unknown non_human_input: %v", v))
+		}
 	}
+	return NonHumanInputTag
 }
@@ -22,6 +22,18 @@ type InputStateMachineParams struct {
 	UsePendingTimestamp   bool
 	SkipUndefinedResponse bool
 	JankOnFrameUpdate     bool
+	JankModel             string
+	VsyncPeriodNs         int64
+	LongStallVsyncs       int
+	GapPolicy             string
+	DetectStability       bool
+	FinishOnStable        bool
+	StabilityWindowMs     int64
+	BackgroundMaxDiff     float64
+	BackgroundMinChanges  int
+	MaskBackground        bool
+	BackgroundMask        BackgroundMaskParams
+	NonHumanInput         int
 }
 
 // Create a new InputStateMachineParams with the default settings.
@@ -37,6 +49,18 @@ func DefaultInputStateMachineParams() *InputStateMachineParams {
 		Connectivity:          FourConnected,
 		UsePendingTimestamp:   false,
 		SkipUndefinedResponse: true,
+		JankModel:             JankModelThreshold,
+		VsyncPeriodNs:         0,
+		LongStallVsyncs:       6,
+		GapPolicy:             GapPolicyMark,
+		DetectStability:       false,
+		FinishOnStable:        false,
+		StabilityWindowMs:     500,
+		BackgroundMaxDiff:     30.0,
+		BackgroundMinChanges:  4,
+		MaskBackground:        false,
+		BackgroundMask:        *DefaultBackgroundMaskParams(),
+		NonHumanInput:         NonHumanInputTag,
 	}
 }
 
@@ -109,6 +133,63 @@ func NewInputStateMachineParams(kwargs map[string]interface{}) *InputStateMachin
 		params.JankOnFrameUpdate = v.(bool)
 	}
 
+	if v, ok := kwargs["jank_model"]; ok {
+		params.JankModel = v.(string)
+	}
+
+	if v, ok := kwargs["vsync_period_ms"]; ok {
+		switch t := v.(type) {
+		case int:
+			params.VsyncPeriodNs = int64(t) * nsPerMs
+		case float64:
+			params.VsyncPeriodNs = int64(t * nsPerMsF)
+		}
+	}
+
+	if v, ok := kwargs["long_stall_vsyncs"]; ok {
+		params.LongStallVsyncs = v.(int)
+	}
+
+	params.GapPolicy = gapPolicyFromArgs(kwargs, "gap_policy", params.GapPolicy)
+
+	if v, ok := kwargs["detect_stability"]; ok {
+		params.DetectStability = v.(bool)
+	}
+
+	if v, ok := kwargs["finish_on_stable"]; ok {
+		params.FinishOnStable = v.(bool)
+	}
+
+	// Finishing on stable needs stability detection
+	if params.FinishOnStable {
+		params.DetectStability = true
+	}
+
+	if v, ok := kwargs["stability_window_ms"]; ok {
+		params.StabilityWindowMs = int64(v.(int))
+	}
+
+	if v, ok := kwargs["background_max_diff"]; ok {
+		switch t := v.(type) {
+		case int:
+			params.BackgroundMaxDiff = float64(t)
+		case float64:
+			params.BackgroundMaxDiff = t
+		}
+	}
+
+	if v, ok := kwargs["background_min_changes"]; ok {
+		params.BackgroundMinChanges = v.(int)
+	}
+
+	if v, ok := kwargs["mask_background"]; ok {
+		params.MaskBackground = v.(bool)
+	}
+
+	params.BackgroundMask = *NewBackgroundMaskParams(kwargs)
+
+	params.NonHumanInput = NewNonHumanInput(kwargs)
+
 	fmt.Println("ISM Parameters:", *params)
 
 	return params
@@ -138,6 +219,21 @@ type InputStateMachine struct {
 
 	pendingResponseStartNs int64
 	scrollKeepaliveNs      int64
+
+	// Frame pacing, only used with the vsync jank model
+	pacing      *FramePacingAnalyzer
+	prevFrameNs int64
+
+	// Foreground app
+	curApp string
+
+	// Visual stability, if enabled
+	stability *StabilityDetector
+	// Background regions for stability, kept across inputs
+	regions *StabilityRegions
+
+	// Background animation, if enabled
+	background *BackgroundMaskLearner
 }
 
 // Create a new InputStateMachine with the default parameters.
@@ -150,10 +246,16 @@ func NewInputStateMachine() *InputStateMachine {
 
 // JankEvent defines one instance in a measured response where consecutive
 // inter-frame time delta is above some threshold. In practice, there are
-// different types of jank, but they are all measured in the same way.
+// different types of jank, but they are all measured in the same way. With the
+// vsync jank model, the number of missed vsyncs and the FrameJank class are
+// filled in as well.
 type JankEvent struct {
-	TimestampNs int64 `json:"timestamp_ns"`
-	JankAmount  int64 `json:"jank_amount"`
+	TimestampNs  int64 `json:"timestamp_ns"`
+	JankAmount   int64 `json:"jank_amount"`
+	MissedVsyncs int   `json:"missed_vsyncs,omitempty"`
+	Class        int   `json:"class,omitempty"`
+	// App or compositor, if attributed (see AttributeJank)
+	Side string `json:"side,omitempty"`
 }
 
 const (
@@ -164,6 +266,7 @@ const (
 const (
 	TapEventFinishTimeout = iota
 	TapEventFinishShortCircuit
+	TapEventFinishStable
 )
 
 // InputEventResult encapsulates the response detail and performance metrics of a
@@ -179,7 +282,28 @@ type InputEventResult struct {
 	EventType    int   `json:"event_type"`
 	ScrollStopNs int64 `json:"scroll_stop_ns"`
 
+	// Set if part of the measurement overlapped missing data
+	Incomplete bool `json:"incomplete,omitempty"`
+
+	// Foreground app, if app changes are part of the input stream
+	App string `json:"app,omitempty"`
+
+	// Set if the global response was the soft keyboard being shown
+	KeyboardShown bool `json:"keyboard_shown,omitempty"`
+
+	// Time to visual stability, if enabled
+	Stability *StabilityResult `json:"stability,omitempty"`
+
+	// Set if the input didn't come from a person
+	NonHuman bool `json:"non_human,omitempty"`
+
+	// Time from the kernel to InputDispatcher, if known. TimestampNs is the
+	// kernel's time, so response times already start from the physical
+	// touch; this is how much of them the app never saw.
+	DispatchLatencyNs int64 `json:"dispatch_latency_ns,omitempty"`
+
 	prevFrameTimeNs int64
+	keyboardShowNs  int64
 }
 
 func NewInputEventResult(event *TouchScreenEvent) *InputEventResult {
@@ -189,6 +313,17 @@ func NewInputEventResult(event *TouchScreenEvent) *InputEventResult {
 		LocalResponse:  NewResponseDetail(),
 		GlobalResponse: NewResponseDetail(),
 		Jank:           make([]*JankEvent, 0),
+		NonHuman:       event.NonHuman,
+
+		DispatchLatencyNs: event.DispatchLatencyNs,
+	}
+}
+
+// The global response was the keyboard if it started after the IME showed
+// its input view.
+func (t *InputEventResult) markKeyboard() {
+	if t.keyboardShowNs > 0 && t.HasGlobalResponse() && t.GlobalResponse.StartNs >= t.keyboardShowNs {
+		t.KeyboardShown = true
 	}
 }
 
@@ -200,8 +335,11 @@ func (t *InputEventResult) HasGlobalResponse() bool {
 	return t.GlobalResponse.HasResponse()
 }
 
+// Whether there was a local or a global response. This used to only check the
+// local response, so results with only a global response now count as
+// responded.
 func (t *InputEventResult) HasResponse() bool {
-	return t.HasLocalResponse() || t.HasLocalResponse()
+	return t.HasLocalResponse() || t.HasGlobalResponse()
 }
 
 func (t *InputEventResult) TouchResponseMs() int64 {
@@ -212,6 +350,15 @@ func (t *InputEventResult) TouchResponseMs() int64 {
 	}
 }
 
+// TouchResponseMs from when InputDispatcher sent the event, rather than from
+// the physical touch.
+func (t *InputEventResult) DispatchResponseMs() int64 {
+	if !t.HasResponse() {
+		return InvalidResponseDuration
+	}
+	return t.TouchResponseMs() - t.DispatchLatencyNs/nsPerMs
+}
+
 func (t *InputEventResult) GlobalResponseMs() int64 {
 	if t.HasGlobalResponse() {
 		return (t.GlobalResponse.StartNs - t.TimestampNs) / nsPerMs
@@ -294,6 +441,8 @@ func (ism *InputStateMachine) shortCircuit(ts int64) *InputEventResult {
 	ism.curResult.FinishType = TapEventFinishShortCircuit
 	ism.curResult.FinishNs = ts
 	ism.curResult.prevFrameTimeNs = 0
+	ism.curResult.markKeyboard()
+	ism.markStability(ism.curResult)
 
 	// TODO: Do we need to detect timeouts here?
 	// If the diffs interlace zeros, then probably not since we'll have
@@ -308,6 +457,23 @@ func (ism *InputStateMachine) handleTimeout(ts int64) *InputEventResult {
 	res.FinishType = TapEventFinishTimeout
 	res.FinishNs = ts
 	res.prevFrameTimeNs = 0
+	res.markKeyboard()
+	ism.markStability(res)
+
+	// --> InputStateWaitInput
+	ism.reset()
+
+	return res
+}
+
+// Measuring --> InputStateWaitInput once the screen is stable.
+func (ism *InputStateMachine) handleStable(ts int64) *InputEventResult {
+	res := ism.curResult
+	res.FinishType = TapEventFinishStable
+	res.FinishNs = ts
+	res.prevFrameTimeNs = 0
+	res.markKeyboard()
+	ism.markStability(res)
 
 	// --> InputStateWaitInput
 	ism.reset()
@@ -315,6 +481,12 @@ func (ism *InputStateMachine) handleTimeout(ts int64) *InputEventResult {
 	return res
 }
 
+func (ism *InputStateMachine) markStability(res *InputEventResult) {
+	if ism.stability != nil {
+		res.Stability = ism.stability.Result(res.FinishNs)
+	}
+}
+
 // Reset to the start state (--> InputStateWaitInput).
 func (ism *InputStateMachine) reset() {
 	if ismDebug {
@@ -324,6 +496,7 @@ func (ism *InputStateMachine) reset() {
 	ism.curState = InputStateWaitInput
 	ism.curEvent = nil
 	ism.curResult = nil
+	ism.stability = nil
 }
 
 type responseType int
@@ -337,6 +510,11 @@ const (
 
 // Determine the responseType of the frame diff
 func (ism *InputStateMachine) getResponseType(diff *FrameDiffSample) responseType {
+	// Background animation isn't a response
+	if ism.Params.MaskBackground {
+		diff = ism.backgroundMask().Apply(diff)
+	}
+
 	if diff.PctDiff == 0.0 {
 		return responseTypeNone
 	}
@@ -377,6 +555,16 @@ func (ism *InputStateMachine) getResponseType(diff *FrameDiffSample) responseTyp
 // Update state and possibly return an event result
 func (ism *InputStateMachine) OnTouchEvent(event *TouchScreenEvent) *InputEventResult {
 
+	// Any input means the user isn't idle, even if it isn't measured
+	if ism.Params.MaskBackground {
+		ism.backgroundMask().OnInput(event)
+	}
+
+	// Excluded input is ignored as if it never happened
+	if event.NonHuman && ism.Params.NonHumanInput == NonHumanInputExclude {
+		return nil
+	}
+
 	// For all other touch events, this short-circuits the current state if
 	// we're not at the start/wait state.
 	var cur *InputEventResult = nil
@@ -396,7 +584,8 @@ func (ism *InputStateMachine) OnTouchEvent(event *TouchScreenEvent) *InputEventR
 	}
 
 	// New event staring
-	if event.What == TouchScreenEventTap || event.What == TouchScreenEventScrollStart {
+	if event.What == TouchScreenEventTap || event.What == TouchScreenEventScrollStart ||
+		event.What == TouchScreenEventKeystroke {
 		if ism.curState != InputStateWaitInput {
 			cur = ism.shortCircuit(event.Timestamp)
 		}
@@ -424,6 +613,11 @@ func (ism *InputStateMachine) startWaitingForResponse(event *TouchScreenEvent) {
 	ism.curState = InputStateWaitResponse
 	ism.curEvent = event
 	ism.curResult = NewInputEventResult(event)
+	ism.curResult.App = ism.curApp
+
+	if ism.Params.DetectStability {
+		ism.stability = NewStabilityDetector(ism.Params, ism.stabilityRegions(), event.Timestamp)
+	}
 	//ism.curResult.prevFrameTime = event.Timestamp / 1000000
 }
 
@@ -463,23 +657,64 @@ func (ism *InputStateMachine) startMeasuringRepsonse(response *ResponseDetail, d
 func (ism *InputStateMachine) checkJank(timestampNs int64) {
 	if ism.curResult != nil {
 		if ism.curResult.prevFrameTimeNs > 0 {
-			delta := (timestampNs - ism.curResult.prevFrameTimeNs) / 1000000
-			if delta >= ism.Params.JankThresholdMs {
-				ism.curResult.Jank = append(ism.curResult.Jank, &JankEvent{
-					TimestampNs: timestampNs,
-					JankAmount:  delta,
-				})
+			if ism.Params.JankModel == JankModelVsync {
+				ism.checkVsyncJank(timestampNs-ism.curResult.prevFrameTimeNs, timestampNs)
+			} else {
+				delta := (timestampNs - ism.curResult.prevFrameTimeNs) / 1000000
+				if delta >= ism.Params.JankThresholdMs {
+					ism.curResult.Jank = append(ism.curResult.Jank, &JankEvent{
+						TimestampNs: timestampNs,
+						JankAmount:  delta,
+					})
+				}
 			}
 		}
 		ism.curResult.prevFrameTimeNs = timestampNs
 	}
 }
 
+// Jank check using missed vsyncs instead of a fixed threshold.
+func (ism *InputStateMachine) checkVsyncJank(deltaNs, timestampNs int64) {
+	missed, class := ism.framePacing().Classify(deltaNs)
+	if class != FrameJankNone {
+		ism.curResult.Jank = append(ism.curResult.Jank, &JankEvent{
+			TimestampNs:  timestampNs,
+			JankAmount:   deltaNs / nsPerMs,
+			MissedVsyncs: missed,
+			Class:        class,
+		})
+	}
+}
+
+// Get the frame pacing analyzer, creating it if necessary. This is lazy since
+// the parameters can be swapped out after the state machine is created.
+func (ism *InputStateMachine) framePacing() *FramePacingAnalyzer {
+	if ism.pacing == nil {
+		params := DefaultFramePacingParams()
+		params.VsyncPeriodNs = ism.Params.VsyncPeriodNs
+		params.LongStallVsyncs = ism.Params.LongStallVsyncs
+		ism.pacing = NewFramePacingAnalyzer(params)
+	}
+	return ism.pacing
+}
+
 // Update state and possibly return an event result.
 func (ism *InputStateMachine) OnFrameDiff(diff *FrameDiffSample) *InputEventResult {
 
-	// Short circuit: we don't do anything with diffs if we're waiting for
-	// input.
+	// Learn what changes on its own. The learner decides what's idle, which
+	// isn't the same as waiting for input: the last response can still be
+	// animating after a timeout or short circuit.
+	if ism.Params.MaskBackground {
+		ism.backgroundMask().OnFrameDiff(diff)
+	}
+
+	// Between measurements, keep learning background regions for stability
+	if ism.Params.DetectStability && ism.stability == nil {
+		ism.stabilityRegions().OnFrameDiff(diff)
+	}
+
+	// Short circuit: we don't do anything else with diffs if we're waiting
+	// for input.
 	if ism.curState == InputStateWaitInput {
 		return nil
 	}
@@ -501,6 +736,14 @@ func (ism *InputStateMachine) OnFrameDiff(diff *FrameDiffSample) *InputEventResu
 		ism.checkJank(diff.TimestampNs())
 	}
 
+	// Stability is checked on every diff, including zeros, since those tell
+	// us time is passing. Nothing can settle before there's a response.
+	if ism.stability != nil && ism.stability.OnFrameDiff(diff) &&
+		ism.Params.FinishOnStable && ism.curState != InputStateWaitResponse {
+
+		return ism.handleStable(diff.TimestampNs())
+	}
+
 	// Handle timeouts in one shot
 	if rt == responseTypeNone {
 		ns := int64(0)
@@ -610,6 +853,15 @@ func (ism *InputStateMachine) OnFrameRefresh(event *FrameRefreshEvent) *InputEve
 	// At this point, we'll only use this info to update the jankiness, so we'll always
 	// return nil.
 
+	// The vsync period is inferred from every frame, not just the ones we
+	// see while measuring a response.
+	if ism.Params.JankModel == JankModelVsync {
+		if ism.prevFrameNs > 0 {
+			ism.framePacing().Observe(event.SysTimeNs - ism.prevFrameNs)
+		}
+		ism.prevFrameNs = event.SysTimeNs
+	}
+
 	// It's an option where to do this.
 	if !ism.Params.JankOnFrameUpdate {
 		return nil
@@ -644,6 +896,82 @@ func (ism *InputStateMachine) OnFrameRefresh(event *FrameRefreshEvent) *InputEve
 	return nil
 }
 
+// Update state when part of the diff or frame time stream went missing. With
+// GapPolicyAbort, the current measurement is dropped; with GapPolicyMark it is
+// flagged as incomplete. This never returns a result, but it keeps the same
+// signature as the other handlers.
+func (ism *InputStateMachine) OnDataGap(gap *DataGap) *InputEventResult {
+	// Whatever the policy, inter-frame deltas can't span the gap.
+	ism.prevFrameNs = 0
+
+	if ism.curState == InputStateWaitInput || ism.curResult == nil ||
+		ism.Params.GapPolicy == GapPolicyIgnore {
+		return nil
+	}
+
+	if !gap.Overlaps(ism.curResult.TimestampNs, 0) {
+		return nil
+	}
+
+	if ism.Params.GapPolicy == GapPolicyAbort {
+		if ismDebug {
+			fmt.Println("Aborting measurement due to data gap")
+		}
+		ism.pendingResponseStartNs = InvalidResponseTime
+		ism.reset()
+		return nil
+	}
+
+	ism.curResult.Incomplete = true
+	ism.curResult.prevFrameTimeNs = 0
+
+	return nil
+}
+
+// Called when the foreground app changes. Measurements in progress keep the
+// app they started in.
+func (ism *InputStateMachine) OnAppChange(event *AppChangeEvent) {
+	ism.curApp = event.App
+	if ism.Params.MaskBackground {
+		ism.backgroundMask().OnAppChange(event)
+	}
+	if ism.regions != nil {
+		ism.regions.Reset()
+	}
+}
+
+// Get the background mask learner, creating it if necessary.
+func (ism *InputStateMachine) backgroundMask() *BackgroundMaskLearner {
+	if ism.background == nil {
+		params := ism.Params.BackgroundMask
+		ism.background = NewBackgroundMaskLearner(&params)
+		ism.background.curApp = ism.curApp
+	}
+	return ism.background
+}
+
+func (ism *InputStateMachine) stabilityRegions() *StabilityRegions {
+	if ism.regions == nil {
+		ism.regions = NewStabilityRegions(ism.Params)
+	}
+	return ism.regions
+}
+
+// The background masks learned so far, if masking is enabled.
+func (ism *InputStateMachine) BackgroundMasks() []*BackgroundMask {
+	if ism.background == nil {
+		return nil
+	}
+	return ism.background.Masks()
+}
+
+// Called when the IME shows or hides its input view.
+func (ism *InputStateMachine) OnKeyboardEvent(event *KeyboardEvent) {
+	if event.What == KeyboardEventShow && ism.curResult != nil && ism.curResult.keyboardShowNs == 0 {
+		ism.curResult.keyboardShowNs = event.TimestampNs
+	}
+}
+
 // Called when the input log stream is finished
 func (ism *InputStateMachine) Finish(ts int64) *InputEventResult {
 	if ism.curState != InputStateWaitInput {
@@ -699,6 +1027,25 @@ func (proc *InputStateMachineProcessor) Process() <-chan interface{} {
 						outChan <- res
 					}
 				}
+			case *DataGap:
+				{
+					if res := ism.OnDataGap(t); res != nil {
+						outChan <- res
+					}
+				}
+			case *AppChangeEvent:
+				{
+					ism.OnAppChange(t)
+				}
+			case *KeyboardEvent:
+				{
+					ism.OnKeyboardEvent(t)
+				}
+			case *TimestampMergerStats:
+				{
+					// Says whether the input was in order
+					outChan <- t
+				}
 			}
 		}
 
@@ -706,6 +1053,10 @@ func (proc *InputStateMachineProcessor) Process() <-chan interface{} {
 			outChan <- res
 		}
 
+		for _, mask := range ism.BackgroundMasks() {
+			outChan <- mask
+		}
+
 		// Done.
 		close(outChan)
 	}()
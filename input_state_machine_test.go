@@ -91,6 +91,52 @@ func TestISMShortCircuit(t *testing.T) {
 	commonTestInputStateMachine(events, nil, nil, expected, t)
 }
 
+func TestInputEventResultHasResponse(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	res := NewInputEventResult(&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 100 * nsPerMs})
+	assert.False(res.HasResponse())
+
+	// Only a global response
+	res.GlobalResponse.StartNs = 300 * nsPerMs
+	assert.False(res.HasLocalResponse())
+	assert.True(res.HasResponse())
+	assert.Equal(int64(200), res.TouchResponseMs())
+
+	res.LocalResponse.StartNs = 200 * nsPerMs
+	assert.True(res.HasResponse())
+	assert.Equal(int64(100), res.TouchResponseMs())
+}
+
+func TestISMNonHumanInput(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	human := &TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 100 * nsPerMs}
+	monkey := &TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 500 * nsPerMs, NonHuman: true}
+	last := &TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 900 * nsPerMs}
+
+	// Tagged by default
+	ism := NewInputStateMachine()
+	assert.Nil(ism.OnTouchEvent(monkey))
+	res := ism.OnTouchEvent(human)
+	require.NotNil(res)
+	assert.True(res.NonHuman)
+
+	// Excluded input doesn't cut the measurement short
+	ism = NewInputStateMachine()
+	ism.Params.NonHumanInput = NonHumanInputExclude
+	assert.Nil(ism.OnTouchEvent(human))
+	assert.Nil(ism.OnTouchEvent(monkey))
+	res = ism.OnTouchEvent(last)
+	require.NotNil(res)
+	assert.False(res.NonHuman)
+	assert.Equal(human.Timestamp, res.TimestampNs)
+	assert.Equal(last.Timestamp, res.FinishNs)
+}
+
 func TestISMLocalResponse(t *testing.T) {
 	// Touch the upper left corner
 	events := []*TouchScreenEvent{
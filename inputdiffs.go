@@ -25,6 +25,8 @@ type InputDiffSample struct {
 	GlobalDiff float64          `json:"global_diff"`
 	NumChanges int              `json:"num_changes"`
 	Inserted   bool             `json:"inserted"`
+	// Changes ignored as background animation
+	NumMasked int `json:"num_masked,omitempty"`
 }
 
 type InputDiffEvent struct {
@@ -43,6 +45,8 @@ type InputDiffProcessorArgs struct {
 	DoScrolls      bool
 	DiffDurationMs int64
 	DoFrameTimes   bool
+	MaskBackground bool
+	BackgroundMask *BackgroundMaskParams
 }
 
 func NewInputDiffProcessorArgs(kwargs map[string]interface{}) *InputDiffProcessorArgs {
@@ -74,6 +78,12 @@ func NewInputDiffProcessorArgs(kwargs map[string]interface{}) *InputDiffProcesso
 		args.DoFrameTimes, _ = v.(bool)
 	}
 
+	if v, ok := kwargs["mask_background"]; ok {
+		args.MaskBackground, _ = v.(bool)
+	}
+
+	args.BackgroundMask = NewBackgroundMaskParams(kwargs)
+
 	return args
 }
 
@@ -85,11 +95,20 @@ func (proc *InputDiffProcessor) Process() <-chan interface{} {
 	var curEvent *InputDiffEvent
 
 	go func() {
+		var learner *BackgroundMaskLearner
+		if proc.Args.MaskBackground {
+			learner = NewBackgroundMaskLearner(proc.Args.BackgroundMask)
+		}
+
 		for iLog := range inChan {
 			// We're only expecting frame diffs and input logs
 			switch t := iLog.(type) {
 			case *TouchScreenEvent:
 				{
+					if learner != nil {
+						learner.OnInput(t)
+					}
+
 					if curEvent != nil {
 						if curEvent.complete {
 							curEvent.Eclipsed = true
@@ -127,8 +146,21 @@ func (proc *InputDiffProcessor) Process() <-chan interface{} {
 					}
 				}
 
+			case *AppChangeEvent:
+				{
+					if learner != nil {
+						learner.OnAppChange(t)
+					}
+				}
+
 			case *FrameDiffSample:
 				{
+					// The learner decides what's idle, so this learns the
+					// same masks as the state machine.
+					if learner != nil {
+						learner.OnFrameDiff(t)
+					}
+
 					if curEvent == nil {
 						continue
 					}
@@ -136,6 +168,13 @@ func (proc *InputDiffProcessor) Process() <-chan interface{} {
 					// TODO: This should be done in the diffstream
 					(&t.SFFrameDiff).initScreenGrid(allScreenGrids[0])
 
+					numMasked := 0
+					if learner != nil {
+						masked := learner.Apply(t)
+						numMasked = len(t.GridEntries) - len(masked.GridEntries)
+						t = masked
+					}
+
 					diffTsMs := t.SFFrameDiff.Timestamp
 					curDetail := curEvent.EventDetail[len(curEvent.EventDetail)-1]
 					curEventMs := curDetail.Timestamp / 1000000
@@ -163,6 +202,7 @@ func (proc *InputDiffProcessor) Process() <-chan interface{} {
 							GlobalDiff: t.SFFrameDiff.PctDiff,
 							NumChanges: len(t.SFFrameDiff.GridEntries),
 							Inserted:   t.Inserted,
+							NumMasked:  numMasked,
 						})
 
 					} else {
@@ -192,6 +232,12 @@ func (proc *InputDiffProcessor) Process() <-chan interface{} {
 		if curEvent != nil {
 			outChan <- curEvent
 		}
+
+		if learner != nil {
+			for _, mask := range learner.Masks() {
+				outChan <- mask
+			}
+		}
 		// Done.
 		close(outChan)
 	}()
@@ -13,6 +13,11 @@ type TouchScreenEvent struct {
 	X         float64 `json:"x"`
 	Y         float64 `json:"y"`
 	Code      int     `json:"code"`
+	// Set if the input didn't come from a person, e.g. monkey or adb
+	NonHuman bool `json:"non_human,omitempty"`
+	// Time from the kernel to InputDispatcher, if known (see
+	// dispatch_latency.go)
+	DispatchLatencyNs int64 `json:"dispatch_latency_ns,omitempty"`
 }
 
 func (event *TouchScreenEvent) MonotonicTimestamp() float64 {
@@ -32,8 +37,30 @@ const (
 	TouchScreenEventScrollStart
 	TouchScreenEventScroll
 	TouchScreenEventScrollEnd
+	// A tap on the soft keyboard, see KeystrokeClassifier
+	TouchScreenEventKeystroke
 )
 
+// Get a human readable name for a TouchScreenEvent type.
+func TouchScreenEventName(what int) string {
+	switch what {
+	case TouchScreenEventKey:
+		return "key"
+	case TouchScreenEventTap:
+		return "tap"
+	case TouchScreenEventScrollStart:
+		return "scroll_start"
+	case TouchScreenEventScroll:
+		return "scroll"
+	case TouchScreenEventScrollEnd:
+		return "scroll_end"
+	case TouchScreenEventKeystroke:
+		return "keystroke"
+	default:
+		return "unknown"
+	}
+}
+
 type GestureDetector struct {
 	TouchSlop int
 
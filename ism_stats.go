@@ -0,0 +1,439 @@
+package libphonelabgo
+
+import (
+	"encoding/json"
+	"fmt"
+	phonelab "github.com/shaseley/phonelab-go"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+)
+
+// ism_stats.go summarizes InputEventResult streams. Response times are kept in
+// QuantileSketches, so stats from different files can be merged into a single
+// fleet-wide summary without keeping every result around.
+
+// Ways results are grouped
+const (
+	StatsGroupAll        = "all"
+	StatsGroupEventType  = "event_type"
+	StatsGroupApp        = "app"
+	StatsGroupFinishType = "finish_type"
+)
+
+var allStatsGroupings = []string{
+	StatsGroupAll,
+	StatsGroupEventType,
+	StatsGroupApp,
+	StatsGroupFinishType,
+}
+
+// MetricSummary is the readable form of a QuantileSketch.
+type MetricSummary struct {
+	Count int64   `json:"count"`
+	Mean  float64 `json:"mean"`
+	Min   float64 `json:"min"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+func NewMetricSummary(sketch *QuantileSketch) *MetricSummary {
+	return &MetricSummary{
+		Count: sketch.Count,
+		Mean:  sketch.Mean(),
+		Min:   sketch.Min,
+		P50:   sketch.Quantile(0.5),
+		P90:   sketch.Quantile(0.9),
+		P99:   sketch.Quantile(0.99),
+		Max:   sketch.Max,
+	}
+}
+
+// InputEventGroupStats has the stats for one group of results, e.g. all taps.
+type InputEventGroupStats struct {
+	GroupBy string `json:"group_by"`
+	Group   string `json:"group"`
+
+	Count         int64 `json:"count"`
+	Timeouts      int64 `json:"timeouts"`
+	ShortCircuits int64 `json:"short_circuits"`
+	Stable        int64 `json:"stable"`
+	Incomplete    int64 `json:"incomplete"`
+	JankEvents    int64 `json:"jank_events"`
+
+	TimeoutRate      float64 `json:"timeout_rate"`
+	ShortCircuitRate float64 `json:"short_circuit_rate"`
+	JankPerEvent     float64 `json:"jank_per_event"`
+
+	TouchResponseMs  *MetricSummary `json:"touch_response_ms"`
+	GlobalResponseMs *MetricSummary `json:"global_response_ms"`
+	LocalDurationMs  *MetricSummary `json:"local_duration_ms"`
+	GlobalDurationMs *MetricSummary `json:"global_duration_ms"`
+	JankMs           *MetricSummary `json:"jank_ms"`
+	// Only for results where it is known
+	DispatchLatencyMs *MetricSummary `json:"dispatch_latency_ms"`
+
+	// Kept for merging
+	Sketches map[string]*QuantileSketch `json:"sketches"`
+}
+
+// Sketch names
+const (
+	sketchTouchResponse  = "touch_response_ms"
+	sketchGlobalResponse = "global_response_ms"
+	sketchLocalDuration  = "local_duration_ms"
+	sketchGlobalDuration = "global_duration_ms"
+	sketchJank           = "jank_ms"
+	sketchDispatch       = "dispatch_latency_ms"
+)
+
+var allSketchNames = []string{
+	sketchTouchResponse,
+	sketchGlobalResponse,
+	sketchLocalDuration,
+	sketchGlobalDuration,
+	sketchJank,
+	sketchDispatch,
+}
+
+func newInputEventGroupStats(groupBy, group string, accuracy float64) *InputEventGroupStats {
+	stats := &InputEventGroupStats{
+		GroupBy:  groupBy,
+		Group:    group,
+		Sketches: make(map[string]*QuantileSketch),
+	}
+	for _, name := range allSketchNames {
+		stats.Sketches[name] = NewQuantileSketch(accuracy)
+	}
+	return stats
+}
+
+func (stats *InputEventGroupStats) add(res *InputEventResult) {
+	stats.Count += 1
+
+	switch res.FinishType {
+	case TapEventFinishTimeout:
+		stats.Timeouts += 1
+	case TapEventFinishShortCircuit:
+		stats.ShortCircuits += 1
+	case TapEventFinishStable:
+		stats.Stable += 1
+	}
+
+	if res.Incomplete {
+		stats.Incomplete += 1
+	}
+
+	addValid := func(name string, v int64) {
+		if v != InvalidResponseDuration {
+			stats.Sketches[name].Add(float64(v))
+		}
+	}
+
+	addValid(sketchTouchResponse, res.TouchResponseMs())
+	addValid(sketchGlobalResponse, res.GlobalResponseMs())
+	addValid(sketchLocalDuration, res.LocalResponseDurationMs())
+	addValid(sketchGlobalDuration, res.GlobalResponseDurationMs())
+
+	if res.DispatchLatencyNs > 0 {
+		stats.Sketches[sketchDispatch].Add(float64(res.DispatchLatencyNs) / nsPerMsF)
+	}
+
+	for _, jank := range res.Jank {
+		stats.JankEvents += 1
+		stats.Sketches[sketchJank].Add(float64(jank.JankAmount))
+	}
+}
+
+func (stats *InputEventGroupStats) merge(other *InputEventGroupStats) error {
+	stats.Count += other.Count
+	stats.Timeouts += other.Timeouts
+	stats.ShortCircuits += other.ShortCircuits
+	stats.Stable += other.Stable
+	stats.Incomplete += other.Incomplete
+	stats.JankEvents += other.JankEvents
+
+	for name, sketch := range other.Sketches {
+		if mine, ok := stats.Sketches[name]; !ok {
+			// Later merges mustn't change the other stats
+			stats.Sketches[name] = sketch.Copy()
+		} else if !mine.Merge(sketch) {
+			return fmt.Errorf("Cannot merge sketches with different accuracy (%v vs %v)",
+				mine.RelativeAccuracy, sketch.RelativeAccuracy)
+		}
+	}
+	return nil
+}
+
+// Fill in the rates and summaries from the counts and sketches.
+func (stats *InputEventGroupStats) finalize() {
+	if stats.Count > 0 {
+		count := float64(stats.Count)
+		stats.TimeoutRate = float64(stats.Timeouts) / count
+		stats.ShortCircuitRate = float64(stats.ShortCircuits) / count
+		stats.JankPerEvent = float64(stats.JankEvents) / count
+	}
+
+	stats.TouchResponseMs = NewMetricSummary(stats.Sketches[sketchTouchResponse])
+	stats.GlobalResponseMs = NewMetricSummary(stats.Sketches[sketchGlobalResponse])
+	stats.LocalDurationMs = NewMetricSummary(stats.Sketches[sketchLocalDuration])
+	stats.GlobalDurationMs = NewMetricSummary(stats.Sketches[sketchGlobalDuration])
+	stats.JankMs = NewMetricSummary(stats.Sketches[sketchJank])
+	if sketch, ok := stats.Sketches[sketchDispatch]; ok {
+		stats.DispatchLatencyMs = NewMetricSummary(sketch)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// InputEventStats aggregates InputEventResults for one or more files.
+type InputEventStats struct {
+	Source   string                  `json:"source,omitempty"`
+	Files    int                     `json:"files"`
+	Accuracy float64                 `json:"accuracy"`
+	Groups   []*InputEventGroupStats `json:"groups"`
+
+	groups map[string]*InputEventGroupStats
+}
+
+func NewInputEventStats(accuracy float64) *InputEventStats {
+	if accuracy <= 0 || accuracy >= 1 {
+		accuracy = DefaultSketchAccuracy
+	}
+	return &InputEventStats{
+		Files:    1,
+		Accuracy: accuracy,
+		Groups:   make([]*InputEventGroupStats, 0),
+		groups:   make(map[string]*InputEventGroupStats),
+	}
+}
+
+func finishTypeName(finishType int) string {
+	if name, ok := finishTypeNames[finishType]; ok {
+		return name
+	}
+	return fmt.Sprint(finishType)
+}
+
+// The group names a result falls under, by grouping.
+func statsGroupsFor(res *InputEventResult) map[string]string {
+	app := res.App
+	if len(app) == 0 {
+		app = UnknownApp
+	}
+
+	return map[string]string{
+		StatsGroupAll:        StatsGroupAll,
+		StatsGroupEventType:  TouchScreenEventName(res.EventType),
+		StatsGroupApp:        app,
+		StatsGroupFinishType: finishTypeName(res.FinishType),
+	}
+}
+
+func (stats *InputEventStats) group(groupBy, group string) *InputEventGroupStats {
+	if stats.groups == nil {
+		stats.rebuildIndex()
+	}
+
+	key := groupBy + "\x00" + group
+	g, ok := stats.groups[key]
+	if !ok {
+		g = newInputEventGroupStats(groupBy, group, stats.Accuracy)
+		stats.groups[key] = g
+		stats.Groups = append(stats.Groups, g)
+	}
+	return g
+}
+
+// Stats read back from JSON only have the exported group list.
+func (stats *InputEventStats) rebuildIndex() {
+	stats.groups = make(map[string]*InputEventGroupStats)
+	for _, g := range stats.Groups {
+		stats.groups[g.GroupBy+"\x00"+g.Group] = g
+	}
+}
+
+func (stats *InputEventStats) Add(res *InputEventResult) {
+	groups := statsGroupsFor(res)
+	for _, groupBy := range allStatsGroupings {
+		stats.group(groupBy, groups[groupBy]).add(res)
+	}
+}
+
+// Merge the stats for other files into these stats.
+func (stats *InputEventStats) Merge(other *InputEventStats) error {
+	stats.Files += other.Files
+	for _, g := range other.Groups {
+		if err := stats.group(g.GroupBy, g.Group).merge(g); err != nil {
+			return err
+		}
+	}
+	stats.Finalize()
+	return nil
+}
+
+// Update the summaries and sort the groups. Called before stats are sent
+// anywhere.
+func (stats *InputEventStats) Finalize() {
+	order := make(map[string]int)
+	for i, groupBy := range allStatsGroupings {
+		order[groupBy] = i
+	}
+
+	sort.SliceStable(stats.Groups, func(i, j int) bool {
+		a, b := stats.Groups[i], stats.Groups[j]
+		if a.GroupBy != b.GroupBy {
+			return order[a.GroupBy] < order[b.GroupBy]
+		}
+		return a.Group < b.Group
+	})
+
+	for _, g := range stats.Groups {
+		g.finalize()
+	}
+}
+
+// Get the stats for a group, or nil if there aren't any.
+func (stats *InputEventStats) Get(groupBy, group string) *InputEventGroupStats {
+	if stats.groups == nil {
+		stats.rebuildIndex()
+	}
+	return stats.groups[groupBy+"\x00"+group]
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// InputEventStatsProcessor aggregates the InputEventResults from its source
+// and sends a single InputEventStats when the source is done.
+type InputEventStatsProcessor struct {
+	Source   phonelab.Processor
+	Accuracy float64
+}
+
+func (proc *InputEventStatsProcessor) Process() <-chan interface{} {
+	outChan := make(chan interface{})
+
+	go func() {
+		inChan := proc.Source.Process()
+		stats := NewInputEventStats(proc.Accuracy)
+
+		for iLog := range inChan {
+			if res, ok := iLog.(*InputEventResult); ok {
+				stats.Add(res)
+			}
+		}
+
+		stats.Finalize()
+		outChan <- stats
+		close(outChan)
+	}()
+
+	return outChan
+}
+
+type InputEventStatsProcessorGenerator struct{}
+
+func (g *InputEventStatsProcessorGenerator) GenerateProcessor(source *phonelab.PipelineSourceInstance,
+	kwargs map[string]interface{}) phonelab.Processor {
+
+	proc := &InputEventStatsProcessor{
+		Source:   source.Processor,
+		Accuracy: DefaultSketchAccuracy,
+	}
+
+	// The sketches' relative accuracy, so it must be between 0 and 1
+	if v, ok := kwargs["accuracy"]; ok {
+		if accuracy, ok := v.(float64); ok && accuracy > 0 && accuracy < 1 {
+			proc.Accuracy = accuracy
+		} else {
+			panic(fmt.Sprintf("accuracy must be between 0 and 1: %v", v))
+		}
+	}
+
+	return proc
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// InputEventStatsCollector merges the InputEventStats from every source into
+// a single summary. The per-file stats are kept as well.
+type InputEventStatsCollector struct {
+	Filename string
+	PerFile  bool
+
+	Merged  *InputEventStats
+	Sources []*InputEventStats
+
+	sync.Mutex
+}
+
+func NewInputEventStatsCollector(kwargs map[string]interface{}) *InputEventStatsCollector {
+	c := &InputEventStatsCollector{
+		PerFile: true,
+		Sources: make([]*InputEventStats, 0),
+	}
+
+	if v, ok := kwargs["filename"]; ok {
+		c.Filename, _ = v.(string)
+	}
+
+	if v, ok := kwargs["per_file"]; ok {
+		c.PerFile, _ = v.(bool)
+	}
+
+	return c
+}
+
+func (c *InputEventStatsCollector) OnData(data interface{}, info phonelab.PipelineSourceInfo) {
+	stats, ok := data.(*InputEventStats)
+	if !ok {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if info != nil && len(stats.Source) == 0 {
+		stats.Source = info.Context()
+	}
+
+	if c.PerFile {
+		c.Sources = append(c.Sources, stats)
+	}
+
+	if c.Merged == nil {
+		c.Merged = NewInputEventStats(stats.Accuracy)
+		c.Merged.Files = 0
+	}
+
+	if err := c.Merged.Merge(stats); err != nil {
+		fmt.Fprintf(os.Stderr, "Error merging stats for '%v': %v\n", stats.Source, err)
+	}
+}
+
+func (c *InputEventStatsCollector) Finish() {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.Merged == nil {
+		c.Merged = NewInputEventStats(DefaultSketchAccuracy)
+		c.Merged.Files = 0
+	}
+
+	out := struct {
+		Merged  *InputEventStats   `json:"merged"`
+		Sources []*InputEventStats `json:"sources,omitempty"`
+	}{c.Merged, c.Sources}
+
+	bytes, err := json.MarshalIndent(out, "", "\t")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error marshalling stats: %v\n", err)
+	} else if len(c.Filename) == 0 {
+		fmt.Println(string(bytes))
+	} else if err = ioutil.WriteFile(c.Filename, bytes, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing stats: %v\n", err)
+	}
+}
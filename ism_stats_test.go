@@ -0,0 +1,234 @@
+package libphonelabgo
+
+import (
+	"encoding/json"
+	phonelab "github.com/shaseley/phonelab-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQuantileSketch(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	s := NewQuantileSketch(0.01)
+	assert.Equal(0.0, s.Quantile(0.5))
+
+	for i := 1; i <= 1000; i++ {
+		s.Add(float64(i))
+	}
+
+	assert.Equal(int64(1000), s.Count)
+	assert.Equal(500.5, s.Mean())
+	assert.Equal(1.0, s.Quantile(0.0))
+	assert.Equal(1000.0, s.Quantile(1.0))
+
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		expected := q * 1000
+		assert.True(math.Abs(s.Quantile(q)-expected) <= 0.01*expected+1,
+			"q=%v: %v", q, s.Quantile(q))
+	}
+
+	// Merging two halves gives the same sketch
+	a := NewQuantileSketch(0.01)
+	b := NewQuantileSketch(0.01)
+	for i := 1; i <= 1000; i++ {
+		if i%2 == 0 {
+			a.Add(float64(i))
+		} else {
+			b.Add(float64(i))
+		}
+	}
+	assert.True(a.Merge(b))
+	assert.Equal(s, a)
+
+	assert.False(a.Merge(&QuantileSketch{RelativeAccuracy: 0.05, Count: 1}))
+
+	// Zeros
+	z := NewQuantileSketch(0.01)
+	z.Add(0)
+	z.Add(0)
+	z.Add(10)
+	assert.Equal(0.0, z.Quantile(0.5))
+	assert.InDelta(10.0, z.Quantile(0.99), 0.1)
+}
+
+func statsTestResult(eventType, finishType int, responseMs int64, app string, jank ...int64) *InputEventResult {
+	res := NewInputEventResult(&TouchScreenEvent{
+		What:      eventType,
+		Timestamp: 1000 * nsPerMs,
+	})
+	res.FinishType = finishType
+	res.App = app
+	if responseMs >= 0 {
+		res.LocalResponse.StartNs = res.TimestampNs + responseMs*nsPerMs
+		res.LocalResponse.EndNs = res.LocalResponse.StartNs + 100*nsPerMs
+	}
+	for _, amount := range jank {
+		res.Jank = append(res.Jank, &JankEvent{JankAmount: amount})
+	}
+	return res
+}
+
+func TestInputEventStats(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	stats := NewInputEventStats(0.01)
+	stats.Add(statsTestResult(TouchScreenEventTap, TapEventFinishShortCircuit, 100, "a", 50))
+	stats.Add(statsTestResult(TouchScreenEventTap, TapEventFinishTimeout, 200, "a"))
+	stats.Add(statsTestResult(TouchScreenEventScrollStart, TapEventFinishShortCircuit, 300, ""))
+	stats.Add(statsTestResult(TouchScreenEventTap, TapEventFinishTimeout, -1, "b", 30, 40))
+	stats.Finalize()
+
+	all := stats.Get(StatsGroupAll, StatsGroupAll)
+	require.NotNil(all)
+	assert.Equal(int64(4), all.Count)
+	assert.Equal(0.5, all.TimeoutRate)
+	assert.Equal(0.5, all.ShortCircuitRate)
+	assert.Equal(int64(3), all.JankEvents)
+	assert.Equal(int64(3), all.TouchResponseMs.Count)
+	assert.InDelta(200.0, all.TouchResponseMs.Mean, 0.001)
+	assert.InDelta(200.0, all.TouchResponseMs.P50, 2.0)
+	assert.Equal(int64(3), all.LocalDurationMs.Count)
+	assert.Equal(int64(0), all.GlobalResponseMs.Count)
+
+	taps := stats.Get(StatsGroupEventType, "tap")
+	require.NotNil(taps)
+	assert.Equal(int64(3), taps.Count)
+
+	assert.Equal(int64(1), stats.Get(StatsGroupApp, UnknownApp).Count)
+	assert.Equal(int64(2), stats.Get(StatsGroupApp, "a").Count)
+	assert.Equal(int64(2), stats.Get(StatsGroupFinishType, "timeout").Count)
+	assert.Nil(stats.Get(StatsGroupApp, "c"))
+
+	// Groups are sorted by grouping, then name
+	assert.Equal(StatsGroupAll, stats.Groups[0].GroupBy)
+	assert.Equal(StatsGroupFinishType, stats.Groups[len(stats.Groups)-1].GroupBy)
+
+	// Merging survives a round trip through JSON
+	bytes, err := json.Marshal(stats)
+	require.Nil(err)
+
+	var decoded InputEventStats
+	require.Nil(json.Unmarshal(bytes, &decoded))
+
+	merged := NewInputEventStats(0.01)
+	merged.Files = 0
+	require.Nil(merged.Merge(stats))
+	require.Nil(merged.Merge(&decoded))
+
+	assert.Equal(2, merged.Files)
+	all = merged.Get(StatsGroupAll, StatsGroupAll)
+	assert.Equal(int64(8), all.Count)
+	assert.Equal(0.5, all.TimeoutRate)
+	assert.Equal(int64(6), all.TouchResponseMs.Count)
+	assert.InDelta(200.0, all.TouchResponseMs.Mean, 0.001)
+}
+
+func TestInputEventStatsPipeline(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "ismstats")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "stats.json")
+	c := NewInputEventStatsCollector(map[string]interface{}{
+		"filename": filename,
+	})
+
+	for _, source := range []string{"a.log", "b.log"} {
+		proc := &InputEventStatsProcessor{
+			Source: &sliceSource{[]interface{}{
+				statsTestResult(TouchScreenEventTap, TapEventFinishTimeout, 100, ""),
+				&FrameRefreshEvent{},
+			}},
+			Accuracy: 0.01,
+		}
+		res := collectAll(proc)
+		require.Equal(1, len(res))
+		c.OnData(res[0], &testSourceInfo{source})
+	}
+	c.Finish()
+
+	bytes, err := ioutil.ReadFile(filename)
+	require.Nil(err)
+
+	var out struct {
+		Merged  *InputEventStats   `json:"merged"`
+		Sources []*InputEventStats `json:"sources"`
+	}
+	require.Nil(json.Unmarshal(bytes, &out))
+
+	assert.Equal(2, out.Merged.Files)
+	assert.Equal(int64(2), out.Merged.Get(StatsGroupAll, StatsGroupAll).Count)
+	require.Equal(2, len(out.Sources))
+	assert.Equal("a.log", out.Sources[0].Source)
+	assert.Equal(1.0, out.Sources[1].Get(StatsGroupAll, StatsGroupAll).TimeoutRate)
+}
+
+func TestInputEventStatsProcessorArgs(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	gen := &InputEventStatsProcessorGenerator{}
+	accuracy := func(v interface{}) float64 {
+		return gen.GenerateProcessor(&phonelab.PipelineSourceInstance{},
+			map[string]interface{}{"accuracy": v}).(*InputEventStatsProcessor).Accuracy
+	}
+
+	assert.Equal(0.05, accuracy(0.05))
+	assert.Equal(DefaultSketchAccuracy, gen.GenerateProcessor(&phonelab.PipelineSourceInstance{},
+		map[string]interface{}{}).(*InputEventStatsProcessor).Accuracy)
+
+	// Anything the sketches can't use is rejected, instead of silently
+	// replaced with the default.
+	for _, v := range []interface{}{0, 1, 1.5, 0.0, "0.01"} {
+		assert.Panics(func() { accuracy(v) }, "%v", v)
+	}
+}
+
+func TestInputEventStatsMergeCopies(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	a := NewInputEventStats(0.01)
+	a.Add(statsTestResult(TouchScreenEventTap, TapEventFinishTimeout, 100, "a"))
+	a.Finalize()
+
+	// Stats saved before a sketch existed
+	merged := NewInputEventStats(0.01)
+	merged.Files = 0
+	delete(merged.group(StatsGroupAll, StatsGroupAll).Sketches, sketchTouchResponse)
+
+	require.Nil(merged.Merge(a))
+	require.Nil(merged.Merge(a))
+
+	assert.Equal(int64(2), merged.Get(StatsGroupAll, StatsGroupAll).Sketches[sketchTouchResponse].Count)
+	assert.Equal(int64(1), a.Get(StatsGroupAll, StatsGroupAll).Sketches[sketchTouchResponse].Count)
+}
+
+func TestQuantileSketchCopy(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	s := NewQuantileSketch(0.01)
+	s.Add(10)
+	c := s.Copy()
+	c.Add(20)
+
+	assert.Equal(int64(1), s.Count)
+	assert.Equal(1, len(s.Buckets))
+	assert.Equal(int64(2), c.Count)
+	assert.Equal(2, len(c.Buckets))
+}
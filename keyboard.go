@@ -0,0 +1,340 @@
+package libphonelabgo
+
+import (
+	phonelab "github.com/shaseley/phonelab-go"
+)
+
+// keyboard.go measures how long the soft keyboard (IME) takes to show up and
+// go away. The InputMethodService lifecycle logs tell us when the IME starts
+// and finishes its input view; the input that caused it is the most recent
+// tap (or key) before that, and the keyboard is visible once the bottom of the
+// screen changes.
+
+// IMS lifecycle actions
+const (
+	IMSActionStartInputView  = "onStartInputView"
+	IMSActionFinishInputView = "onFinishInputView"
+)
+
+// KeyboardEvent types
+const (
+	KeyboardEventShow = iota
+	KeyboardEventHide
+)
+
+// KeyboardEvent is emitted when the IME shows or hides its input view.
+type KeyboardEvent struct {
+	What        int     `json:"what"`
+	TimestampNs int64   `json:"timestamp_ns"`
+	TraceTime   float64 `json:"tracetime"`
+}
+
+func (event *KeyboardEvent) MonotonicTimestamp() float64 {
+	if GlobalConf.UseSysTime {
+		return float64(event.TimestampNs) / nsPerSecF
+	} else {
+		return event.TraceTime
+	}
+}
+
+// Convert an IMS lifecycle log to a KeyboardEvent. Returns nil for other
+// actions.
+func NewKeyboardEvent(log *IMSLifeCycleLog, traceTime float64) *KeyboardEvent {
+	event := &KeyboardEvent{
+		TimestampNs: log.UpTimeNs,
+		TraceTime:   traceTime,
+	}
+
+	switch log.Action {
+	case IMSActionStartInputView:
+		event.What = KeyboardEventShow
+	case IMSActionFinishInputView:
+		event.What = KeyboardEventHide
+	default:
+		return nil
+	}
+	return event
+}
+
+// KeyboardEventProcessor emits KeyboardEvents from IMS lifecycle logs.
+type KeyboardEventProcessor struct {
+	Source phonelab.Processor
+}
+
+func (proc *KeyboardEventProcessor) Process() <-chan interface{} {
+	outChan := make(chan interface{})
+
+	go func() {
+		inChan := proc.Source.Process()
+
+		for iLog := range inChan {
+			if ll, ok := iLog.(*phonelab.Logline); ok && ll != nil {
+				if log, ok := ll.Payload.(*IMSLifeCycleLog); ok {
+					if event := NewKeyboardEvent(log, ll.TraceTime); event != nil {
+						outChan <- event
+					}
+				}
+			}
+		}
+		close(outChan)
+	}()
+
+	return outChan
+}
+
+type KeyboardEventProcessorGenerator struct{}
+
+func (g *KeyboardEventProcessorGenerator) GenerateProcessor(source *phonelab.PipelineSourceInstance,
+	kwargs map[string]interface{}) phonelab.Processor {
+
+	return &KeyboardEventProcessor{
+		Source: source.Processor,
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type KeyboardLatencyParams struct {
+	// How far back to look for the input that triggered a show/hide.
+	MaxTriggerMs int64
+	// How long to wait for the keyboard to appear/disappear on screen.
+	MaxVisibleMs int64
+	// Number of grid rows, from the bottom, covered by the keyboard.
+	KeyboardRows int
+	// Mean change over the keyboard rows that counts as the keyboard
+	// (dis)appearing.
+	DiffThreshold float64
+}
+
+func DefaultKeyboardLatencyParams() *KeyboardLatencyParams {
+	return &KeyboardLatencyParams{
+		MaxTriggerMs:  1000,
+		MaxVisibleMs:  2000,
+		KeyboardRows:  3,
+		DiffThreshold: 10.0,
+	}
+}
+
+func NewKeyboardLatencyParams(kwargs map[string]interface{}) *KeyboardLatencyParams {
+	params := DefaultKeyboardLatencyParams()
+
+	if v, ok := kwargs["max_trigger_ms"]; ok {
+		params.MaxTriggerMs = int64(v.(int))
+	}
+
+	if v, ok := kwargs["max_visible_ms"]; ok {
+		params.MaxVisibleMs = int64(v.(int))
+	}
+
+	if v, ok := kwargs["keyboard_rows"]; ok {
+		params.KeyboardRows = v.(int)
+	}
+
+	if v, ok := kwargs["diff_threshold"]; ok {
+		switch t := v.(type) {
+		case int:
+			params.DiffThreshold = float64(t)
+		case float64:
+			params.DiffThreshold = t
+		}
+	}
+
+	return params
+}
+
+// KeyboardLatencyResult is the latency of one keyboard show or hide.
+// Timestamps that couldn't be found are InvalidResponseTime.
+type KeyboardLatencyResult struct {
+	What int `json:"what"`
+
+	// The input that caused it
+	TriggerNs   int64   `json:"trigger_ns"`
+	TriggerType int     `json:"trigger_type"`
+	X           float64 `json:"x"`
+	Y           float64 `json:"y"`
+
+	// IME lifecycle
+	LifecycleNs int64 `json:"lifecycle_ns"`
+
+	// First frame diff with the keyboard area changing
+	VisibleNs int64 `json:"visible_ns"`
+}
+
+func (res *KeyboardLatencyResult) HasTrigger() bool {
+	return res.TriggerNs != InvalidResponseTime
+}
+
+func (res *KeyboardLatencyResult) HasVisible() bool {
+	return res.VisibleNs != InvalidResponseTime
+}
+
+// Input to the keyboard showing or hiding on screen.
+func (res *KeyboardLatencyResult) LatencyMs() int64 {
+	if !res.HasTrigger() || !res.HasVisible() {
+		return InvalidResponseDuration
+	}
+	return (res.VisibleNs - res.TriggerNs) / nsPerMs
+}
+
+// Input to the IME lifecycle callback.
+func (res *KeyboardLatencyResult) LifecycleLatencyMs() int64 {
+	if !res.HasTrigger() {
+		return InvalidResponseDuration
+	}
+	return (res.LifecycleNs - res.TriggerNs) / nsPerMs
+}
+
+// Mean change over the bottom rows of the screen.
+func keyboardRegionDiff(diff *SFFrameDiff, rows int) float64 {
+	props := allScreenGrids[0]
+	values := heatmapGridValues(diff, props)
+
+	if rows <= 0 || rows > props.rows {
+		rows = props.rows
+	}
+
+	sum := 0.0
+	for row := props.rows - rows; row < props.rows; row++ {
+		for _, v := range values[row] {
+			sum += v
+		}
+	}
+	return sum / float64(rows*props.cols)
+}
+
+// KeyboardLatencyAnalyzer pairs keyboard events with the input that caused
+// them and the frame diffs that show them.
+type KeyboardLatencyAnalyzer struct {
+	Params *KeyboardLatencyParams
+
+	lastInput *TouchScreenEvent
+	pending   *KeyboardLatencyResult
+}
+
+func NewKeyboardLatencyAnalyzer(params *KeyboardLatencyParams) *KeyboardLatencyAnalyzer {
+	if params == nil {
+		params = DefaultKeyboardLatencyParams()
+	}
+	return &KeyboardLatencyAnalyzer{
+		Params: params,
+	}
+}
+
+// Taps, keys and keystrokes can trigger the keyboard. Scrolls can't.
+func (kla *KeyboardLatencyAnalyzer) OnTouchEvent(event *TouchScreenEvent) *KeyboardLatencyResult {
+	if event.What == TouchScreenEventTap || event.What == TouchScreenEventKey ||
+		event.What == TouchScreenEventKeystroke {
+		kla.lastInput = event
+	}
+	return nil
+}
+
+func (kla *KeyboardLatencyAnalyzer) OnKeyboardEvent(event *KeyboardEvent) *KeyboardLatencyResult {
+	// A new show/hide ends the last one
+	prev := kla.pending
+
+	res := &KeyboardLatencyResult{
+		What:        event.What,
+		TriggerNs:   InvalidResponseTime,
+		LifecycleNs: event.TimestampNs,
+		VisibleNs:   InvalidResponseTime,
+	}
+
+	if input := kla.lastInput; input != nil &&
+		input.Timestamp <= event.TimestampNs &&
+		event.TimestampNs-input.Timestamp <= kla.Params.MaxTriggerMs*nsPerMs {
+
+		res.TriggerNs = input.Timestamp
+		res.TriggerType = input.What
+		res.X = input.X
+		res.Y = input.Y
+
+		// Inputs only trigger one change
+		kla.lastInput = nil
+	}
+
+	kla.pending = res
+	return prev
+}
+
+func (kla *KeyboardLatencyAnalyzer) OnFrameDiff(diff *FrameDiffSample) *KeyboardLatencyResult {
+	res := kla.pending
+	if res == nil {
+		return nil
+	}
+
+	ts := diff.TimestampNs()
+	if ts < res.LifecycleNs {
+		return nil
+	}
+
+	if ts-res.LifecycleNs > kla.Params.MaxVisibleMs*nsPerMs {
+		kla.pending = nil
+		return res
+	}
+
+	if keyboardRegionDiff(&diff.SFFrameDiff, kla.Params.KeyboardRows) >= kla.Params.DiffThreshold {
+		res.VisibleNs = ts
+		kla.pending = nil
+		return res
+	}
+
+	return nil
+}
+
+func (kla *KeyboardLatencyAnalyzer) Finish() *KeyboardLatencyResult {
+	res := kla.pending
+	kla.pending = nil
+	return res
+}
+
+// KeyboardLatencyProcessor runs a KeyboardLatencyAnalyzer over a stream of
+// TouchScreenEvents, KeyboardEvents and FrameDiffSamples.
+type KeyboardLatencyProcessor struct {
+	Source phonelab.Processor
+	Params *KeyboardLatencyParams
+}
+
+func (proc *KeyboardLatencyProcessor) Process() <-chan interface{} {
+	outChan := make(chan interface{})
+
+	go func() {
+		inChan := proc.Source.Process()
+		kla := NewKeyboardLatencyAnalyzer(proc.Params)
+
+		for iLog := range inChan {
+			var res *KeyboardLatencyResult
+
+			switch t := iLog.(type) {
+			case *TouchScreenEvent:
+				res = kla.OnTouchEvent(t)
+			case *KeyboardEvent:
+				res = kla.OnKeyboardEvent(t)
+			case *FrameDiffSample:
+				res = kla.OnFrameDiff(t)
+			}
+
+			if res != nil {
+				outChan <- res
+			}
+		}
+
+		if res := kla.Finish(); res != nil {
+			outChan <- res
+		}
+		close(outChan)
+	}()
+
+	return outChan
+}
+
+type KeyboardLatencyProcessorGenerator struct{}
+
+func (g *KeyboardLatencyProcessorGenerator) GenerateProcessor(source *phonelab.PipelineSourceInstance,
+	kwargs map[string]interface{}) phonelab.Processor {
+
+	return &KeyboardLatencyProcessor{
+		Source: source.Processor,
+		Params: NewKeyboardLatencyParams(kwargs),
+	}
+}
@@ -0,0 +1,123 @@
+package libphonelabgo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// A diff with the bottom three rows fully changed
+func keyboardTestDiff(tsMs int64) *FrameDiffSample {
+	entries := make([]*GridEntry, 0)
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 5; col++ {
+			entries = append(entries, &GridEntry{row*8 + col, 100.0})
+		}
+	}
+	return heatmapTestDiff(tsMs, entries...)
+}
+
+func TestKeyboardEvent(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	event := NewKeyboardEvent(&IMSLifeCycleLog{
+		Action:   IMSActionStartInputView,
+		UpTimeNs: 158108566813,
+	}, 1.5)
+	assert.Equal(&KeyboardEvent{
+		What:        KeyboardEventShow,
+		TimestampNs: 158108566813,
+		TraceTime:   1.5,
+	}, event)
+
+	event = NewKeyboardEvent(&IMSLifeCycleLog{Action: IMSActionFinishInputView}, 0)
+	assert.Equal(KeyboardEventHide, event.What)
+
+	assert.Nil(NewKeyboardEvent(&IMSLifeCycleLog{Action: "onCreate"}, 0))
+}
+
+func TestKeyboardRegionDiff(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	diff := keyboardTestDiff(100)
+	assert.Equal(100.0, keyboardRegionDiff(&diff.SFFrameDiff, 3))
+	assert.Equal(37.5, keyboardRegionDiff(&diff.SFFrameDiff, 8))
+
+	// Changes at the top don't count
+	diff = heatmapTestDiff(100, &GridEntry{63 - 3, 100.0})
+	assert.Equal(0.0, keyboardRegionDiff(&diff.SFFrameDiff, 3))
+}
+
+func TestKeyboardLatency(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	source := &sliceSource{[]interface{}{
+		// Tap on a text field, keyboard shows
+		&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 1000 * nsPerMs, X: 500, Y: 300},
+		heatmapTestDiff(1020, &GridEntry{60, 50.0}),
+		&KeyboardEvent{What: KeyboardEventShow, TimestampNs: 1100 * nsPerMs},
+		heatmapTestDiff(1150, &GridEntry{0, 10.0}),
+		keyboardTestDiff(1250),
+
+		// Back key, keyboard hides
+		&TouchScreenEvent{What: TouchScreenEventKey, Timestamp: 5000 * nsPerMs},
+		&KeyboardEvent{What: KeyboardEventHide, TimestampNs: 5050 * nsPerMs},
+		keyboardTestDiff(5100),
+
+		// Shown without input, never seen on screen
+		&KeyboardEvent{What: KeyboardEventShow, TimestampNs: 9000 * nsPerMs},
+		heatmapTestDiff(12000),
+	}}
+
+	res := collectAll(&KeyboardLatencyProcessor{
+		Source: source,
+		Params: DefaultKeyboardLatencyParams(),
+	})
+	require.Equal(3, len(res))
+
+	show := res[0].(*KeyboardLatencyResult)
+	assert.Equal(KeyboardEventShow, show.What)
+	assert.Equal(TouchScreenEventTap, show.TriggerType)
+	assert.Equal(500.0, show.X)
+	assert.Equal(int64(100), show.LifecycleLatencyMs())
+	assert.Equal(int64(250), show.LatencyMs())
+
+	hide := res[1].(*KeyboardLatencyResult)
+	assert.Equal(KeyboardEventHide, hide.What)
+	assert.Equal(TouchScreenEventKey, hide.TriggerType)
+	assert.Equal(int64(100), hide.LatencyMs())
+
+	orphan := res[2].(*KeyboardLatencyResult)
+	assert.False(orphan.HasTrigger())
+	assert.False(orphan.HasVisible())
+	assert.Equal(int64(InvalidResponseDuration), orphan.LatencyMs())
+}
+
+func TestInputStateMachineKeyboard(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	res := NewInputEventResult(&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 1000})
+	res.GlobalResponse.StartNs = 3000
+	res.keyboardShowNs = 2000
+	res.markKeyboard()
+	assert.True(res.KeyboardShown)
+
+	// The response started before the keyboard was shown
+	res = NewInputEventResult(&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 1000})
+	res.GlobalResponse.StartNs = 1500
+	res.keyboardShowNs = 2000
+	res.markKeyboard()
+	assert.False(res.KeyboardShown)
+
+	// Only measurements in progress are marked
+	ism := NewInputStateMachine()
+	ism.OnKeyboardEvent(&KeyboardEvent{What: KeyboardEventShow, TimestampNs: 500})
+	ism.OnTouchEvent(&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 1000})
+	ism.OnKeyboardEvent(&KeyboardEvent{What: KeyboardEventShow, TimestampNs: 2000})
+	assert.Equal(int64(2000), ism.curResult.keyboardShowNs)
+}
@@ -0,0 +1,29 @@
+package libphonelabgo
+
+import (
+	phonelab "github.com/shaseley/phonelab-go"
+)
+
+// Activity lifecycle logs
+type ActivityLifeCycleLog struct {
+	phonelab.PLLog
+	Action         string `json:"Action"`
+	AppName        string `json:"AppName"`
+	Pid            int    `json:"Pid"`
+	Uid            int    `json:"Uid"`
+	Tid            int    `json:"Tid"`
+	ParentActivity string `json:"ParentActivity"`
+	ActivityName   string `json:"ActivityName"`
+	UpTimeMs       int64  `json:"UpTime"`
+	SessionId      string `json:"SessionID"`
+}
+
+type ActivityLifeCycleLogProps struct{}
+
+func (p *ActivityLifeCycleLogProps) New() interface{} {
+	return &ActivityLifeCycleLog{}
+}
+
+func NewActivityLifeCycleParser() phonelab.Parser {
+	return phonelab.NewJSONParser(&ActivityLifeCycleLogProps{})
+}
@@ -77,6 +77,69 @@ const (
 	FLAG_TARGET_ACCESSIBILITY_FOCUS   = 0x40000000
 )
 
+// KeyEvent flags, from frameworks/base/core/java/android/view/KeyEvent.java.
+const (
+	KEY_FLAG_FROM_SYSTEM      = 0x8
+	KEY_FLAG_VIRTUAL_HARD_KEY = 0x40
+)
+
+// Policy flags, from
+// frameworks/base/core/java/android/view/WindowManagerPolicy.java.
+const (
+	POLICY_FLAG_WAKE         = 0x00000001
+	POLICY_FLAG_VIRTUAL      = 0x00000002
+	POLICY_FLAG_INJECTED     = 0x01000000
+	POLICY_FLAG_TRUSTED      = 0x02000000
+	POLICY_FLAG_FILTERED     = 0x04000000
+	POLICY_FLAG_INTERACTIVE  = 0x20000000
+	POLICY_FLAG_PASS_TO_USER = 0x40000000
+)
+
+// InputPolicy is what the policy and event flags say about where an input
+// event came from.
+type InputPolicy struct {
+	// Injected by an app or the shell (monkey, adb, accessibility
+	// services), rather than read from an input device
+	Injected bool `json:"injected"`
+	// The injector had permission to inject
+	Trusted bool `json:"trusted"`
+	// Virtual key, e.g. a capacitive button
+	Virtual bool `json:"virtual"`
+	// Key injected by the navigation bar
+	VirtualHardKey bool `json:"virtual_hard_key"`
+	// Sent to the view with accessibility focus
+	AccessibilityFocus bool `json:"accessibility_focus"`
+	// Passed through an accessibility input filter
+	Filtered bool `json:"filtered"`
+}
+
+// DecodeInputPolicy decodes policy flags and event flags. Motion and key
+// events use different event flags, so isKey says which these are.
+func DecodeInputPolicy(policyFlags, flags int, isKey bool) *InputPolicy {
+	policy := &InputPolicy{
+		Injected: policyFlags&POLICY_FLAG_INJECTED != 0,
+		Trusted:  policyFlags&POLICY_FLAG_TRUSTED != 0,
+		Virtual:  policyFlags&POLICY_FLAG_VIRTUAL != 0,
+		Filtered: policyFlags&POLICY_FLAG_FILTERED != 0,
+	}
+	if isKey {
+		policy.VirtualHardKey = flags&KEY_FLAG_VIRTUAL_HARD_KEY != 0
+	} else {
+		policy.AccessibilityFocus = flags&FLAG_TARGET_ACCESSIBILITY_FOCUS != 0
+	}
+	return policy
+}
+
+// Whether a person didn't generate the event. The navigation bar injects
+// BACK, HOME and RECENTS, but those are still a person pressing a button.
+func (policy *InputPolicy) NonHuman() bool {
+	return policy.Injected && !policy.VirtualHardKey
+}
+
+func (event *IFKeyEventLog) Policy() *InputPolicy {
+	return DecodeInputPolicy(event.PolicyFlags, event.Flags, true)
+}
+
 const (
 	EDGE_TOP    = 0x00000001
 	EDGE_BOTTOM = 0x00000002
@@ -94,6 +157,7 @@ const (
 	KEYCODE_VOLUME_DOWN = 25
 	KEYCODE_POWER       = 26
 	KEYCODE_SEARCH      = 84
+	KEYCODE_APP_SWITCH  = 187
 )
 
 const (
@@ -130,6 +194,10 @@ func (event *IFMotionEventLog) GetMaskedAction() int {
 	return event.Action & ACTION_MASK
 }
 
+func (event *IFMotionEventLog) Policy() *InputPolicy {
+	return DecodeInputPolicy(event.PolicyFlags, event.Flags, false)
+}
+
 type IFMotionEventParserProps struct{}
 
 func (p *IFMotionEventParserProps) New() interface{} {
@@ -1,6 +1,7 @@
 package libphonelabgo
 
 import (
+	phonelab "github.com/shaseley/phonelab-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"reflect"
@@ -151,3 +152,97 @@ func TestActionPointerIndex(t *testing.T) {
 		assert.Equal(test.expected, res)
 	}
 }
+
+func TestDecodeInputPolicy(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	// A finger on the touchscreen
+	policy := DecodeInputPolicy(0x62000000, 0, false)
+	assert.Equal(&InputPolicy{Trusted: true}, policy)
+	assert.False(policy.NonHuman())
+
+	// BACK from the navigation bar
+	policy = DecodeInputPolicy(0x6b000002, 72, true)
+	assert.True(policy.Injected)
+	assert.True(policy.Virtual)
+	assert.True(policy.VirtualHardKey)
+	assert.False(policy.NonHuman())
+
+	// adb shell input tap
+	policy = DecodeInputPolicy(POLICY_FLAG_INJECTED, 0, false)
+	assert.True(policy.NonHuman())
+
+	// Accessibility focus is only a motion event flag
+	policy = DecodeInputPolicy(0, FLAG_TARGET_ACCESSIBILITY_FOCUS, false)
+	assert.True(policy.AccessibilityFocus)
+	assert.False(policy.NonHuman())
+	assert.False(DecodeInputPolicy(0, FLAG_TARGET_ACCESSIBILITY_FOCUS, true).AccessibilityFocus)
+}
+
+func TestInputNonHuman(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	motion := func(tsMs int64, action, pflags int) *phonelab.Logline {
+		return &phonelab.Logline{Payload: &IFMotionEventLog{
+			Timestamp:   tsMs * nsPerMs,
+			Action:      action,
+			PolicyFlags: pflags,
+			PointerData: []*IFPointerData{&IFPointerData{XPos: 500.0, YPos: 500.0}},
+		}}
+	}
+	key := func(tsMs int64, code, pflags, flags int) *phonelab.Logline {
+		return &phonelab.Logline{Payload: &IFKeyEventLog{
+			Timestamp:   tsMs * nsPerMs,
+			Action:      KEY_ACTION_UP,
+			KeyCode:     code,
+			PolicyFlags: pflags,
+			Flags:       flags,
+		}}
+	}
+
+	logs := []interface{}{
+		// A person tapping
+		motion(1000, ACTION_DOWN, 0x62000000),
+		motion(1050, ACTION_UP, 0x62000000),
+		// adb shell input tap
+		motion(2000, ACTION_DOWN, POLICY_FLAG_INJECTED),
+		motion(2050, ACTION_UP, POLICY_FLAG_INJECTED),
+		// Navigation bar BACK, then an injected one
+		key(3000, KEYCODE_BACK, 0x6b000002, 72),
+		key(4000, KEYCODE_BACK, POLICY_FLAG_INJECTED, 0),
+	}
+
+	events := func(policy int) []*TouchScreenEvent {
+		res := make([]*TouchScreenEvent, 0)
+		for _, r := range collectAll(&InputProcessor{
+			TouchSlop:     TouchSlopScaled,
+			Source:        &sliceSource{logs},
+			NonHumanInput: policy,
+		}) {
+			if event, ok := r.(*TouchScreenEvent); ok {
+				res = append(res, event)
+			}
+		}
+		return res
+	}
+
+	tagged := events(NonHumanInputTag)
+	require.Equal(4, len(tagged))
+	assert.Equal([]bool{false, true, false, true}, []bool{
+		tagged[0].NonHuman, tagged[1].NonHuman, tagged[2].NonHuman, tagged[3].NonHuman,
+	})
+
+	excluded := events(NonHumanInputExclude)
+	require.Equal(2, len(excluded))
+	assert.Equal(TouchScreenEventTap, excluded[0].What)
+	assert.Equal(int64(1050*nsPerMs), excluded[0].Timestamp)
+	assert.Equal(TouchScreenEventKey, excluded[1].What)
+	assert.Equal(int64(3000*nsPerMs), excluded[1].Timestamp)
+
+	assert.Equal(NonHumanInputExclude, NewNonHumanInput(map[string]interface{}{"non_human_input": "exclude"}))
+	assert.Equal(NonHumanInputTag, NewNonHumanInput(map[string]interface{}{}))
+	assert.Panics(func() { NewNonHumanInput(map[string]interface{}{"non_human_input": "nope"}) })
+}
@@ -0,0 +1,43 @@
+package libphonelabgo
+
+import (
+	phonelab "github.com/shaseley/phonelab-go"
+)
+
+// Spinner-State-QoE logs are written by the framework when a ProgressBar
+// starts or stops animating. They're the ground truth for spinner detection.
+
+const (
+	SpinnerStateActionEvent = "SpinnerEvent"
+	SpinnerStateTypeStart   = "start"
+	SpinnerStateTypeStop    = "stop"
+)
+
+type SpinnerStateLog struct {
+	phonelab.PLLog
+	Action    string `json:"Action"`
+	Type      string `json:"Type"`
+	View      string `json:"View"`
+	Parent    string `json:"Parent"`
+	RootView  string `json:"RootView"`
+	AppName   string `json:"AppName"`
+	Pid       int    `json:"Pid"`
+	Uid       int    `json:"Uid"`
+	Tid       int    `json:"Tid"`
+	Id        int64  `json:"Id"`
+	Token     string `json:"Token"`
+	Activity  string `json:"Activity"`
+	SessionId string `json:"SessionID"`
+	Time      int64  `json:"Time"`
+	UpTimeMs  int64  `json:"UpTime"`
+}
+
+type SpinnerStateLogProps struct{}
+
+func (p *SpinnerStateLogProps) New() interface{} {
+	return &SpinnerStateLog{}
+}
+
+func NewSpinnerStateParser() phonelab.Parser {
+	return phonelab.NewJSONParser(&SpinnerStateLogProps{})
+}
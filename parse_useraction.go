@@ -0,0 +1,60 @@
+package libphonelabgo
+
+import (
+	phonelab "github.com/shaseley/phonelab-go"
+)
+
+// User-level key actions logged by the framework when a key event is
+// delivered to an app. Times are uptime milliseconds, the same clock as the
+// InputDispatcher timestamps.
+type KeyEventUserActionLog struct {
+	phonelab.PLLog
+	Action        string `json:"Action"`
+	Method        string `json:"Method"`
+	Pid           int    `json:"Pid"`
+	Uid           int    `json:"Uid"`
+	Tid           int    `json:"Tid"`
+	KeyAction     int    `json:"MAction"`
+	ActionString  string `json:"ActionString"`
+	KeyCode       int    `json:"MKeyCode"`
+	KeyCodeString string `json:"KeyCodeString"`
+	RepeatCount   int    `json:"RepeatCount"`
+	DeviceId      int    `json:"DeviceId"`
+	Time          int64  `json:"Time"`
+	UpTimeMs      int64  `json:"UpTime"`
+	DownTimeMs    int64  `json:"DownTime"`
+	EventTimeMs   int64  `json:"EventTime"`
+}
+
+// Method for key events
+const UserActionMethodKeyEvent = "KeyEvent"
+
+// KeyCharacterMap.VIRTUAL_KEYBOARD, the device id of keys that didn't come
+// from a real input device
+const KEY_DEVICE_VIRTUAL_KEYBOARD = -1
+
+// Whether the key was probably injected, e.g. by adb shell input keyevent.
+// These logs have no policy flags (see InputPolicy.NonHuman), so this goes by
+// the device id. The soft navigation bar also sends its keys from the virtual
+// keyboard, so back, home and recents always count as human; telling those
+// apart needs the dispatcher logs.
+func (log *KeyEventUserActionLog) NonHuman() bool {
+	if log.DeviceId != KEY_DEVICE_VIRTUAL_KEYBOARD {
+		return false
+	}
+	switch log.KeyCode {
+	case KEYCODE_BACK, KEYCODE_HOME, KEYCODE_APP_SWITCH:
+		return false
+	}
+	return true
+}
+
+type KeyEventUserActionLogProps struct{}
+
+func (p *KeyEventUserActionLogProps) New() interface{} {
+	return &KeyEventUserActionLog{}
+}
+
+func NewKeyEventUserActionParser() phonelab.Parser {
+	return phonelab.NewJSONParser(&KeyEventUserActionLogProps{})
+}
@@ -0,0 +1,241 @@
+package libphonelabgo
+
+import (
+	phonelab "github.com/shaseley/phonelab-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseKeyEventUserAction(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	payload := `{"Action":"HardwareTouchEvent","Method":"KeyEvent","Pid":1692,"Uid":10029,"Tid":1692,"MAction":1,"ActionString":"ACTION_UP","MKeyCode":4,"KeyCodeString":"KEYCODE_BACK","RepeatCount":0,"DeviceId":-1,"Time":1480665847958,"UpTime":184578720,"DownTime":184578623,"EventTime":184578720,"timestamp":1480665847958,"uptimeNanos":209199694405090,"LogFormat":"1.1"}`
+
+	parser := NewKeyEventUserActionParser()
+	require.NotNil(parser)
+
+	log, err := parser.Parse(payload)
+	require.Nil(err)
+	typedLog, ok := log.(*KeyEventUserActionLog)
+	require.True(ok)
+
+	assert.Equal(UserActionMethodKeyEvent, typedLog.Method)
+	assert.Equal(KEY_ACTION_UP, typedLog.KeyAction)
+	assert.Equal(KEYCODE_BACK, typedLog.KeyCode)
+	assert.Equal("KEYCODE_BACK", typedLog.KeyCodeString)
+	assert.Equal(-1, typedLog.DeviceId)
+	assert.Equal(int64(184578623), typedLog.DownTimeMs)
+	assert.Equal(int64(184578720), typedLog.EventTimeMs)
+}
+
+func keySourceTestLogs() []interface{} {
+	dispatcher := func(tsMs int64, code int) *phonelab.Logline {
+		return &phonelab.Logline{
+			TraceTime: float64(tsMs) / 1000.0,
+			Payload: &IFKeyEventLog{
+				Timestamp: tsMs*nsPerMs + 123456,
+				Action:    KEY_ACTION_UP,
+				KeyCode:   code,
+			},
+		}
+	}
+	userAction := func(tsMs int64, code int) *phonelab.Logline {
+		return &phonelab.Logline{
+			TraceTime: float64(tsMs+5) / 1000.0,
+			Payload: &KeyEventUserActionLog{
+				Method:      UserActionMethodKeyEvent,
+				KeyAction:   KEY_ACTION_UP,
+				KeyCode:     code,
+				EventTimeMs: tsMs,
+			},
+		}
+	}
+
+	return []interface{}{
+		// In both
+		dispatcher(1000, KEYCODE_BACK),
+		userAction(1000, KEYCODE_BACK),
+		// Only dispatched, e.g. the power key never reaches an app
+		dispatcher(2000, KEYCODE_POWER),
+		// Only in the user action feed
+		userAction(3000, KEYCODE_VOLUME_UP),
+		// Downs are ignored
+		&phonelab.Logline{Payload: &KeyEventUserActionLog{
+			Method:      UserActionMethodKeyEvent,
+			KeyAction:   KEY_ACTION_DOWN,
+			KeyCode:     KEYCODE_BACK,
+			EventTimeMs: 4000,
+		}},
+	}
+}
+
+func TestInputKeySource(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	codes := func(source int) []int {
+		res := make([]int, 0)
+		for _, r := range collectAll(&InputProcessor{
+			TouchSlop: TouchSlopScaled,
+			Source:    &sliceSource{keySourceTestLogs()},
+			KeySource: source,
+		}) {
+			if event, ok := r.(*TouchScreenEvent); ok {
+				res = append(res, event.Code)
+			}
+		}
+		return res
+	}
+
+	assert.Equal([]int{KEYCODE_BACK, KEYCODE_POWER}, codes(KeySourceDispatcher))
+	assert.Equal([]int{KEYCODE_BACK, KEYCODE_VOLUME_UP}, codes(KeySourceUserAction))
+	assert.Equal([]int{KEYCODE_BACK, KEYCODE_POWER, KEYCODE_VOLUME_UP}, codes(KeySourceBoth))
+
+	assert.Equal(KeySourceBoth, NewKeySource(map[string]interface{}{"key_source": "both"}))
+	assert.Equal(KeySourceDispatcher, NewKeySource(map[string]interface{}{}))
+	assert.Panics(func() { NewKeySource(map[string]interface{}{"key_source": "nope"}) })
+}
+
+func TestInputKeySourceMismatch(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mismatches := make([]*KeyEventMismatch, 0)
+	for _, r := range collectAll(&InputProcessor{
+		TouchSlop: TouchSlopScaled,
+		Source:    &sliceSource{keySourceTestLogs()},
+		KeySource: KeySourceBoth,
+	}) {
+		if m, ok := r.(*KeyEventMismatch); ok {
+			mismatches = append(mismatches, m)
+		}
+	}
+
+	require.Equal(2, len(mismatches))
+	assert.Equal(&KeyEventMismatch{
+		Source:    "dispatcher",
+		Code:      KEYCODE_POWER,
+		Timestamp: 2000*nsPerMs + 123456,
+		TraceTime: 2.0,
+	}, mismatches[0])
+	assert.Equal("useraction", mismatches[1].Source)
+	assert.Equal(KEYCODE_VOLUME_UP, mismatches[1].Code)
+	assert.Equal(int64(3000*nsPerMs), mismatches[1].Timestamp)
+}
+
+func TestInputKeySourceBothNonHuman(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	injected := func(tsMs int64, code int) *phonelab.Logline {
+		return &phonelab.Logline{
+			TraceTime: float64(tsMs) / 1000.0,
+			Payload: &IFKeyEventLog{
+				Timestamp:   tsMs * nsPerMs,
+				Action:      KEY_ACTION_UP,
+				KeyCode:     code,
+				PolicyFlags: POLICY_FLAG_INJECTED,
+			},
+		}
+	}
+
+	logs := keySourceTestLogs()
+	logs = append(logs,
+		// adb shell input keyevent BACK, which the app also sees
+		injected(5000, KEYCODE_BACK),
+		&phonelab.Logline{
+			TraceTime: 5.005,
+			Payload: &KeyEventUserActionLog{
+				Method:      UserActionMethodKeyEvent,
+				KeyAction:   KEY_ACTION_UP,
+				KeyCode:     KEYCODE_BACK,
+				EventTimeMs: 5000,
+			},
+		},
+		// Only dispatched
+		injected(6000, KEYCODE_POWER),
+	)
+
+	run := func(policy int) ([]int, []int) {
+		codes := make([]int, 0)
+		mismatches := make([]int, 0)
+		for _, r := range collectAll(&InputProcessor{
+			TouchSlop:     TouchSlopScaled,
+			Source:        &sliceSource{logs},
+			KeySource:     KeySourceBoth,
+			NonHumanInput: policy,
+		}) {
+			switch t := r.(type) {
+			case *TouchScreenEvent:
+				codes = append(codes, t.Code)
+			case *KeyEventMismatch:
+				mismatches = append(mismatches, t.Code)
+			}
+		}
+		return codes, mismatches
+	}
+
+	codes, mismatches := run(NonHumanInputTag)
+	assert.Equal([]int{KEYCODE_BACK, KEYCODE_POWER, KEYCODE_VOLUME_UP, KEYCODE_BACK, KEYCODE_POWER}, codes)
+	assert.Equal([]int{KEYCODE_POWER, KEYCODE_VOLUME_UP, KEYCODE_POWER}, mismatches)
+
+	// Neither copy of the injected BACK gets through, and the injected POWER
+	// isn't a mismatch.
+	codes, mismatches = run(NonHumanInputExclude)
+	assert.Equal([]int{KEYCODE_BACK, KEYCODE_POWER, KEYCODE_VOLUME_UP}, codes)
+	assert.Equal([]int{KEYCODE_POWER, KEYCODE_VOLUME_UP}, mismatches)
+}
+
+func TestInputUserActionNonHuman(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	userAction := func(tsMs int64, code, deviceId int) *phonelab.Logline {
+		return &phonelab.Logline{
+			TraceTime: float64(tsMs) / 1000.0,
+			Payload: &KeyEventUserActionLog{
+				Method:      UserActionMethodKeyEvent,
+				KeyAction:   KEY_ACTION_UP,
+				KeyCode:     code,
+				DeviceId:    deviceId,
+				EventTimeMs: tsMs,
+			},
+		}
+	}
+
+	logs := []interface{}{
+		// adb shell input keyevent VOLUME_UP
+		userAction(1000, KEYCODE_VOLUME_UP, KEY_DEVICE_VIRTUAL_KEYBOARD),
+		// The navigation bar's back button
+		userAction(2000, KEYCODE_BACK, KEY_DEVICE_VIRTUAL_KEYBOARD),
+		// The real volume key
+		userAction(3000, KEYCODE_VOLUME_UP, 2),
+	}
+
+	run := func(policy int) ([]int64, []bool) {
+		times := make([]int64, 0)
+		nonHuman := make([]bool, 0)
+		for _, r := range collectAll(&InputProcessor{
+			TouchSlop:     TouchSlopScaled,
+			Source:        &sliceSource{logs},
+			KeySource:     KeySourceUserAction,
+			NonHumanInput: policy,
+		}) {
+			event := r.(*TouchScreenEvent)
+			times = append(times, event.Timestamp/nsPerMs)
+			nonHuman = append(nonHuman, event.NonHuman)
+		}
+		return times, nonHuman
+	}
+
+	times, nonHuman := run(NonHumanInputTag)
+	assert.Equal([]int64{1000, 2000, 3000}, times)
+	assert.Equal([]bool{true, false, false}, nonHuman)
+
+	times, _ = run(NonHumanInputExclude)
+	assert.Equal([]int64{2000, 3000}, times)
+}
@@ -0,0 +1,142 @@
+package libphonelabgo
+
+import (
+	"math"
+	"sort"
+)
+
+// QuantileSketch is a streaming quantile estimator with log-spaced buckets.
+// Any quantile is within RelativeAccuracy of the true value, memory only grows
+// with the log of the value range, and two sketches with the same accuracy can
+// be merged exactly. All fields are exported so sketches can be written out
+// and merged later.
+type QuantileSketch struct {
+	RelativeAccuracy float64       `json:"relative_accuracy"`
+	Buckets          map[int]int64 `json:"buckets"`
+	ZeroCount        int64         `json:"zero_count"`
+	Count            int64         `json:"count"`
+	Sum              float64       `json:"sum"`
+	Min              float64       `json:"min"`
+	Max              float64       `json:"max"`
+}
+
+const DefaultSketchAccuracy = 0.01
+
+// Values below this are counted as zero.
+const minSketchValue = 1e-9
+
+func NewQuantileSketch(relativeAccuracy float64) *QuantileSketch {
+	if relativeAccuracy <= 0 || relativeAccuracy >= 1 {
+		relativeAccuracy = DefaultSketchAccuracy
+	}
+	return &QuantileSketch{
+		RelativeAccuracy: relativeAccuracy,
+		Buckets:          make(map[int]int64),
+	}
+}
+
+func (s *QuantileSketch) gamma() float64 {
+	return (1 + s.RelativeAccuracy) / (1 - s.RelativeAccuracy)
+}
+
+// Add a value. Negative values are clamped to zero.
+func (s *QuantileSketch) Add(v float64) {
+	if s.Count == 0 || v < s.Min {
+		s.Min = v
+	}
+	if s.Count == 0 || v > s.Max {
+		s.Max = v
+	}
+	s.Count += 1
+	s.Sum += v
+
+	if v < minSketchValue {
+		s.ZeroCount += 1
+		return
+	}
+
+	if s.Buckets == nil {
+		s.Buckets = make(map[int]int64)
+	}
+	s.Buckets[int(math.Ceil(math.Log(v)/math.Log(s.gamma())))] += 1
+}
+
+// Copy returns a sketch that shares nothing with this one.
+func (s *QuantileSketch) Copy() *QuantileSketch {
+	res := *s
+	res.Buckets = make(map[int]int64, len(s.Buckets))
+	for k, v := range s.Buckets {
+		res.Buckets[k] = v
+	}
+	return &res
+}
+
+// Merge another sketch into this one. Both must have the same accuracy.
+func (s *QuantileSketch) Merge(other *QuantileSketch) bool {
+	if other == nil || other.Count == 0 {
+		return true
+	}
+	if s.RelativeAccuracy != other.RelativeAccuracy {
+		return false
+	}
+
+	if s.Count == 0 || other.Min < s.Min {
+		s.Min = other.Min
+	}
+	if s.Count == 0 || other.Max > s.Max {
+		s.Max = other.Max
+	}
+	s.Count += other.Count
+	s.Sum += other.Sum
+	s.ZeroCount += other.ZeroCount
+
+	if s.Buckets == nil {
+		s.Buckets = make(map[int]int64)
+	}
+	for k, c := range other.Buckets {
+		s.Buckets[k] += c
+	}
+	return true
+}
+
+func (s *QuantileSketch) Mean() float64 {
+	if s.Count == 0 {
+		return 0.0
+	}
+	return s.Sum / float64(s.Count)
+}
+
+// Estimate quantile q in [0, 1].
+func (s *QuantileSketch) Quantile(q float64) float64 {
+	if s.Count == 0 {
+		return 0.0
+	}
+
+	rank := int64(math.Ceil(q*float64(s.Count))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+
+	if rank < s.ZeroCount {
+		return math.Max(0.0, s.Min)
+	}
+
+	keys := make([]int, 0, len(s.Buckets))
+	for k := range s.Buckets {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	seen := s.ZeroCount
+	gamma := s.gamma()
+	for _, k := range keys {
+		seen += s.Buckets[k]
+		if seen > rank {
+			// Bucket k covers (gamma^(k-1), gamma^k]
+			v := 2 * math.Pow(gamma, float64(k)) / (gamma + 1)
+			return math.Max(s.Min, math.Min(s.Max, v))
+		}
+	}
+
+	return s.Max
+}
@@ -0,0 +1,330 @@
+package libphonelabgo
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// record_writers.go has the streaming writers used by the DumbCollector. Each
+// writer writes records as they arrive, so nothing is buffered in memory
+// beyond the encoders themselves.
+
+// Output formats
+const (
+	OutputFormatJSON      = "json"
+	OutputFormatJSONLines = "jsonl"
+	OutputFormatCSV       = "csv"
+)
+
+// RecordWriter writes a stream of records.
+type RecordWriter interface {
+	Write(record interface{}) error
+	Close() error
+}
+
+// JSONLinesWriter writes one JSON object per line.
+type JSONLinesWriter struct {
+	w   io.WriteCloser
+	enc *json.Encoder
+}
+
+func NewJSONLinesWriter(w io.WriteCloser) *JSONLinesWriter {
+	return &JSONLinesWriter{
+		w:   w,
+		enc: json.NewEncoder(w),
+	}
+}
+
+func (jw *JSONLinesWriter) Write(record interface{}) error {
+	return jw.enc.Encode(record)
+}
+
+func (jw *JSONLinesWriter) Close() error {
+	return jw.w.Close()
+}
+
+// CSVWriter writes flattened records of a single kind. The header is written
+// with the first record.
+type CSVWriter struct {
+	w           io.WriteCloser
+	csv         *csv.Writer
+	kind        string
+	wroteHeader bool
+}
+
+func NewCSVWriter(w io.WriteCloser, kind string) *CSVWriter {
+	return &CSVWriter{
+		w:    w,
+		csv:  csv.NewWriter(w),
+		kind: kind,
+	}
+}
+
+func (cw *CSVWriter) Write(record interface{}) error {
+	flat := flattenerFor(record)
+	if flat.kind != cw.kind {
+		return fmt.Errorf("Cannot write record of kind '%v' to '%v' CSV", flat.kind, cw.kind)
+	}
+
+	if !cw.wroteHeader {
+		if err := cw.csv.Write(flat.header); err != nil {
+			return err
+		}
+		cw.wroteHeader = true
+	}
+
+	return cw.csv.Write(flat.row(record))
+}
+
+func (cw *CSVWriter) Close() error {
+	cw.csv.Flush()
+	if err := cw.csv.Error(); err != nil {
+		cw.w.Close()
+		return err
+	}
+	return cw.w.Close()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// CSV flattening
+
+type csvFlattener struct {
+	kind   string
+	header []string
+	row    func(interface{}) []string
+}
+
+// Kind of record, used for naming CSV files.
+func RecordKind(record interface{}) string {
+	return flattenerFor(record).kind
+}
+
+func flattenerFor(record interface{}) *csvFlattener {
+	switch record.(type) {
+	case *InputEventResult:
+		return inputEventResultFlattener
+	case *Spinner:
+		return spinnerFlattener
+	case *FrameDiffSample:
+		return frameDiffSampleFlattener
+	case *TouchScreenEvent:
+		return touchScreenEventFlattener
+	default:
+		return genericFlattener(record)
+	}
+}
+
+func fmtInt(v int64) string {
+	return strconv.FormatInt(v, 10)
+}
+
+func fmtFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+var inputEventResultFlattener = &csvFlattener{
+	kind: "input_event_result",
+	header: []string{
+		"timestamp_ns", "finish_ns", "finish_type", "event_type",
+		"local_start_ns", "local_end_ns", "global_start_ns", "global_end_ns",
+		"touch_response_ms", "global_response_ms", "local_duration_ms",
+		"global_duration_ms", "num_jank", "scroll_stop_ns", "incomplete",
+	},
+	row: func(record interface{}) []string {
+		res := record.(*InputEventResult)
+		return []string{
+			fmtInt(res.TimestampNs),
+			fmtInt(res.FinishNs),
+			strconv.Itoa(res.FinishType),
+			strconv.Itoa(res.EventType),
+			fmtInt(res.LocalResponse.StartNs),
+			fmtInt(res.LocalResponse.EndNs),
+			fmtInt(res.GlobalResponse.StartNs),
+			fmtInt(res.GlobalResponse.EndNs),
+			fmtInt(res.TouchResponseMs()),
+			fmtInt(res.GlobalResponseMs()),
+			fmtInt(res.LocalResponseDurationMs()),
+			fmtInt(res.GlobalResponseDurationMs()),
+			strconv.Itoa(len(res.Jank)),
+			fmtInt(res.ScrollStopNs),
+			strconv.FormatBool(res.Incomplete),
+		}
+	},
+}
+
+var spinnerFlattener = &csvFlattener{
+	kind: "spinner",
+	header: []string{
+		"start_time_ms", "end_time_ms", "duration_ms", "trace_time_start",
+		"trace_time_end", "incomplete",
+	},
+	row: func(record interface{}) []string {
+		s := record.(*Spinner)
+		return []string{
+			fmtInt(s.StartTimeMs),
+			fmtInt(s.EndTimeMs),
+			fmtInt(s.DurationMs),
+			fmtFloat(s.TraceTimeStart),
+			fmtFloat(s.TraceTimeEnd),
+			strconv.FormatBool(s.Incomplete),
+		}
+	},
+}
+
+var frameDiffSampleFlattener = &csvFlattener{
+	kind: "frame_diff",
+	header: []string{
+		"timestamp_ms", "trace_time_adj", "pct_diff", "mode", "color",
+		"grid_wh", "num_grid_entries", "grid", "inserted",
+	},
+	row: func(record interface{}) []string {
+		sample := record.(*FrameDiffSample)
+
+		// Grid entries are packed as pos:value pairs
+		entries := make([]string, 0, len(sample.GridEntries))
+		for _, entry := range sample.GridEntries {
+			entries = append(entries, strconv.Itoa(entry.Position)+":"+fmtFloat(entry.Value))
+		}
+
+		return []string{
+			fmtInt(sample.Timestamp),
+			fmtFloat(sample.TraceTimeAdj),
+			fmtFloat(sample.PctDiff),
+			strconv.Itoa(sample.Mode),
+			strconv.Itoa(sample.HasColor),
+			strconv.Itoa(sample.GridWH),
+			strconv.Itoa(len(sample.GridEntries)),
+			strings.Join(entries, ";"),
+			strconv.FormatBool(sample.Inserted),
+		}
+	},
+}
+
+var touchScreenEventFlattener = &csvFlattener{
+	kind: "touch_event",
+	header: []string{
+		"what", "timestamp", "tracetime", "x", "y", "code",
+	},
+	row: func(record interface{}) []string {
+		event := record.(*TouchScreenEvent)
+		return []string{
+			strconv.Itoa(event.What),
+			fmtInt(event.Timestamp),
+			fmtFloat(event.TraceTime),
+			fmtFloat(event.X),
+			fmtFloat(event.Y),
+			strconv.Itoa(event.Code),
+		}
+	},
+}
+
+// Anything else is written as a single JSON column.
+func genericFlattener(record interface{}) *csvFlattener {
+	kind := "unknown"
+	if t := reflect.TypeOf(record); t != nil {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		kind = strings.ToLower(t.Name())
+	}
+
+	return &csvFlattener{
+		kind:   kind,
+		header: []string{"json"},
+		row: func(record interface{}) []string {
+			bytes, err := json.Marshal(record)
+			if err != nil {
+				return []string{""}
+			}
+			return []string{string(bytes)}
+		},
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Output plumbing
+
+// gzipWriteCloser closes both the gzip stream and the underlying writer.
+type gzipWriteCloser struct {
+	*gzip.Writer
+	under io.WriteCloser
+}
+
+func (g *gzipWriteCloser) Close() error {
+	if err := g.Writer.Close(); err != nil {
+		g.under.Close()
+		return err
+	}
+	return g.under.Close()
+}
+
+func newGzipWriteCloser(w io.WriteCloser) io.WriteCloser {
+	return &gzipWriteCloser{
+		Writer: gzip.NewWriter(w),
+		under:  w,
+	}
+}
+
+// nopWriteCloser keeps stdout open when a writer is closed.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// Make a string safe to use as part of a file name. Paths are shortened to
+// their base name plus a hash of the whole path, since logs from different
+// devices usually have the same base name.
+func sanitizeFileComponent(s string) string {
+	name := strings.TrimSuffix(s, ".gz")
+	if idx := strings.LastIndex(name, "/"); idx >= 0 {
+		h := fnv.New32a()
+		h.Write([]byte(s))
+		name = fmt.Sprintf("%v-%08x", name[idx+1:], h.Sum32())
+	}
+	return sanitizeFileName(name)
+}
+
+// Replace anything that isn't safe in a file name, including path separators.
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+// Build an output file name from a base name and any number of qualifiers,
+// e.g. out.csv + [file.log, spinner] -> out-file.log-spinner.csv.
+func qualifiedFileName(base string, gz bool, qualifiers ...string) string {
+	base = strings.TrimSuffix(base, ".gz")
+
+	ext := ""
+	if idx := strings.LastIndex(base, "."); idx > strings.LastIndex(base, "/") {
+		ext = base[idx:]
+		base = base[:idx]
+	}
+
+	for _, q := range qualifiers {
+		if len(q) > 0 {
+			base += "-" + sanitizeFileComponent(q)
+		}
+	}
+
+	name := base + ext
+	if gz {
+		name += ".gz"
+	}
+	return name
+}
@@ -0,0 +1,747 @@
+package libphonelabgo
+
+import (
+	"fmt"
+	phonelab "github.com/shaseley/phonelab-go"
+	"hash/fnv"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// report_html.go renders the results for one log file as a single static HTML
+// page: SVG timelines, response time histograms, a jank table and per-app
+// breakdowns. Everything is inline, so the file can be opened anywhere without
+// network access.
+
+// SessionReport accumulates the results for a single session (log file).
+type SessionReport struct {
+	Title      string
+	Results    []*InputEventResult
+	Spinners   []*Spinner
+	FramesNs   []int64
+	AppChanges []*AppChangeEvent
+	Gaps       []*DataGap
+}
+
+func NewSessionReport(title string) *SessionReport {
+	return &SessionReport{
+		Title:      title,
+		Results:    make([]*InputEventResult, 0),
+		Spinners:   make([]*Spinner, 0),
+		FramesNs:   make([]int64, 0),
+		AppChanges: make([]*AppChangeEvent, 0),
+		Gaps:       make([]*DataGap, 0),
+	}
+}
+
+// Add a result to the report. Returns false if the type isn't used in reports.
+func (r *SessionReport) Add(data interface{}) bool {
+	switch t := data.(type) {
+	case *InputEventResult:
+		r.Results = append(r.Results, t)
+	case *Spinner:
+		r.Spinners = append(r.Spinners, t)
+	case *FrameRefreshEvent:
+		r.FramesNs = append(r.FramesNs, t.SysTimeNs)
+	case *AppChangeEvent:
+		r.AppChanges = append(r.AppChanges, t)
+	case *DataGap:
+		r.Gaps = append(r.Gaps, t)
+	default:
+		return false
+	}
+	return true
+}
+
+// Session start and end, in ns.
+func (r *SessionReport) bounds() (int64, int64) {
+	start, end := int64(-1), int64(-1)
+
+	see := func(ns int64) {
+		if ns <= 0 {
+			return
+		}
+		if start < 0 || ns < start {
+			start = ns
+		}
+		if ns > end {
+			end = ns
+		}
+	}
+
+	for _, res := range r.Results {
+		see(res.TimestampNs)
+		see(res.FinishNs)
+	}
+	for _, s := range r.Spinners {
+		see(s.StartTimeMs * nsPerMs)
+		see(s.EndTimeMs * nsPerMs)
+	}
+	for _, ns := range r.FramesNs {
+		see(ns)
+	}
+	for _, event := range r.AppChanges {
+		see(event.TimestampNs)
+	}
+	for _, gap := range r.Gaps {
+		see(gap.StartNs)
+		see(gap.EndNs)
+	}
+
+	if start < 0 {
+		return 0, 0
+	}
+	return start, end
+}
+
+func (r *SessionReport) WriteHTML(w io.Writer) error {
+	return sessionReportTemplate.Execute(w, r.view())
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// View model
+
+const (
+	reportLabelWidth = 130
+	reportPlotWidth  = 1000
+	reportLaneHeight = 24
+	reportHistWidth  = 520
+	reportHistHeight = 180
+)
+
+var frameJankClassNames = map[int]string{
+	FrameJankNone:       "",
+	FrameJankSingleDrop: "single drop",
+	FrameJankMultiDrop:  "multi drop",
+	FrameJankLongStall:  "long stall",
+}
+
+var reportPalette = []string{
+	"#4e79a7", "#f28e2b", "#e15759", "#76b7b2", "#59a14f",
+	"#edc948", "#b07aa1", "#ff9da7", "#9c755f", "#bab0ac",
+}
+
+var reportEventColors = map[int]string{
+	TouchScreenEventTap:         "#4e79a7",
+	TouchScreenEventKey:         "#59a14f",
+	TouchScreenEventScrollStart: "#f28e2b",
+	TouchScreenEventKeystroke:   "#edc948",
+}
+
+type reportView struct {
+	Title    string
+	Summary  []reportStat
+	Timeline *svgTimeline
+	Response *svgHistogram
+	Spinner  *svgHistogram
+	Jank     []*reportJankRow
+	Apps     []*reportAppRow
+}
+
+type reportStat struct {
+	Label string
+	Value string
+}
+
+type svgRect struct {
+	X, Width float64
+	Fill     string
+	Title    string
+}
+
+type svgLane struct {
+	Label string
+	Y     int
+	Rects []*svgRect
+}
+
+type svgTick struct {
+	X     float64
+	Label string
+}
+
+type svgTimeline struct {
+	Width, Height int
+	PlotX         int
+	AxisY         int
+	Lanes         []*svgLane
+	Ticks         []*svgTick
+
+	// Frame rate, as a polyline
+	FPSY      int
+	FPSHeight int
+	FPSPoints string
+	FPSMax    int
+}
+
+type svgBar struct {
+	X, Y, Width, Height float64
+	Title               string
+}
+
+type svgHistogram struct {
+	Width, Height int
+	AxisY         int
+	Bars          []*svgBar
+	Ticks         []*svgTick
+	Count         int
+}
+
+type reportJankRow struct {
+	TimeS        string
+	App          string
+	Input        string
+	JankMs       int64
+	MissedVsyncs int
+	Class        string
+}
+
+type reportAppRow struct {
+	App          string
+	ForegroundS  string
+	Inputs       int
+	Responses    int
+	MedianMs     string
+	P90Ms        string
+	Timeouts     int
+	Jank         int
+	Spinners     int
+	SpinnerTimeS string
+}
+
+func fmtSeconds(ns int64) string {
+	return fmt.Sprintf("%.1f", float64(ns)/nsPerSecF)
+}
+
+// Stable color per app name
+func appColor(app string) string {
+	h := fnv.New32a()
+	h.Write([]byte(app))
+	return reportPalette[h.Sum32()%uint32(len(reportPalette))]
+}
+
+func (r *SessionReport) view() *reportView {
+	startNs, endNs := r.bounds()
+	apps := NewAppTimeline(r.AppChanges)
+
+	appFor := func(res *InputEventResult) string {
+		if len(res.App) > 0 {
+			return res.App
+		}
+		if app := apps.AppAt(res.TimestampNs); len(app) > 0 {
+			return app
+		}
+		return UnknownApp
+	}
+
+	view := &reportView{
+		Title: r.Title,
+	}
+
+	// Summary
+	counts := make(map[int]int)
+	for _, res := range r.Results {
+		counts[res.EventType] += 1
+	}
+	spinnerMs := int64(0)
+	for _, s := range r.Spinners {
+		spinnerMs += s.DurationMs
+	}
+
+	view.Summary = []reportStat{
+		{"Duration (s)", fmtSeconds(endNs - startNs)},
+		{"Taps", fmt.Sprint(counts[TouchScreenEventTap])},
+		{"Scrolls", fmt.Sprint(counts[TouchScreenEventScrollStart])},
+		{"Keys", fmt.Sprint(counts[TouchScreenEventKey])},
+		{"Keystrokes", fmt.Sprint(counts[TouchScreenEventKeystroke])},
+		{"Spinners", fmt.Sprint(len(r.Spinners))},
+		{"Spinner time (s)", fmtSeconds(spinnerMs * nsPerMs)},
+		{"Frames", fmt.Sprint(len(r.FramesNs))},
+		{"Data gaps", fmt.Sprint(len(r.Gaps))},
+	}
+
+	view.Timeline = r.timeline(startNs, endNs, appFor)
+	view.Response, view.Spinner = r.histograms()
+
+	// Jank
+	view.Jank = make([]*reportJankRow, 0)
+	for _, res := range r.Results {
+		for _, jank := range res.Jank {
+			view.Jank = append(view.Jank, &reportJankRow{
+				TimeS:        fmtSeconds(jank.TimestampNs - startNs),
+				App:          appFor(res),
+				Input:        TouchScreenEventName(res.EventType),
+				JankMs:       jank.JankAmount,
+				MissedVsyncs: jank.MissedVsyncs,
+				Class:        frameJankClassNames[jank.Class],
+			})
+		}
+	}
+
+	view.Apps = r.appRows(startNs, endNs, apps, appFor)
+
+	return view
+}
+
+func (r *SessionReport) timeline(startNs, endNs int64,
+	appFor func(*InputEventResult) string) *svgTimeline {
+
+	spanNs := float64(endNs - startNs)
+	if spanNs <= 0 {
+		spanNs = 1
+	}
+
+	xOf := func(ns int64) float64 {
+		return reportLabelWidth + float64(ns-startNs)/spanNs*reportPlotWidth
+	}
+
+	rect := func(fromNs, toNs int64, fill, title string) *svgRect {
+		x := xOf(fromNs)
+		width := xOf(toNs) - x
+		if width < 1.0 {
+			width = 1.0
+		}
+		return &svgRect{X: x, Width: width, Fill: fill, Title: title}
+	}
+
+	lanes := make([]*svgLane, 0)
+	addLane := func(label string) *svgLane {
+		lane := &svgLane{
+			Label: label,
+			Y:     len(lanes) * reportLaneHeight,
+			Rects: make([]*svgRect, 0),
+		}
+		lanes = append(lanes, lane)
+		return lane
+	}
+
+	// Apps
+	appLane := addLane("App")
+	changes := NewAppTimeline(r.AppChanges).changes
+	for i, event := range changes {
+		to := endNs
+		if i+1 < len(changes) {
+			to = changes[i+1].TimestampNs
+		}
+		appLane.Rects = append(appLane.Rects, rect(event.TimestampNs, to, appColor(event.App), event.App))
+	}
+
+	inputLane := addLane("Input")
+	localLane := addLane("Local response")
+	globalLane := addLane("Global response")
+	jankLane := addLane("Jank")
+
+	for _, res := range r.Results {
+		name := TouchScreenEventName(res.EventType)
+		color, ok := reportEventColors[res.EventType]
+		if !ok {
+			color = "#bab0ac"
+		}
+
+		title := fmt.Sprintf("%v at %vs (%v), finished by %v", name,
+			fmtSeconds(res.TimestampNs-startNs), appFor(res), finishTypeNames[res.FinishType])
+		inputLane.Rects = append(inputLane.Rects, rect(res.TimestampNs, res.FinishNs, color, title))
+
+		if res.HasLocalResponse() && res.LocalResponse.EndNs != InvalidResponseTime {
+			title := fmt.Sprintf("local response after %v ms, lasting %v ms",
+				res.TouchResponseMs(), res.LocalResponseDurationMs())
+			localLane.Rects = append(localLane.Rects,
+				rect(res.LocalResponse.StartNs, res.LocalResponse.EndNs, "#76b7b2", title))
+		}
+
+		if res.HasGlobalResponse() && res.GlobalResponse.EndNs != InvalidResponseTime {
+			title := fmt.Sprintf("global response after %v ms, lasting %v ms",
+				res.GlobalResponseMs(), res.GlobalResponseDurationMs())
+			globalLane.Rects = append(globalLane.Rects,
+				rect(res.GlobalResponse.StartNs, res.GlobalResponse.EndNs, "#59a14f", title))
+		}
+
+		for _, jank := range res.Jank {
+			title := fmt.Sprintf("%v ms jank", jank.JankAmount)
+			jankLane.Rects = append(jankLane.Rects,
+				rect(jank.TimestampNs-jank.JankAmount*nsPerMs, jank.TimestampNs, "#e15759", title))
+		}
+	}
+
+	spinnerLane := addLane("Spinners")
+	for _, s := range r.Spinners {
+		title := fmt.Sprintf("spinner, %v ms", s.DurationMs)
+		spinnerLane.Rects = append(spinnerLane.Rects,
+			rect(s.StartTimeMs*nsPerMs, s.EndTimeMs*nsPerMs, "#b07aa1", title))
+	}
+
+	if len(r.Gaps) > 0 {
+		gapLane := addLane("Data gaps")
+		for _, gap := range r.Gaps {
+			title := fmt.Sprintf("%v: %v missing", gap.Stream, gap.MissingTokens())
+			gapLane.Rects = append(gapLane.Rects, rect(gap.StartNs, gap.EndNs, "#bab0ac", title))
+		}
+	}
+
+	tl := &svgTimeline{
+		Width: reportLabelWidth + reportPlotWidth + 10,
+		PlotX: reportLabelWidth,
+		Lanes: lanes,
+	}
+
+	y := len(lanes) * reportLaneHeight
+
+	// Frame rate, in 1 s bins
+	if len(r.FramesNs) > 0 {
+		tl.FPSY = y + 4
+		tl.FPSHeight = 60
+
+		bins := make([]int, int((endNs-startNs)/nsPerSec)+1)
+		for _, ns := range r.FramesNs {
+			bins[(ns-startNs)/nsPerSec] += 1
+		}
+
+		tl.FPSMax = 60
+		for _, count := range bins {
+			if count > tl.FPSMax {
+				tl.FPSMax = count
+			}
+		}
+
+		points := make([]string, 0, len(bins))
+		for i, count := range bins {
+			x := xOf(startNs + int64(i)*nsPerSec + nsPerSec/2)
+			py := float64(tl.FPSY+tl.FPSHeight) - float64(count)/float64(tl.FPSMax)*float64(tl.FPSHeight)
+			points = append(points, fmt.Sprintf("%.1f,%.1f", x, py))
+		}
+		tl.FPSPoints = strings.Join(points, " ")
+
+		y = tl.FPSY + tl.FPSHeight + 4
+	}
+
+	// Axis, roughly 10 ticks on whole seconds
+	tl.AxisY = y + 2
+	tl.Height = tl.AxisY + 20
+
+	stepS := int64(spanNs/nsPerSecF/10) + 1
+	tl.Ticks = make([]*svgTick, 0)
+	for s := int64(0); s*nsPerSec <= endNs-startNs; s += stepS {
+		tl.Ticks = append(tl.Ticks, &svgTick{
+			X:     xOf(startNs + s*nsPerSec),
+			Label: fmt.Sprintf("%vs", s),
+		})
+	}
+
+	return tl
+}
+
+// Build a histogram over fixed width bins. The last bin collects everything
+// at or above the limit.
+func newSVGHistogram(values []int64, binWidth, limit int64) *svgHistogram {
+	numBins := int(limit/binWidth) + 1
+	counts := make([]int, numBins)
+	for _, v := range values {
+		i := int(v / binWidth)
+		if i >= numBins {
+			i = numBins - 1
+		} else if i < 0 {
+			i = 0
+		}
+		counts[i] += 1
+	}
+
+	maxCount := 1
+	for _, c := range counts {
+		if c > maxCount {
+			maxCount = c
+		}
+	}
+
+	hist := &svgHistogram{
+		Width:  reportHistWidth,
+		Height: reportHistHeight,
+		AxisY:  reportHistHeight - 20,
+		Bars:   make([]*svgBar, 0, numBins),
+		Ticks:  make([]*svgTick, 0),
+		Count:  len(values),
+	}
+
+	plotH := float64(hist.AxisY - 10)
+	barW := float64(reportHistWidth-20) / float64(numBins)
+
+	for i, c := range counts {
+		h := float64(c) / float64(maxCount) * plotH
+		lo := int64(i) * binWidth
+
+		var title string
+		if i == numBins-1 {
+			title = fmt.Sprintf(">= %v ms: %v", lo, c)
+		} else {
+			title = fmt.Sprintf("%v-%v ms: %v", lo, lo+binWidth, c)
+		}
+
+		hist.Bars = append(hist.Bars, &svgBar{
+			X:      10 + float64(i)*barW,
+			Y:      float64(hist.AxisY) - h,
+			Width:  barW - 1,
+			Height: h,
+			Title:  title,
+		})
+
+		if i%4 == 0 {
+			hist.Ticks = append(hist.Ticks, &svgTick{
+				X:     10 + float64(i)*barW,
+				Label: fmt.Sprint(lo),
+			})
+		}
+	}
+
+	return hist
+}
+
+func (r *SessionReport) histograms() (*svgHistogram, *svgHistogram) {
+	responses := make([]int64, 0)
+	for _, res := range r.Results {
+		if res.HasResponse() {
+			responses = append(responses, res.TouchResponseMs())
+		}
+	}
+
+	durations := make([]int64, 0, len(r.Spinners))
+	for _, s := range r.Spinners {
+		durations = append(durations, s.DurationMs)
+	}
+
+	return newSVGHistogram(responses, 50, 1000), newSVGHistogram(durations, 250, 5000)
+}
+
+func (r *SessionReport) appRows(startNs, endNs int64, apps *AppTimeline,
+	appFor func(*InputEventResult) string) []*reportAppRow {
+
+	rows := make(map[string]*reportAppRow)
+	responses := make(map[string][]float64)
+	foregroundNs := make(map[string]int64)
+	spinnerMs := make(map[string]int64)
+
+	row := func(app string) *reportAppRow {
+		if _, ok := rows[app]; !ok {
+			rows[app] = &reportAppRow{App: app}
+		}
+		return rows[app]
+	}
+
+	for i, event := range apps.changes {
+		to := endNs
+		if i+1 < len(apps.changes) {
+			to = apps.changes[i+1].TimestampNs
+		}
+		row(event.App)
+		foregroundNs[event.App] += to - event.TimestampNs
+	}
+
+	for _, res := range r.Results {
+		app := appFor(res)
+		appRow := row(app)
+		appRow.Inputs += 1
+		appRow.Jank += len(res.Jank)
+		if res.FinishType == TapEventFinishTimeout {
+			appRow.Timeouts += 1
+		}
+		if res.HasResponse() {
+			appRow.Responses += 1
+			responses[app] = append(responses[app], float64(res.TouchResponseMs()))
+		}
+	}
+
+	for _, s := range r.Spinners {
+		app := apps.AppAt(s.StartTimeMs * nsPerMs)
+		if len(app) == 0 {
+			app = UnknownApp
+		}
+		row(app).Spinners += 1
+		spinnerMs[app] += s.DurationMs
+	}
+
+	res := make([]*reportAppRow, 0, len(rows))
+	for app, appRow := range rows {
+		appRow.ForegroundS = fmtSeconds(foregroundNs[app])
+		appRow.SpinnerTimeS = fmtSeconds(spinnerMs[app] * nsPerMs)
+
+		appRow.MedianMs, appRow.P90Ms = "-", "-"
+		if values := responses[app]; len(values) > 0 {
+			sort.Float64s(values)
+			appRow.MedianMs = fmt.Sprint(percentileSorted(values, 0.5))
+			appRow.P90Ms = fmt.Sprint(percentileSorted(values, 0.9))
+		}
+		res = append(res, appRow)
+	}
+
+	// Busiest apps first
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Inputs != res[j].Inputs {
+			return res[i].Inputs > res[j].Inputs
+		}
+		return res[i].App < res[j].App
+	})
+
+	return res
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Template
+
+var sessionReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.1em; margin-top: 2em; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 3px 8px; text-align: right; }
+th { background: #f4f4f4; }
+td:first-child, th:first-child { text-align: left; }
+.stats td { text-align: left; }
+.lane-label { font-size: 11px; fill: #444; }
+.tick { font-size: 10px; fill: #666; }
+.hists { display: flex; flex-wrap: wrap; gap: 2em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+
+<table class="stats">
+{{range .Summary}}<tr><th>{{.Label}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+
+<h2>Timeline</h2>
+{{with .Timeline}}<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}">
+{{range .Lanes}}<g transform="translate(0,{{.Y}})">
+<text class="lane-label" x="0" y="16">{{.Label}}</text>
+<rect x="{{$.Timeline.PlotX}}" y="2" width="1000" height="20" fill="#fafafa"/>
+{{range .Rects}}<rect x="{{printf "%.2f" .X}}" y="3" width="{{printf "%.2f" .Width}}" height="18" fill="{{.Fill}}" fill-opacity="0.8"><title>{{.Title}}</title></rect>
+{{end}}</g>
+{{end}}{{if .FPSPoints}}<text class="lane-label" x="0" y="{{.FPSY}}" dy="16">Frame rate</text>
+<text class="tick" x="{{.PlotX}}" y="{{.FPSY}}" dx="-20" dy="8">{{.FPSMax}}</text>
+<rect x="{{.PlotX}}" y="{{.FPSY}}" width="1000" height="{{.FPSHeight}}" fill="#fafafa"/>
+<polyline points="{{.FPSPoints}}" fill="none" stroke="#4e79a7" stroke-width="1.5"/>
+{{end}}<line x1="{{.PlotX}}" y1="{{.AxisY}}" x2="{{.Width}}" y2="{{.AxisY}}" stroke="#999"/>
+{{range .Ticks}}<text class="tick" x="{{printf "%.1f" .X}}" y="{{$.Timeline.AxisY}}" dy="14">{{.Label}}</text>
+{{end}}</svg>{{end}}
+
+<div class="hists">
+<div>
+<h2>Touch response time (ms)</h2>
+{{template "hist" .Response}}
+</div>
+<div>
+<h2>Spinner duration (ms)</h2>
+{{template "hist" .Spinner}}
+</div>
+</div>
+
+<h2>Jank</h2>
+{{if .Jank}}<table>
+<tr><th>Time (s)</th><th>App</th><th>Input</th><th>Jank (ms)</th><th>Missed vsyncs</th><th>Class</th></tr>
+{{range .Jank}}<tr><td>{{.TimeS}}</td><td>{{.App}}</td><td>{{.Input}}</td><td>{{.JankMs}}</td><td>{{.MissedVsyncs}}</td><td>{{.Class}}</td></tr>
+{{end}}</table>{{else}}<p>No jank.</p>{{end}}
+
+<h2>Apps</h2>
+{{if .Apps}}<table>
+<tr><th>App</th><th>Foreground (s)</th><th>Inputs</th><th>Responses</th><th>Median response (ms)</th><th>P90 response (ms)</th><th>Timeouts</th><th>Jank</th><th>Spinners</th><th>Spinner time (s)</th></tr>
+{{range .Apps}}<tr><td>{{.App}}</td><td>{{.ForegroundS}}</td><td>{{.Inputs}}</td><td>{{.Responses}}</td><td>{{.MedianMs}}</td><td>{{.P90Ms}}</td><td>{{.Timeouts}}</td><td>{{.Jank}}</td><td>{{.Spinners}}</td><td>{{.SpinnerTimeS}}</td></tr>
+{{end}}</table>{{else}}<p>No app data.</p>{{end}}
+</body>
+</html>
+{{define "hist"}}{{if .Count}}<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}">
+{{range .Bars}}<rect x="{{printf "%.2f" .X}}" y="{{printf "%.2f" .Y}}" width="{{printf "%.2f" .Width}}" height="{{printf "%.2f" .Height}}" fill="#4e79a7"><title>{{.Title}}</title></rect>
+{{end}}<line x1="10" y1="{{.AxisY}}" x2="{{.Width}}" y2="{{.AxisY}}" stroke="#999"/>
+{{range .Ticks}}<text class="tick" x="{{printf "%.1f" .X}}" y="{{$.AxisY}}" dy="14">{{.Label}}</text>
+{{end}}</svg>
+<p>{{.Count}} samples</p>{{else}}<p>No data.</p>{{end}}{{end}}
+`))
+
+////////////////////////////////////////////////////////////////////////////////
+
+// SessionReportCollector builds one SessionReport per source file and writes
+// them as HTML in Finish. With more than one source, the file name is
+// qualified with the source name.
+type SessionReportCollector struct {
+	Filename string
+
+	reports map[string]*SessionReport
+	sources []string
+	sync.Mutex
+}
+
+func NewSessionReportCollector(kwargs map[string]interface{}) *SessionReportCollector {
+	c := &SessionReportCollector{
+		reports: make(map[string]*SessionReport),
+		sources: make([]string, 0),
+	}
+
+	if v, ok := kwargs["filename"]; ok {
+		c.Filename, _ = v.(string)
+	}
+
+	return c
+}
+
+func (c *SessionReportCollector) OnData(data interface{}, info phonelab.PipelineSourceInfo) {
+	c.Lock()
+	defer c.Unlock()
+
+	source := ""
+	if info != nil {
+		source = info.Context()
+	}
+
+	report, ok := c.reports[source]
+	if !ok {
+		title := "Session report"
+		if len(source) > 0 {
+			title += ": " + source
+		}
+		report = NewSessionReport(title)
+		c.reports[source] = report
+		c.sources = append(c.sources, source)
+	}
+
+	report.Add(data)
+}
+
+func (c *SessionReportCollector) Finish() {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, source := range c.sources {
+		if err := c.write(source); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing report: %v\n", err)
+		}
+	}
+}
+
+func (c *SessionReportCollector) write(source string) error {
+	report := c.reports[source]
+
+	if len(c.Filename) == 0 {
+		return report.WriteHTML(os.Stdout)
+	}
+
+	name := c.Filename
+	if len(c.sources) > 1 {
+		name = qualifiedFileName(c.Filename, false, source)
+	}
+
+	file, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("Error creating report file: %v", err)
+	}
+
+	if err = report.WriteHTML(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,125 @@
+package libphonelabgo
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func reportTestResult(tsMs, responseMs int64, app string) *InputEventResult {
+	res := NewInputEventResult(&TouchScreenEvent{
+		What:      TouchScreenEventTap,
+		Timestamp: tsMs * nsPerMs,
+	})
+	res.FinishNs = (tsMs + 1000) * nsPerMs
+	res.LocalResponse.StartNs = (tsMs + responseMs) * nsPerMs
+	res.LocalResponse.EndNs = (tsMs + responseMs + 200) * nsPerMs
+	res.App = app
+	return res
+}
+
+func TestSessionReportAppRows(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	report := NewSessionReport("test")
+
+	assert.True(report.Add(&AppChangeEvent{TimestampNs: 0, App: "a"}))
+	assert.True(report.Add(&AppChangeEvent{TimestampNs: 10000 * nsPerMs, App: "b"}))
+	assert.True(report.Add(reportTestResult(1000, 100, "")))
+	assert.True(report.Add(reportTestResult(3000, 300, "")))
+	assert.True(report.Add(reportTestResult(11000, 50, "")))
+	assert.True(report.Add(&Spinner{StartTimeMs: 12000, EndTimeMs: 13500, DurationMs: 1500}))
+	assert.True(report.Add(&FrameRefreshEvent{SysTimeNs: 20000 * nsPerMs}))
+	assert.False(report.Add("something else"))
+
+	view := report.view()
+	require.Equal(2, len(view.Apps))
+
+	a := view.Apps[0]
+	assert.Equal("a", a.App)
+	assert.Equal("10.0", a.ForegroundS)
+	assert.Equal(2, a.Inputs)
+	assert.Equal(2, a.Responses)
+	assert.Equal("100", a.MedianMs)
+	assert.Equal("300", a.P90Ms)
+	assert.Equal(0, a.Spinners)
+
+	b := view.Apps[1]
+	assert.Equal("b", b.App)
+	assert.Equal("10.0", b.ForegroundS)
+	assert.Equal(1, b.Inputs)
+	assert.Equal(1, b.Spinners)
+	assert.Equal("1.5", b.SpinnerTimeS)
+
+	assert.Equal(3, view.Response.Count)
+	assert.Equal(1, view.Spinner.Count)
+}
+
+func TestSessionReportHTML(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	res := reportTestResult(1000, 100, "<script>")
+	res.Jank = append(res.Jank, &JankEvent{
+		TimestampNs:  1500 * nsPerMs,
+		JankAmount:   120,
+		MissedVsyncs: 7,
+		Class:        FrameJankLongStall,
+	})
+
+	report := NewSessionReport("test")
+	report.Add(res)
+	report.Add(&FrameRefreshEvent{SysTimeNs: 1100 * nsPerMs})
+	report.Add(&FrameRefreshEvent{SysTimeNs: 1116 * nsPerMs})
+
+	var buf bytes.Buffer
+	require.Nil(report.WriteHTML(&buf))
+	out := buf.String()
+
+	assert.True(strings.Contains(out, "<svg"))
+	assert.True(strings.Contains(out, "<polyline"))
+	assert.True(strings.Contains(out, "long stall"))
+	assert.True(strings.Contains(out, "&lt;script&gt;"))
+	assert.False(strings.Contains(out, "<script>"))
+
+	// Self-contained
+	assert.False(strings.Contains(out, "src="))
+	assert.False(strings.Contains(out, "href="))
+
+	// Empty reports still render
+	buf.Reset()
+	require.Nil(NewSessionReport("empty").WriteHTML(&buf))
+	assert.True(strings.Contains(buf.String(), "No data."))
+}
+
+func TestSessionReportCollector(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "sessionreport")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	c := NewSessionReportCollector(map[string]interface{}{
+		"filename": filepath.Join(dir, "report.html"),
+	})
+
+	c.OnData(reportTestResult(1000, 100, "a"), &testSourceInfo{"a.log"})
+	c.OnData(reportTestResult(1000, 100, "b"), &testSourceInfo{"b.log"})
+	c.Finish()
+
+	for _, name := range []string{"report-a.log.html", "report-b.log.html"} {
+		bytes, err := ioutil.ReadFile(filepath.Join(dir, name))
+		require.Nil(err)
+		assert.True(strings.Contains(string(bytes), "Session report"))
+	}
+}
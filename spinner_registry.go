@@ -0,0 +1,340 @@
+package libphonelabgo
+
+import (
+	"bufio"
+	"fmt"
+	phonelab "github.com/shaseley/phonelab-go"
+	"os"
+	"sort"
+	"sync"
+)
+
+// spinner_registry.go maps spinner algorithm names to constructors, so new
+// detectors can be plugged into the "spinners" processor without changing
+// this package. It also has a harness to run registered algorithms over
+// fixture logs and score them against the Spinner-State-QoE ground truth.
+
+// SpinnerAlgoConstructor builds a spinner algorithm reading from source. kwargs
+// are the processor args, for anything that isn't in SpinnerAlgoConf.
+type SpinnerAlgoConstructor func(source phonelab.Processor, conf *SpinnerAlgoConf,
+	kwargs map[string]interface{}) phonelab.Processor
+
+// SpinnerHandlerConstructor builds a spinner algorithm that handles one log at
+// a time, like the naive and voting algorithms.
+type SpinnerHandlerConstructor func(conf *SpinnerAlgoConf,
+	kwargs map[string]interface{}) phonelab.ProcessorHandler
+
+var spinnerAlgoRegistry = struct {
+	sync.RWMutex
+	algos map[string]SpinnerAlgoConstructor
+}{
+	algos: make(map[string]SpinnerAlgoConstructor),
+}
+
+// RegisterSpinnerAlgo makes an algorithm available by name. Registering the
+// same name twice panics.
+func RegisterSpinnerAlgo(name string, ctor SpinnerAlgoConstructor) {
+	if len(name) == 0 || ctor == nil {
+		panic("Spinner algo needs a name and constructor")
+	}
+
+	spinnerAlgoRegistry.Lock()
+	defer spinnerAlgoRegistry.Unlock()
+
+	if _, ok := spinnerAlgoRegistry.algos[name]; ok {
+		panic("Spinner algo '" + name + "' is already registered!")
+	}
+	spinnerAlgoRegistry.algos[name] = ctor
+}
+
+// Only for tests, which shouldn't leave anything in the registry.
+func unregisterSpinnerAlgo(name string) {
+	spinnerAlgoRegistry.Lock()
+	defer spinnerAlgoRegistry.Unlock()
+
+	delete(spinnerAlgoRegistry.algos, name)
+}
+
+// RegisterSpinnerHandler registers an algorithm that runs as a
+// SimpleProcessor.
+func RegisterSpinnerHandler(name string, ctor SpinnerHandlerConstructor) {
+	if ctor == nil {
+		panic("Spinner algo needs a name and constructor")
+	}
+	RegisterSpinnerAlgo(name, func(source phonelab.Processor, conf *SpinnerAlgoConf,
+		kwargs map[string]interface{}) phonelab.Processor {
+		return phonelab.NewSimpleProcessor(source, ctor(conf, kwargs))
+	})
+}
+
+// SpinnerAlgoNames returns the registered algorithm names, sorted.
+func SpinnerAlgoNames() []string {
+	spinnerAlgoRegistry.RLock()
+	defer spinnerAlgoRegistry.RUnlock()
+
+	names := make([]string, 0, len(spinnerAlgoRegistry.algos))
+	for name := range spinnerAlgoRegistry.algos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewSpinnerAlgoProcessor builds the algorithm named by conf.Name.
+func NewSpinnerAlgoProcessor(source phonelab.Processor, conf *SpinnerAlgoConf,
+	kwargs map[string]interface{}) (phonelab.Processor, error) {
+
+	spinnerAlgoRegistry.RLock()
+	ctor, ok := spinnerAlgoRegistry.algos[conf.Name]
+	spinnerAlgoRegistry.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Cannot find algo '%v'!", conf.Name)
+	}
+	if kwargs == nil {
+		kwargs = make(map[string]interface{})
+	}
+	return ctor(source, conf, kwargs), nil
+}
+
+func init() {
+	RegisterSpinnerHandler("naive", func(conf *SpinnerAlgoConf,
+		kwargs map[string]interface{}) phonelab.ProcessorHandler {
+		return NewNaiveSpinnerAlgo(conf)
+	})
+	RegisterSpinnerHandler("voting", func(conf *SpinnerAlgoConf,
+		kwargs map[string]interface{}) phonelab.ProcessorHandler {
+		return NewVotingSpinnerAlgo(conf)
+	})
+	RegisterSpinnerHandler("region", func(conf *SpinnerAlgoConf,
+		kwargs map[string]interface{}) phonelab.ProcessorHandler {
+		return NewRegionSpinnerAlgo(conf)
+	})
+	RegisterSpinnerHandler("periodic", func(conf *SpinnerAlgoConf,
+		kwargs map[string]interface{}) phonelab.ProcessorHandler {
+		return NewPeriodicSpinnerAlgo(conf)
+	})
+	RegisterSpinnerHandler("hmm", func(conf *SpinnerAlgoConf,
+		kwargs map[string]interface{}) phonelab.ProcessorHandler {
+		return NewHMMSpinnerAlgo(conf, spinnerHMMFromConf(conf))
+	})
+	RegisterSpinnerAlgo("hmm_viterbi", func(source phonelab.Processor, conf *SpinnerAlgoConf,
+		kwargs map[string]interface{}) phonelab.Processor {
+		return &HMMViterbiSpinnerProcessor{
+			Source: source,
+			Conf:   *conf,
+			Model:  spinnerHMMFromConf(conf),
+		}
+	})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Harness
+
+// SpinnerFixture is a diff stream (FrameDiffSamples and DataGaps) with the
+// spinners that were really showing.
+type SpinnerFixture struct {
+	Name  string
+	Logs  []interface{}
+	Truth []*SpinnerStateInterval
+}
+
+type spinnerFixtureSource struct {
+	logs []interface{}
+}
+
+func (src *spinnerFixtureSource) Process() <-chan interface{} {
+	outChan := make(chan interface{})
+	go func() {
+		for _, log := range src.logs {
+			outChan <- log
+		}
+		close(outChan)
+	}()
+	return outChan
+}
+
+// LoadSpinnerFixture reads a log file, turning the SurfaceFlinger logs into a
+// diff stream and the Spinner-State-QoE logs into the ground truth. The diff
+// stream is built with the framediffs kwargs in diffArgs (which can be nil),
+// so it matches what the spinners processor would see in that pipeline.
+func LoadSpinnerFixture(path string, diffArgs map[string]interface{}) (*SpinnerFixture, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	parsers := map[string]phonelab.Parser{
+		"SurfaceFlinger":    NewSurfaceFlingerParser(),
+		"Spinner-State-QoE": NewSpinnerStateParser(),
+	}
+
+	sfLogs := make([]interface{}, 0)
+	spinnerLogs := make([]*SpinnerStateLog, 0)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	for scanner.Scan() {
+		ll, err := phonelab.ParseLogline(scanner.Text())
+		if err != nil || ll == nil {
+			continue
+		}
+		parser, ok := parsers[ll.Tag]
+		if !ok {
+			continue
+		}
+		if payload, ok := ll.Payload.(string); ok {
+			if ll.Payload, err = parser.Parse(payload); err != nil || ll.Payload == nil {
+				continue
+			}
+		}
+
+		switch t := ll.Payload.(type) {
+		case *SpinnerStateLog:
+			spinnerLogs = append(spinnerLogs, t)
+		default:
+			sfLogs = append(sfLogs, ll)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	emitter := NewFrameDiffEmitter(&spinnerFixtureSource{sfLogs}, diffArgs)
+
+	fixture := &SpinnerFixture{
+		Name:  path,
+		Logs:  make([]interface{}, 0),
+		Truth: SpinnerStateIntervals(spinnerLogs),
+	}
+	for log := range emitter.Process() {
+		switch log.(type) {
+		case *FrameDiffSample, *DataGap:
+			fixture.Logs = append(fixture.Logs, log)
+		}
+	}
+
+	return fixture, nil
+}
+
+// RunSpinnerAlgo runs the algorithm named by conf.Name over logs and returns
+// the spinners it finds.
+func RunSpinnerAlgo(conf *SpinnerAlgoConf, kwargs map[string]interface{},
+	logs []interface{}) ([]*Spinner, error) {
+
+	proc, err := NewSpinnerAlgoProcessor(&spinnerFixtureSource{logs}, conf, kwargs)
+	if err != nil {
+		return nil, err
+	}
+
+	spinners := make([]*Spinner, 0)
+	for log := range proc.Process() {
+		if s, ok := log.(*Spinner); ok {
+			spinners = append(spinners, s)
+		}
+	}
+	return spinners, nil
+}
+
+// SpinnerAlgoScore is how well an algorithm's spinners line up with the
+// ground truth. A detected spinner matches if it overlaps a true one.
+type SpinnerAlgoScore struct {
+	Algo    string `json:"algo"`
+	Group   string `json:"group,omitempty"`
+	Fixture string `json:"fixture"`
+
+	Detected int `json:"detected"`
+	Truth    int `json:"truth"`
+	// Detected spinners overlapping a true one
+	TruePositives int `json:"true_positives"`
+	// True spinners no detected spinner overlaps
+	Missed    int     `json:"missed"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	// Time covered by both, and by only one or the other
+	OverlapMs       int64 `json:"overlap_ms"`
+	FalseDetectedMs int64 `json:"false_detected_ms"`
+	MissedMs        int64 `json:"missed_ms"`
+}
+
+func overlapMs(startA, endA, startB, endB int64) int64 {
+	start, end := startA, endA
+	if startB > start {
+		start = startB
+	}
+	if endB < end {
+		end = endB
+	}
+	if end <= start {
+		return 0
+	}
+	return end - start
+}
+
+// ScoreSpinners compares detected spinners with the ground truth.
+func ScoreSpinners(spinners []*Spinner, truth []*SpinnerStateInterval) *SpinnerAlgoScore {
+	score := &SpinnerAlgoScore{
+		Detected: len(spinners),
+		Truth:    len(truth),
+	}
+
+	truthMatched := make([]bool, len(truth))
+	detectedMs, truthMs := int64(0), int64(0)
+
+	for _, s := range spinners {
+		detectedMs += s.EndTimeMs - s.StartTimeMs
+		matched := false
+		for i, interval := range truth {
+			if o := overlapMs(s.StartTimeMs, s.EndTimeMs, interval.StartMs, interval.EndMs); o > 0 {
+				matched = true
+				truthMatched[i] = true
+				score.OverlapMs += o
+			}
+		}
+		if matched {
+			score.TruePositives += 1
+		}
+	}
+
+	for i, interval := range truth {
+		truthMs += interval.EndMs - interval.StartMs
+		if !truthMatched[i] {
+			score.Missed += 1
+		}
+	}
+
+	score.FalseDetectedMs = detectedMs - score.OverlapMs
+	score.MissedMs = truthMs - score.OverlapMs
+
+	if score.Detected > 0 {
+		score.Precision = float64(score.TruePositives) / float64(score.Detected)
+	}
+	if score.Truth > 0 {
+		score.Recall = float64(score.Truth-score.Missed) / float64(score.Truth)
+	}
+
+	return score
+}
+
+// CompareSpinnerAlgos runs each configuration over each fixture, in order.
+func CompareSpinnerAlgos(confs []*SpinnerAlgoConf,
+	fixtures []*SpinnerFixture) ([]*SpinnerAlgoScore, error) {
+
+	scores := make([]*SpinnerAlgoScore, 0, len(confs)*len(fixtures))
+	for _, conf := range confs {
+		for _, fixture := range fixtures {
+			spinners, err := RunSpinnerAlgo(conf, nil, fixture.Logs)
+			if err != nil {
+				return nil, err
+			}
+			score := ScoreSpinners(spinners, fixture.Truth)
+			score.Algo = conf.Name
+			score.Group = conf.Group
+			score.Fixture = fixture.Name
+			scores = append(scores, score)
+		}
+	}
+	return scores, nil
+}
@@ -0,0 +1,136 @@
+package libphonelabgo
+
+import (
+	phonelab "github.com/shaseley/phonelab-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// Flags every nonzero diff as its own spinner.
+type everySpinnerAlgo struct {
+	state *spinnerState
+}
+
+func (algo *everySpinnerAlgo) Handle(log interface{}) interface{} {
+	sample, ok := log.(*FrameDiffSample)
+	if !ok || sample.PctDiff == 0.0 {
+		return nil
+	}
+	algo.state.markStartTime(sample)
+	return algo.state.endSpinner(sample)
+}
+
+func (algo *everySpinnerAlgo) Finish() {}
+
+func TestSpinnerAlgoRegistry(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	names := SpinnerAlgoNames()
+	for _, name := range []string{"naive", "voting", "region", "periodic", "hmm", "hmm_viterbi"} {
+		assert.Contains(names, name)
+	}
+
+	RegisterSpinnerHandler("test_every", func(conf *SpinnerAlgoConf,
+		kwargs map[string]interface{}) phonelab.ProcessorHandler {
+		return &everySpinnerAlgo{&spinnerState{}}
+	})
+	defer unregisterSpinnerAlgo("test_every")
+	assert.Contains(SpinnerAlgoNames(), "test_every")
+
+	assert.Panics(func() {
+		RegisterSpinnerAlgo("test_every", func(source phonelab.Processor, conf *SpinnerAlgoConf,
+			kwargs map[string]interface{}) phonelab.Processor {
+			return source
+		})
+	})
+
+	logs := []interface{}{
+		heatmapTestDiff(100, &GridEntry{10, 36.0}),
+		heatmapTestDiff(200),
+		heatmapTestDiff(300, &GridEntry{10, 36.0}),
+	}
+	spinners, err := RunSpinnerAlgo(&SpinnerAlgoConf{Name: "test_every"}, nil, logs)
+	require.Nil(err)
+	assert.Equal(2, len(spinners))
+
+	_, err = RunSpinnerAlgo(&SpinnerAlgoConf{Name: "nope"}, nil, logs)
+	assert.NotNil(err)
+}
+
+func TestScoreSpinners(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	score := ScoreSpinners([]*Spinner{
+		&Spinner{StartTimeMs: 100, EndTimeMs: 300},
+		&Spinner{StartTimeMs: 1000, EndTimeMs: 1100},
+	}, []*SpinnerStateInterval{
+		&SpinnerStateInterval{StartMs: 200, EndMs: 400},
+		&SpinnerStateInterval{StartMs: 2000, EndMs: 2500},
+	})
+
+	assert.Equal(1, score.TruePositives)
+	assert.Equal(1, score.Missed)
+	assert.Equal(0.5, score.Precision)
+	assert.Equal(0.5, score.Recall)
+	assert.Equal(int64(100), score.OverlapMs)
+	assert.Equal(int64(200), score.FalseDetectedMs)
+	assert.Equal(int64(600), score.MissedMs)
+}
+
+func TestCompareSpinnerAlgos(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	logs := make([]interface{}, 0)
+	for _, diff := range hmmTestDiffs(0) {
+		logs = append(logs, diff)
+	}
+	fixture := &SpinnerFixture{
+		Name:  "synthetic",
+		Logs:  logs,
+		Truth: []*SpinnerStateInterval{&SpinnerStateInterval{StartMs: 1000, EndMs: 2000}},
+	}
+
+	scores, err := CompareSpinnerAlgos([]*SpinnerAlgoConf{
+		&SpinnerAlgoConf{Name: "voting", Min: 0.1, Max: 5.0, NumVotesIn: 3, NumVotesOut: 2},
+		&SpinnerAlgoConf{Name: "hmm"},
+		&SpinnerAlgoConf{Name: "hmm_viterbi"},
+	}, []*SpinnerFixture{fixture})
+	require.Nil(err)
+	require.Equal(3, len(scores))
+
+	for _, score := range scores {
+		assert.Equal("synthetic", score.Fixture)
+		assert.Equal(1.0, score.Recall, score.Algo)
+		assert.Equal(1.0, score.Precision, score.Algo)
+	}
+	assert.Equal("voting", scores[0].Algo)
+
+	_, err = CompareSpinnerAlgos([]*SpinnerAlgoConf{&SpinnerAlgoConf{Name: "nope"}},
+		[]*SpinnerFixture{fixture})
+	assert.NotNil(err)
+}
+
+func TestLoadSpinnerFixture(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	fixture, err := LoadSpinnerFixture("./test/test.log", nil)
+	require.Nil(err)
+	assert.True(len(fixture.Logs) > 0)
+	assert.True(len(fixture.Truth) > 0)
+
+	for _, log := range fixture.Logs {
+		switch log.(type) {
+		case *FrameDiffSample, *DataGap:
+		default:
+			t.Errorf("Unexpected log in fixture: %T", log)
+		}
+	}
+}
@@ -12,16 +12,65 @@ type Spinner struct {
 
 	TraceTimeStart float64 `json:"trace_time_start"`
 	TraceTimeEnd   float64 `json:"trace_time_end"`
+
+	// Set if the spinner was cut short by missing data
+	Incomplete bool `json:"incomplete,omitempty"`
+
+	// Where the spinner was, for algorithms that know
+	Region *SpinnerRegion `json:"region,omitempty"`
+
+	// Detected redraw period, for algorithms that know
+	PeriodMs   float64 `json:"period_ms,omitempty"`
+	Confidence float64 `json:"confidence,omitempty"`
+
+	// For stitched spinners, the spinners that were stitched together
+	Segments []*Spinner `json:"segments,omitempty"`
 }
 
 func (s *Spinner) MonotonicTimestamp() float64 {
 	return s.TraceTimeStart
 }
 
+// A copy of the spinner as a segment of a stitched spinner.
+func (s *Spinner) segment() *Spinner {
+	seg := *s
+	seg.Segments = nil
+	if s.Region != nil {
+		region := *s.Region
+		seg.Region = &region
+	}
+	return &seg
+}
+
 func (s *Spinner) Append(other *Spinner) {
+	if len(s.Segments) == 0 {
+		s.Segments = []*Spinner{s.segment()}
+	}
+	if len(other.Segments) > 0 {
+		s.Segments = append(s.Segments, other.Segments...)
+	} else {
+		s.Segments = append(s.Segments, other.segment())
+	}
+
 	s.EndTimeMs = other.EndTimeMs
 	s.TraceTimeEnd = other.TraceTimeEnd
 	s.DurationMs = s.EndTimeMs - s.StartTimeMs
+	s.Incomplete = s.Incomplete || other.Incomplete
+
+	// Keep the more confident period
+	if other.Confidence > s.Confidence {
+		s.PeriodMs = other.PeriodMs
+		s.Confidence = other.Confidence
+	}
+
+	if other.Region != nil {
+		if s.Region == nil {
+			region := *other.Region
+			s.Region = &region
+		} else {
+			s.Region.Append(other.Region)
+		}
+	}
 }
 
 type SpinnerAlgoGenerator struct{}
@@ -36,6 +85,19 @@ type SpinnerAlgoConf struct {
 	IgnoreZeros bool    `json:"ignore_zeros" yaml:"ignore_zeros"`
 	NumVotesIn  int     `json:"num_votes_in" yaml:"num_votes_in"`
 	NumVotesOut int     `json:"num_votes_out" yaml:"num_votes_out"`
+	// One of the GapPolicy constants. Empty means GapPolicyIgnore, which is
+	// what spinner algorithms did before gaps were reported.
+	GapPolicy   string  `json:"gap_policy" yaml:"gap_policy"`
+	// Largest changed region, in grid cells, that can still be a spinner
+	MaxCells int `json:"max_cells,omitempty" yaml:"max_cells"`
+	// Periodicity: window to look for a period in, the range of periods
+	// allowed, and the autocorrelation needed
+	WindowMs      int     `json:"window_ms,omitempty" yaml:"window_ms"`
+	MinPeriodMs   int     `json:"min_period_ms,omitempty" yaml:"min_period_ms"`
+	MaxPeriodMs   int     `json:"max_period_ms,omitempty" yaml:"max_period_ms"`
+	MinConfidence float64 `json:"min_confidence,omitempty" yaml:"min_confidence"`
+	// HMM: model file (JSON), or empty for the default model
+	Model string `json:"model,omitempty" yaml:"model"`
 }
 
 func NewSpinnerAlgoConf(kwargs map[string]interface{}) *SpinnerAlgoConf {
@@ -76,6 +138,33 @@ func NewSpinnerAlgoConf(kwargs map[string]interface{}) *SpinnerAlgoConf {
 	if v, ok := kwargs["votesOut"]; ok {
 		p.NumVotesOut, _ = v.(int)
 	}
+	if _, ok := kwargs["gapPolicy"]; ok {
+		p.GapPolicy = gapPolicyFromArgs(kwargs, "gapPolicy", "")
+	}
+	if v, ok := kwargs["maxCells"]; ok {
+		p.MaxCells, _ = v.(int)
+	}
+	if v, ok := kwargs["windowMs"]; ok {
+		p.WindowMs, _ = v.(int)
+	}
+	if v, ok := kwargs["minPeriodMs"]; ok {
+		p.MinPeriodMs, _ = v.(int)
+	}
+	if v, ok := kwargs["maxPeriodMs"]; ok {
+		p.MaxPeriodMs, _ = v.(int)
+	}
+	if v, ok := kwargs["minConfidence"]; ok {
+		p.MinConfidence, ok = v.(float64)
+		if !ok {
+			// Maybe it was an int?
+			if tmp, ok := v.(int); ok {
+				p.MinConfidence = float64(tmp)
+			}
+		}
+	}
+	if v, ok := kwargs["model"]; ok {
+		p.Model, _ = v.(string)
+	}
 
 	return p
 }
@@ -85,17 +174,12 @@ func (g *SpinnerAlgoGenerator) GenerateProcessor(source *phonelab.PipelineSource
 
 	conf := NewSpinnerAlgoConf(kwargs)
 
-	switch conf.Name {
-	case "naive":
-		return phonelab.NewSimpleProcessor(source.Processor,
-			NewNaiveSpinnerAlgo(conf))
-	case "voting":
-		return phonelab.NewSimpleProcessor(source.Processor,
-			NewVotingSpinnerAlgo(conf))
-	default:
+	proc, err := NewSpinnerAlgoProcessor(source.Processor, conf, kwargs)
+	if err != nil {
 		// TODO: this should be able to return an error
-		panic("Cannot find algo '" + conf.Name + "'!")
+		panic(err.Error())
 	}
+	return proc
 }
 
 // TODO:
@@ -132,6 +216,42 @@ func (state *spinnerState) endSpinner(sample *FrameDiffSample) *Spinner {
 	}
 }
 
+// Handle missing diffs. If we're in the middle of a spinner, it either gets
+// cut off at the start of the gap and flagged as incomplete, or dropped,
+// depending on the policy. Either way, we're no longer in a spinner after the
+// gap. Returns true if the state was reset. Gaps are ignored unless a policy
+// is set.
+func (state *spinnerState) onGap(gap *DataGap, policy string) (*Spinner, bool) {
+	if policy == GapPolicyIgnore || len(policy) == 0 {
+		return nil, false
+	}
+
+	if !state.isSpinner {
+		return nil, true
+	}
+
+	state.setState(false)
+
+	if policy == GapPolicyAbort {
+		return nil, true
+	}
+
+	endMs := gap.StartNs / nsPerMs
+	endTrace := gap.TraceTimeStart
+	if GlobalConf.UseSysTime {
+		endTrace = float64(gap.StartNs) / nsPerSecF
+	}
+
+	return &Spinner{
+		StartTimeMs:    state.startMs,
+		EndTimeMs:      endMs,
+		DurationMs:     endMs - state.startMs,
+		TraceTimeStart: state.startTrace,
+		TraceTimeEnd:   endTrace,
+		Incomplete:     true,
+	}, true
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // NaiveSpinnerAlgo is just that, very naive. It classifies on a per-frame basis
 // without caring about previous or future frames.
@@ -148,6 +268,13 @@ func NewNaiveSpinnerAlgo(conf *SpinnerAlgoConf) *NaiveSpinnerAlgo {
 }
 
 func (algo *NaiveSpinnerAlgo) Handle(log interface{}) interface{} {
+	if gap, ok := log.(*DataGap); ok {
+		if s, _ := algo.state.onGap(gap, algo.Conf.GapPolicy); s != nil {
+			return s
+		}
+		return nil
+	}
+
 	// We're expecting only frame diff samples
 	sample, ok := log.(*FrameDiffSample)
 	if !ok {
@@ -239,6 +366,18 @@ func (algo *VotingSpinnerAlgo) Handle(log interface{}) interface{} {
 	//  	and require M consecutive samples outside of the bounds to classify
 	//  	as no.
 
+	if gap, ok := log.(*DataGap); ok {
+		s, reset := algo.state.onGap(gap, algo.Conf.GapPolicy)
+		if reset {
+			// Votes don't carry across the gap
+			algo.votesNeeded = algo.Conf.NumVotesIn
+		}
+		if s != nil {
+			return s
+		}
+		return nil
+	}
+
 	// We're expecting only frame diff samples
 	sample, ok := log.(*FrameDiffSample)
 	if !ok {
@@ -330,9 +469,43 @@ func (g *SpinnerCollectorGenerator) GenerateProcessor(source *phonelab.PipelineS
 	return NewSpinnerCollectorProcessor(source, kwargs)
 }
 
+// Spinners this long or shorter are never stitched.
+const DefaultStitchMinSegmentMs = 1000
+
+// SpinnerStitcher combines spinners separated by short gaps, which usually
+// happen when a spinner briefly drops out of the detector's range. It can
+// also be given AppChangeEvents and TouchScreenEvents, to avoid stitching
+// across an app change or a tap.
 type SpinnerStitcher struct {
+	// Largest gap between spinners to stitch across
 	StitchInterval int64
 	Source         phonelab.Processor
+	// Spinners this long or shorter are passed through on their own. 0 means
+	// DefaultStitchMinSegmentMs.
+	MinSegmentMs int64
+	// Longest stitched spinner, or 0 for no limit
+	MaxMergedMs int64
+	// Don't stitch across a foreground app change
+	AppBoundaries bool
+	// Don't stitch across a tap
+	InputBoundaries bool
+}
+
+// Whether s can be stitched onto cur, given the boundaries (in ms) seen so
+// far.
+func (stitcher *SpinnerStitcher) canStitch(cur, s *Spinner, boundaries []int64) bool {
+	if s.StartTimeMs-cur.EndTimeMs >= stitcher.StitchInterval {
+		return false
+	}
+	if stitcher.MaxMergedMs > 0 && s.EndTimeMs-cur.StartTimeMs > stitcher.MaxMergedMs {
+		return false
+	}
+	for _, b := range boundaries {
+		if b > cur.EndTimeMs && b <= s.StartTimeMs {
+			return false
+		}
+	}
+	return true
 }
 
 func (stitcher *SpinnerStitcher) Process() <-chan interface{} {
@@ -343,13 +516,33 @@ func (stitcher *SpinnerStitcher) Process() <-chan interface{} {
 	go func() {
 		var curSpinner *Spinner = nil
 
+		minSegmentMs := stitcher.MinSegmentMs
+		if minSegmentMs <= 0 {
+			minSegmentMs = DefaultStitchMinSegmentMs
+		}
+
+		// App changes and taps, in ms
+		boundaries := make([]int64, 0)
+
 		for log := range inChan {
-			// We're expecting only spinners
+			switch t := log.(type) {
+			case *AppChangeEvent:
+				if stitcher.AppBoundaries {
+					boundaries = append(boundaries, t.TimestampNs/nsPerMs)
+				}
+			case *TouchScreenEvent:
+				if stitcher.InputBoundaries &&
+					(t.What == TouchScreenEventTap || t.What == TouchScreenEventKeystroke) {
+					boundaries = append(boundaries, t.Timestamp/nsPerMs)
+				}
+			}
+
+			// Otherwise, we're expecting only spinners
 			s, ok := log.(*Spinner)
 			if ok {
 				if stitcher.StitchInterval <= 0 {
 					outChan <- s
-				} else if s.DurationMs <= 1000 {
+				} else if s.DurationMs <= minSegmentMs {
 					if curSpinner != nil {
 						outChan <- curSpinner
 						curSpinner = nil
@@ -357,7 +550,7 @@ func (stitcher *SpinnerStitcher) Process() <-chan interface{} {
 					outChan <- s
 				} else if curSpinner == nil {
 					curSpinner = s
-				} else if s.StartTimeMs-curSpinner.EndTimeMs < stitcher.StitchInterval {
+				} else if stitcher.canStitch(curSpinner, s, boundaries) {
 					// Combine the spinners
 					curSpinner.Append(s)
 				} else {
@@ -365,6 +558,17 @@ func (stitcher *SpinnerStitcher) Process() <-chan interface{} {
 					outChan <- curSpinner
 					curSpinner = s
 				}
+
+				// Boundaries before the current spinner don't matter anymore
+				if curSpinner != nil {
+					kept := boundaries[:0]
+					for _, b := range boundaries {
+						if b > curSpinner.EndTimeMs {
+							kept = append(kept, b)
+						}
+					}
+					boundaries = kept
+				}
 			}
 		}
 
@@ -391,8 +595,28 @@ func (g *SpinnerStitcherGen) GenerateProcessor(source *phonelab.PipelineSourceIn
 		interval, _ = v.(int)
 	}
 
-	return &SpinnerStitcher{
+	stitcher := &SpinnerStitcher{
 		StitchInterval: int64(interval),
 		Source:         source.Processor,
+		MinSegmentMs:   DefaultStitchMinSegmentMs,
 	}
+
+	if v, ok := kwargs["minSegmentMs"]; ok {
+		if tmp, ok := v.(int); ok {
+			stitcher.MinSegmentMs = int64(tmp)
+		}
+	}
+	if v, ok := kwargs["maxMergedMs"]; ok {
+		if tmp, ok := v.(int); ok {
+			stitcher.MaxMergedMs = int64(tmp)
+		}
+	}
+	if v, ok := kwargs["appBoundaries"]; ok {
+		stitcher.AppBoundaries, _ = v.(bool)
+	}
+	if v, ok := kwargs["inputBoundaries"]; ok {
+		stitcher.InputBoundaries, _ = v.(bool)
+	}
+
+	return stitcher
 }
@@ -0,0 +1,753 @@
+package libphonelabgo
+
+import (
+	"encoding/json"
+	"fmt"
+	phonelab "github.com/shaseley/phonelab-go"
+	"io/ioutil"
+	"math"
+	"sort"
+)
+
+// spinners_hmm.go segments the diff stream with a hidden Markov model instead
+// of vote counting. The hidden states are idle, spinner and content change,
+// and each diff is observed as a symbol combining its PctDiff and how many
+// grid entries changed. Spinners can be decoded online with a forward filter
+// ("hmm"), or offline with Viterbi over the whole file ("hmm_viterbi"). The
+// model parameters can be learned from the Spinner-State-QoE logs
+// ("spinner_hmm_train").
+
+const (
+	HMMStateIdle = iota
+	HMMStateSpinner
+	HMMStateContent
+	numHMMStates
+)
+
+// Observation symbols. PctDiff is bucketed as 0, (0,1], (1,5], (5,20] and
+// above, and the number of grid entries as 0, 1-2, 3-6 and above.
+var hmmPctDiffBounds = []float64{0.0, 1.0, 5.0, 20.0}
+var hmmEntryBounds = []int{0, 2, 6}
+
+var numHMMSymbols = (len(hmmPctDiffBounds) + 1) * (len(hmmEntryBounds) + 1)
+
+const (
+	DefaultHMMTrainIterations = 20
+
+	// Keeps re-estimated probabilities from going to zero
+	hmmPseudoCount = 1e-6
+)
+
+func hmmSymbol(diff *FrameDiffSample) int {
+	pctBin := len(hmmPctDiffBounds)
+	for i, bound := range hmmPctDiffBounds {
+		if diff.PctDiff <= bound {
+			pctBin = i
+			break
+		}
+	}
+
+	entryBin := len(hmmEntryBounds)
+	for i, bound := range hmmEntryBounds {
+		if len(diff.GridEntries) <= bound {
+			entryBin = i
+			break
+		}
+	}
+
+	return pctBin*(len(hmmEntryBounds)+1) + entryBin
+}
+
+// SpinnerHMM is a discrete HMM over diff symbols.
+type SpinnerHMM struct {
+	Initial    []float64   `json:"initial"`
+	Transition [][]float64 `json:"transition"`
+	Emission   [][]float64 `json:"emission"`
+}
+
+// A hand-tuned model, for when nothing has been trained.
+func DefaultSpinnerHMM() *SpinnerHMM {
+	pct := [][]float64{
+		{0.9, 0.07, 0.02, 0.005, 0.005},
+		{0.15, 0.5, 0.3, 0.04, 0.01},
+		{0.05, 0.1, 0.15, 0.3, 0.4},
+	}
+	entries := [][]float64{
+		{0.9, 0.07, 0.02, 0.01},
+		{0.1, 0.6, 0.25, 0.05},
+		{0.05, 0.1, 0.25, 0.6},
+	}
+
+	model := &SpinnerHMM{
+		Initial: []float64{0.8, 0.1, 0.1},
+		Transition: [][]float64{
+			{0.95, 0.02, 0.03},
+			{0.02, 0.95, 0.03},
+			{0.05, 0.03, 0.92},
+		},
+		Emission: make([][]float64, numHMMStates),
+	}
+
+	for s := 0; s < numHMMStates; s++ {
+		model.Emission[s] = make([]float64, 0, numHMMSymbols)
+		for _, p := range pct[s] {
+			for _, e := range entries[s] {
+				model.Emission[s] = append(model.Emission[s], p*e)
+			}
+		}
+		normalize(model.Emission[s])
+	}
+
+	return model
+}
+
+func LoadSpinnerHMM(path string) (*SpinnerHMM, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	model := &SpinnerHMM{}
+	if err := json.Unmarshal(data, model); err != nil {
+		return nil, err
+	}
+
+	if err := model.validate(); err != nil {
+		return nil, fmt.Errorf("Invalid spinner HMM '%v': %v", path, err)
+	}
+	return model, nil
+}
+
+func (model *SpinnerHMM) validate() error {
+	if len(model.Initial) != numHMMStates || len(model.Transition) != numHMMStates ||
+		len(model.Emission) != numHMMStates {
+		return fmt.Errorf("expected %v states", numHMMStates)
+	}
+	for s := 0; s < numHMMStates; s++ {
+		if len(model.Transition[s]) != numHMMStates {
+			return fmt.Errorf("expected %v transitions from state %v", numHMMStates, s)
+		}
+		if len(model.Emission[s]) != numHMMSymbols {
+			return fmt.Errorf("expected %v symbols for state %v", numHMMSymbols, s)
+		}
+	}
+	return nil
+}
+
+func normalize(p []float64) float64 {
+	sum := 0.0
+	for _, v := range p {
+		sum += v
+	}
+	if sum > 0.0 {
+		for i := range p {
+			p[i] /= sum
+		}
+	}
+	return sum
+}
+
+func argmax(p []float64) int {
+	best := 0
+	for i := range p {
+		if p[i] > p[best] {
+			best = i
+		}
+	}
+	return best
+}
+
+// One step of the forward filter. belief is the state distribution after the
+// previous observation, or nil for the first one.
+func (model *SpinnerHMM) filter(belief []float64, symbol int) []float64 {
+	next := make([]float64, numHMMStates)
+	for s := 0; s < numHMMStates; s++ {
+		if belief == nil {
+			next[s] = model.Initial[s]
+		} else {
+			for prev := 0; prev < numHMMStates; prev++ {
+				next[s] += belief[prev] * model.Transition[prev][s]
+			}
+		}
+		next[s] *= model.Emission[s][symbol]
+	}
+
+	if normalize(next) <= 0.0 {
+		// Impossible under the model, start over
+		copy(next, model.Initial)
+	}
+	return next
+}
+
+// Viterbi decodes the most likely state sequence.
+func (model *SpinnerHMM) Viterbi(obs []int) []int {
+	if len(obs) == 0 {
+		return []int{}
+	}
+
+	logp := func(p float64) float64 {
+		if p <= 0.0 {
+			return math.Inf(-1)
+		}
+		return math.Log(p)
+	}
+
+	score := make([]float64, numHMMStates)
+	back := make([][]int, len(obs))
+
+	for s := 0; s < numHMMStates; s++ {
+		score[s] = logp(model.Initial[s]) + logp(model.Emission[s][obs[0]])
+	}
+
+	for t := 1; t < len(obs); t++ {
+		next := make([]float64, numHMMStates)
+		back[t] = make([]int, numHMMStates)
+		for s := 0; s < numHMMStates; s++ {
+			best, bestScore := 0, math.Inf(-1)
+			for prev := 0; prev < numHMMStates; prev++ {
+				if v := score[prev] + logp(model.Transition[prev][s]); v > bestScore {
+					best, bestScore = prev, v
+				}
+			}
+			next[s] = bestScore + logp(model.Emission[s][obs[t]])
+			back[t][s] = best
+		}
+		score = next
+	}
+
+	path := make([]int, len(obs))
+	path[len(obs)-1] = argmax(score)
+	for t := len(obs) - 1; t > 0; t-- {
+		path[t-1] = back[t][path[t]]
+	}
+	return path
+}
+
+// Scaled forward-backward. Returns the state posteriors, the expected
+// transition counts, and the log likelihood of the sequence.
+func (model *SpinnerHMM) forwardBackward(obs []int) ([][]float64, [][]float64, float64) {
+	n := len(obs)
+	alpha := make([][]float64, n)
+	scale := make([]float64, n)
+
+	for t := 0; t < n; t++ {
+		alpha[t] = make([]float64, numHMMStates)
+		for s := 0; s < numHMMStates; s++ {
+			if t == 0 {
+				alpha[t][s] = model.Initial[s]
+			} else {
+				for prev := 0; prev < numHMMStates; prev++ {
+					alpha[t][s] += alpha[t-1][prev] * model.Transition[prev][s]
+				}
+			}
+			alpha[t][s] *= model.Emission[s][obs[t]]
+		}
+		scale[t] = normalize(alpha[t])
+	}
+
+	beta := make([][]float64, n)
+	beta[n-1] = []float64{1.0, 1.0, 1.0}
+	for t := n - 2; t >= 0; t-- {
+		beta[t] = make([]float64, numHMMStates)
+		for s := 0; s < numHMMStates; s++ {
+			for next := 0; next < numHMMStates; next++ {
+				beta[t][s] += model.Transition[s][next] *
+					model.Emission[next][obs[t+1]] * beta[t+1][next]
+			}
+			if scale[t+1] > 0.0 {
+				beta[t][s] /= scale[t+1]
+			}
+		}
+	}
+
+	gamma := make([][]float64, n)
+	for t := 0; t < n; t++ {
+		gamma[t] = make([]float64, numHMMStates)
+		for s := 0; s < numHMMStates; s++ {
+			gamma[t][s] = alpha[t][s] * beta[t][s]
+		}
+		normalize(gamma[t])
+	}
+
+	xi := make([][]float64, numHMMStates)
+	for s := range xi {
+		xi[s] = make([]float64, numHMMStates)
+	}
+	for t := 0; t < n-1; t++ {
+		step := make([][]float64, numHMMStates)
+		total := 0.0
+		for s := 0; s < numHMMStates; s++ {
+			step[s] = make([]float64, numHMMStates)
+			for next := 0; next < numHMMStates; next++ {
+				step[s][next] = alpha[t][s] * model.Transition[s][next] *
+					model.Emission[next][obs[t+1]] * beta[t+1][next]
+				total += step[s][next]
+			}
+		}
+		if total <= 0.0 {
+			continue
+		}
+		for s := 0; s < numHMMStates; s++ {
+			for next := 0; next < numHMMStates; next++ {
+				xi[s][next] += step[s][next] / total
+			}
+		}
+	}
+
+	logLikelihood := 0.0
+	for _, c := range scale {
+		logLikelihood += math.Log(c)
+	}
+
+	return gamma, xi, logLikelihood
+}
+
+// BaumWelch re-estimates the model from unlabelled sequences. Stops after
+// iters iterations, or once the likelihood stops improving. Returns the total
+// log likelihood of the sequences under the final model and the number of
+// iterations run.
+func (model *SpinnerHMM) BaumWelch(seqs [][]int, iters int) (float64, int) {
+	prevLL := math.Inf(-1)
+
+	for iter := 0; iter < iters; iter++ {
+		initial := make([]float64, numHMMStates)
+		trans := make([][]float64, numHMMStates)
+		emit := make([][]float64, numHMMStates)
+		for s := 0; s < numHMMStates; s++ {
+			initial[s] = hmmPseudoCount
+			trans[s] = make([]float64, numHMMStates)
+			for next := range trans[s] {
+				trans[s][next] = hmmPseudoCount
+			}
+			emit[s] = make([]float64, numHMMSymbols)
+			for sym := range emit[s] {
+				emit[s][sym] = hmmPseudoCount
+			}
+		}
+
+		ll := 0.0
+		for _, obs := range seqs {
+			if len(obs) == 0 {
+				continue
+			}
+			gamma, xi, seqLL := model.forwardBackward(obs)
+			ll += seqLL
+
+			for s := 0; s < numHMMStates; s++ {
+				initial[s] += gamma[0][s]
+				for next := 0; next < numHMMStates; next++ {
+					trans[s][next] += xi[s][next]
+				}
+				for t, sym := range obs {
+					emit[s][sym] += gamma[t][s]
+				}
+			}
+		}
+
+		if ll <= prevLL+1e-6 {
+			// Converged (the model is from the previous iteration)
+			return ll, iter
+		}
+		prevLL = ll
+
+		normalize(initial)
+		for s := 0; s < numHMMStates; s++ {
+			normalize(trans[s])
+			normalize(emit[s])
+		}
+		model.Initial, model.Transition, model.Emission = initial, trans, emit
+	}
+
+	return prevLL, iters
+}
+
+// EstimateSpinnerHMM builds a model by counting over labelled sequences, with
+// add-one smoothing.
+func EstimateSpinnerHMM(seqs [][]int, labels [][]int) *SpinnerHMM {
+	model := &SpinnerHMM{
+		Initial:    make([]float64, numHMMStates),
+		Transition: make([][]float64, numHMMStates),
+		Emission:   make([][]float64, numHMMStates),
+	}
+	for s := 0; s < numHMMStates; s++ {
+		model.Initial[s] = 1.0
+		model.Transition[s] = make([]float64, numHMMStates)
+		for next := range model.Transition[s] {
+			model.Transition[s][next] = 1.0
+		}
+		model.Emission[s] = make([]float64, numHMMSymbols)
+		for sym := range model.Emission[s] {
+			model.Emission[s][sym] = 1.0
+		}
+	}
+
+	for i, obs := range seqs {
+		states := labels[i]
+		for t, sym := range obs {
+			if t == 0 {
+				model.Initial[states[t]] += 1.0
+			} else {
+				model.Transition[states[t-1]][states[t]] += 1.0
+			}
+			model.Emission[states[t]][sym] += 1.0
+		}
+	}
+
+	normalize(model.Initial)
+	for s := 0; s < numHMMStates; s++ {
+		normalize(model.Transition[s])
+		normalize(model.Emission[s])
+	}
+	return model
+}
+
+// The model for a spinner algo, from the file named in the conf or the
+// default.
+func spinnerHMMFromConf(conf *SpinnerAlgoConf) *SpinnerHMM {
+	if len(conf.Model) == 0 {
+		return DefaultSpinnerHMM()
+	}
+	model, err := LoadSpinnerHMM(conf.Model)
+	if err != nil {
+		// TODO: this should be able to return an error
+		panic(err.Error())
+	}
+	return model
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Online decoding
+
+// HMMSpinnerAlgo runs the forward filter over the diff stream. A spinner is
+// going whenever it's the most likely state.
+type HMMSpinnerAlgo struct {
+	Conf  SpinnerAlgoConf
+	Model *SpinnerHMM
+
+	// State
+	state   *spinnerState
+	belief  []float64
+	sumProb float64
+	numProb int
+}
+
+func NewHMMSpinnerAlgo(conf *SpinnerAlgoConf, model *SpinnerHMM) *HMMSpinnerAlgo {
+	if model == nil {
+		model = DefaultSpinnerHMM()
+	}
+	return &HMMSpinnerAlgo{
+		Conf:  *conf,
+		Model: model,
+		state: &spinnerState{},
+	}
+}
+
+// Confidence is the mean posterior probability of the spinner state.
+func (algo *HMMSpinnerAlgo) setConfidence(s *Spinner) {
+	if algo.numProb > 0 {
+		s.Confidence = algo.sumProb / float64(algo.numProb)
+	}
+	algo.sumProb, algo.numProb = 0.0, 0
+}
+
+func (algo *HMMSpinnerAlgo) Handle(log interface{}) interface{} {
+	if gap, ok := log.(*DataGap); ok {
+		s, reset := algo.state.onGap(gap, algo.Conf.GapPolicy)
+		if reset {
+			algo.belief = nil
+		}
+		if s != nil {
+			algo.setConfidence(s)
+			return s
+		}
+		return nil
+	}
+
+	// We're expecting only frame diff samples
+	sample, ok := log.(*FrameDiffSample)
+	if !ok {
+		return nil
+	}
+
+	if sample.PctDiff == 0.0 && algo.Conf.IgnoreZeros {
+		return nil
+	}
+
+	algo.belief = algo.Model.filter(algo.belief, hmmSymbol(sample))
+	isSpinner := argmax(algo.belief) == HMMStateSpinner
+
+	if isSpinner {
+		if !algo.state.isSpinner {
+			algo.state.setState(true)
+			algo.state.markStartTime(sample)
+		}
+		algo.sumProb += algo.belief[HMMStateSpinner]
+		algo.numProb += 1
+	} else if algo.state.isSpinner {
+		algo.state.setState(false)
+		s := algo.state.endSpinner(sample)
+		algo.setConfidence(s)
+		return s
+	}
+
+	return nil
+}
+
+func (algo *HMMSpinnerAlgo) Finish() {}
+
+////////////////////////////////////////////////////////////////////////////////
+// Offline decoding
+
+// HMMViterbiSpinnerProcessor buffers the diff stream and decodes it with
+// Viterbi, one stretch between data gaps at a time. Like the online
+// algorithms, a spinner still going at the end of the stream isn't reported.
+type HMMViterbiSpinnerProcessor struct {
+	Source phonelab.Processor
+	Conf   SpinnerAlgoConf
+	Model  *SpinnerHMM
+}
+
+func (proc *HMMViterbiSpinnerProcessor) Process() <-chan interface{} {
+	outChan := make(chan interface{})
+
+	go func() {
+		inChan := proc.Source.Process()
+		state := &spinnerState{}
+		samples := make([]*FrameDiffSample, 0)
+
+		// Decode what we have so far and send the finished spinners.
+		decode := func() {
+			obs := make([]int, len(samples))
+			for i, sample := range samples {
+				obs[i] = hmmSymbol(sample)
+			}
+			for i, s := range proc.Model.Viterbi(obs) {
+				if s == HMMStateSpinner && !state.isSpinner {
+					state.setState(true)
+					state.markStartTime(samples[i])
+				} else if s != HMMStateSpinner && state.isSpinner {
+					state.setState(false)
+					outChan <- state.endSpinner(samples[i])
+				}
+			}
+			samples = samples[:0]
+		}
+
+		for iLog := range inChan {
+			switch t := iLog.(type) {
+			case *DataGap:
+				if proc.Conf.GapPolicy == GapPolicyIgnore || len(proc.Conf.GapPolicy) == 0 {
+					continue
+				}
+				decode()
+				if s, _ := state.onGap(t, proc.Conf.GapPolicy); s != nil {
+					outChan <- s
+				}
+			case *FrameDiffSample:
+				if t.PctDiff == 0.0 && proc.Conf.IgnoreZeros {
+					continue
+				}
+				samples = append(samples, t)
+			}
+		}
+
+		decode()
+		close(outChan)
+	}()
+
+	return outChan
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Training
+
+// SpinnerStateInterval is when a ProgressBar was showing, according to the
+// Spinner-State-QoE logs.
+type SpinnerStateInterval struct {
+	StartMs int64  `json:"start_ms"`
+	EndMs   int64  `json:"end_ms"`
+	App     string `json:"app"`
+}
+
+// Turn spinner start and stop logs into intervals when at least one spinner
+// was showing. A view that starts more than once before stopping is counted
+// once.
+func SpinnerStateIntervals(logs []*SpinnerStateLog) []*SpinnerStateInterval {
+	sorted := make([]*SpinnerStateLog, len(logs))
+	copy(sorted, logs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].UpTimeMs < sorted[j].UpTimeMs
+	})
+
+	type viewKey struct {
+		pid int
+		id  int64
+	}
+
+	intervals := make([]*SpinnerStateInterval, 0)
+	active := make(map[viewKey]bool)
+	var cur *SpinnerStateInterval
+
+	for _, log := range sorted {
+		if log.Action != SpinnerStateActionEvent {
+			continue
+		}
+		key := viewKey{log.Pid, log.Id}
+
+		switch log.Type {
+		case SpinnerStateTypeStart:
+			active[key] = true
+			if cur == nil {
+				cur = &SpinnerStateInterval{StartMs: log.UpTimeMs, App: log.AppName}
+			}
+		case SpinnerStateTypeStop:
+			delete(active, key)
+			if cur != nil && len(active) == 0 {
+				cur.EndMs = log.UpTimeMs
+				intervals = append(intervals, cur)
+				cur = nil
+			}
+		}
+	}
+
+	return intervals
+}
+
+// Label each diff with its true state: spinner if one was showing, otherwise
+// idle or content change depending on whether anything changed.
+func labelSpinnerStates(samples []*FrameDiffSample, intervals []*SpinnerStateInterval) []int {
+	labels := make([]int, len(samples))
+	for i, sample := range samples {
+		j := sort.Search(len(intervals), func(j int) bool {
+			return intervals[j].EndMs >= sample.Timestamp
+		})
+		if j < len(intervals) && intervals[j].StartMs <= sample.Timestamp {
+			labels[i] = HMMStateSpinner
+		} else if sample.PctDiff > 0.0 {
+			labels[i] = HMMStateContent
+		} else {
+			labels[i] = HMMStateIdle
+		}
+	}
+	return labels
+}
+
+type SpinnerHMMTrainResult struct {
+	Model         *SpinnerHMM `json:"model"`
+	Samples       int         `json:"samples"`
+	Sequences     int         `json:"sequences"`
+	Spinners      int         `json:"spinners"`
+	Iterations    int         `json:"iterations"`
+	LogLikelihood float64     `json:"log_likelihood"`
+}
+
+// TrainSpinnerHMM learns a model from diffs and the spinner logs over the
+// same time. The labels give the starting point, then Baum-Welch refines it.
+// Sequences are split at the data gaps.
+func TrainSpinnerHMM(samples []*FrameDiffSample, spinnerLogs []*SpinnerStateLog,
+	gaps []*DataGap, iters int) *SpinnerHMMTrainResult {
+
+	sorted := make([]*FrameDiffSample, len(samples))
+	copy(sorted, samples)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp < sorted[j].Timestamp
+	})
+
+	intervals := SpinnerStateIntervals(spinnerLogs)
+	labels := labelSpinnerStates(sorted, intervals)
+
+	gapStarts := make([]int64, 0, len(gaps))
+	for _, gap := range gaps {
+		gapStarts = append(gapStarts, gap.StartNs)
+	}
+	sort.Slice(gapStarts, func(i, j int) bool { return gapStarts[i] < gapStarts[j] })
+
+	seqs := make([][]int, 0)
+	seqLabels := make([][]int, 0)
+	curSeq, curLabels := make([]int, 0), make([]int, 0)
+	nextGap := 0
+
+	for i, sample := range sorted {
+		split := false
+		for nextGap < len(gapStarts) && gapStarts[nextGap] < sample.TimestampNs() {
+			split = true
+			nextGap += 1
+		}
+		if split && len(curSeq) > 0 {
+			seqs, seqLabels = append(seqs, curSeq), append(seqLabels, curLabels)
+			curSeq, curLabels = make([]int, 0), make([]int, 0)
+		}
+		curSeq = append(curSeq, hmmSymbol(sample))
+		curLabels = append(curLabels, labels[i])
+	}
+	if len(curSeq) > 0 {
+		seqs, seqLabels = append(seqs, curSeq), append(seqLabels, curLabels)
+	}
+
+	model := EstimateSpinnerHMM(seqs, seqLabels)
+	ll, n := model.BaumWelch(seqs, iters)
+
+	return &SpinnerHMMTrainResult{
+		Model:         model,
+		Samples:       len(sorted),
+		Sequences:     len(seqs),
+		Spinners:      len(intervals),
+		Iterations:    n,
+		LogLikelihood: ll,
+	}
+}
+
+// SpinnerHMMTrainProcessor collects FrameDiffSamples, DataGaps and
+// Spinner-State-QoE logs, and sends a SpinnerHMMTrainResult at the end.
+type SpinnerHMMTrainProcessor struct {
+	Source     phonelab.Processor
+	Iterations int
+}
+
+func (proc *SpinnerHMMTrainProcessor) Process() <-chan interface{} {
+	outChan := make(chan interface{})
+
+	go func() {
+		inChan := proc.Source.Process()
+		samples := make([]*FrameDiffSample, 0)
+		spinnerLogs := make([]*SpinnerStateLog, 0)
+		gaps := make([]*DataGap, 0)
+
+		for iLog := range inChan {
+			switch t := iLog.(type) {
+			case *FrameDiffSample:
+				samples = append(samples, t)
+			case *DataGap:
+				gaps = append(gaps, t)
+			case *phonelab.Logline:
+				if log, ok := t.Payload.(*SpinnerStateLog); ok {
+					spinnerLogs = append(spinnerLogs, log)
+				}
+			}
+		}
+
+		if len(samples) > 0 {
+			outChan <- TrainSpinnerHMM(samples, spinnerLogs, gaps, proc.Iterations)
+		}
+		close(outChan)
+	}()
+
+	return outChan
+}
+
+type SpinnerHMMTrainProcessorGenerator struct{}
+
+func (g *SpinnerHMMTrainProcessorGenerator) GenerateProcessor(source *phonelab.PipelineSourceInstance,
+	kwargs map[string]interface{}) phonelab.Processor {
+
+	proc := &SpinnerHMMTrainProcessor{
+		Source:     source.Processor,
+		Iterations: DefaultHMMTrainIterations,
+	}
+
+	if v, ok := kwargs["iterations"]; ok {
+		proc.Iterations = v.(int)
+	}
+
+	return proc
+}
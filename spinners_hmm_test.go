@@ -0,0 +1,178 @@
+package libphonelabgo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+func TestParseSpinnerState(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	payload := `{"Action":"SpinnerEvent","Type":"start","View":"android.widget.ProgressBar","Parent":"NULL","RootView":"android.widget.ProgressBar","AppName":"com.facebook.katana","Pid":26367,"Uid":10085,"Tid":26367,"Id":2131559485,"Token":"","Activity":"com.facebook.katana.activity.FbMainTabActivity","SessionID":"02d76b1f-3407-4fd3-a2c4-ef9d27bebddd","Time":1480665811041,"UpTime":184541803,"timestamp":1480665811041,"uptimeNanos":209162776833645,"LogFormat":"1.1"}`
+
+	obj, err := NewSpinnerStateParser().Parse(payload)
+	require.Nil(err)
+	log, ok := obj.(*SpinnerStateLog)
+	require.True(ok)
+
+	assert.Equal(SpinnerStateActionEvent, log.Action)
+	assert.Equal(SpinnerStateTypeStart, log.Type)
+	assert.Equal("com.facebook.katana", log.AppName)
+	assert.Equal(26367, log.Pid)
+	assert.Equal(int64(2131559485), log.Id)
+	assert.Equal(int64(184541803), log.UpTimeMs)
+}
+
+func spinnerStateTestLog(what string, id, upTimeMs int64) *SpinnerStateLog {
+	return &SpinnerStateLog{
+		Action:   SpinnerStateActionEvent,
+		Type:     what,
+		AppName:  "com.example",
+		Pid:      100,
+		Id:       id,
+		UpTimeMs: upTimeMs,
+	}
+}
+
+func TestSpinnerStateIntervals(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	intervals := SpinnerStateIntervals([]*SpinnerStateLog{
+		spinnerStateTestLog(SpinnerStateTypeStart, 1, 100),
+		spinnerStateTestLog(SpinnerStateTypeStart, 1, 150),
+		spinnerStateTestLog(SpinnerStateTypeStart, 2, 200),
+		spinnerStateTestLog(SpinnerStateTypeStop, 1, 300),
+		spinnerStateTestLog(SpinnerStateTypeStop, 2, 400),
+		// Out of order
+		spinnerStateTestLog(SpinnerStateTypeStop, 1, 700),
+		spinnerStateTestLog(SpinnerStateTypeStart, 1, 500),
+	})
+
+	assert.Equal([]*SpinnerStateInterval{
+		&SpinnerStateInterval{100, 400, "com.example"},
+		&SpinnerStateInterval{500, 700, "com.example"},
+	}, intervals)
+}
+
+// Idle, then a spinner from 1000ms, content loading at 2000ms and idle
+// again.
+func hmmTestDiffs(offsetMs int64) []*FrameDiffSample {
+	content := make([]*GridEntry, 0)
+	for _, pos := range []int{8, 9, 10, 11, 12, 16, 17, 18, 19, 20} {
+		content = append(content, &GridEntry{pos, 100.0})
+	}
+
+	diffs := make([]*FrameDiffSample, 0)
+	for ts := int64(0); ts < 3000; ts += 50 {
+		var diff *FrameDiffSample
+		switch {
+		case ts >= 1000 && ts < 2000:
+			diff = heatmapTestDiff(offsetMs+ts, &GridEntry{60, 36.0})
+		case ts >= 2000 && ts < 2250:
+			diff = heatmapTestDiff(offsetMs+ts, content...)
+		default:
+			diff = heatmapTestDiff(offsetMs + ts)
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs
+}
+
+func TestHMMSpinnerAlgo(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	algo := NewHMMSpinnerAlgo(&SpinnerAlgoConf{}, nil)
+	spinners := make([]*Spinner, 0)
+	for _, diff := range hmmTestDiffs(0) {
+		if res := algo.Handle(diff); res != nil {
+			spinners = append(spinners, res.(*Spinner))
+		}
+	}
+
+	require.Equal(1, len(spinners))
+	assert.Equal(int64(1000), spinners[0].StartTimeMs)
+	assert.Equal(int64(2000), spinners[0].EndTimeMs)
+	assert.True(spinners[0].Confidence > 0.5)
+}
+
+func TestHMMViterbiSpinnerProcessor(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	logs := make([]interface{}, 0)
+	for _, diff := range hmmTestDiffs(0) {
+		logs = append(logs, diff)
+	}
+
+	res := collectAll(&HMMViterbiSpinnerProcessor{
+		Source: &sliceSource{logs},
+		Model:  DefaultSpinnerHMM(),
+	})
+	require.Equal(1, len(res))
+	spinner := res[0].(*Spinner)
+	assert.Equal(int64(1000), spinner.StartTimeMs)
+	assert.Equal(int64(2000), spinner.EndTimeMs)
+
+	// Cut off by a gap
+	logs = logs[:30]
+	logs = append(logs, &DataGap{StartNs: 1450 * nsPerMs, EndNs: 5000 * nsPerMs})
+
+	res = collectAll(&HMMViterbiSpinnerProcessor{
+		Source: &sliceSource{logs},
+		Conf:   SpinnerAlgoConf{GapPolicy: GapPolicyMark},
+		Model:  DefaultSpinnerHMM(),
+	})
+	require.Equal(1, len(res))
+	spinner = res[0].(*Spinner)
+	assert.True(spinner.Incomplete)
+	assert.Equal(int64(1450), spinner.EndTimeMs)
+}
+
+func TestTrainSpinnerHMM(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	samples := append(hmmTestDiffs(0), hmmTestDiffs(10000)...)
+	spinnerLogs := []*SpinnerStateLog{
+		spinnerStateTestLog(SpinnerStateTypeStart, 1, 1000),
+		spinnerStateTestLog(SpinnerStateTypeStop, 1, 1999),
+		spinnerStateTestLog(SpinnerStateTypeStart, 1, 11000),
+		spinnerStateTestLog(SpinnerStateTypeStop, 1, 11999),
+	}
+	gaps := []*DataGap{&DataGap{StartNs: 5000 * nsPerMs, EndNs: 10000 * nsPerMs}}
+
+	res := TrainSpinnerHMM(samples, spinnerLogs, gaps, 10)
+	require.NotNil(res.Model)
+	require.Nil(res.Model.validate())
+	assert.Equal(len(samples), res.Samples)
+	assert.Equal(2, res.Sequences)
+	assert.Equal(2, res.Spinners)
+	assert.False(math.IsInf(res.LogLikelihood, 0) || math.IsNaN(res.LogLikelihood))
+
+	for s := 0; s < numHMMStates; s++ {
+		sum := 0.0
+		for _, p := range res.Model.Emission[s] {
+			sum += p
+		}
+		assert.InDelta(1.0, sum, 0.0001)
+	}
+
+	// The trained model finds the spinner
+	obs := make([]int, 0)
+	for _, diff := range hmmTestDiffs(0) {
+		obs = append(obs, hmmSymbol(diff))
+	}
+	path := res.Model.Viterbi(obs)
+	assert.Equal(HMMStateSpinner, path[30])
+	assert.NotEqual(HMMStateSpinner, path[10])
+	assert.NotEqual(HMMStateSpinner, path[50])
+}
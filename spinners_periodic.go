@@ -0,0 +1,298 @@
+package libphonelabgo
+
+import (
+	"math"
+)
+
+// spinners_periodic.go is a spinner algorithm based on periodicity. Spinners
+// redraw at a fixed rate, so the diff series over a short window correlates
+// with itself shifted by the period. Scrolling and content loading don't.
+
+const (
+	defaultPeriodicWindowMs      = 1000
+	defaultPeriodicMinPeriodMs   = 30
+	defaultPeriodicMaxPeriodMs   = 500
+	defaultPeriodicMinConfidence = 0.5
+	defaultPeriodicVotesIn       = 2
+	defaultPeriodicVotesOut      = 2
+
+	// Consecutive detections within this fraction of each other have the
+	// same period.
+	periodicPeriodTolerance = 0.2
+)
+
+// The diff series is binned at the display refresh rate.
+var periodicBinMs = float64(defaultVsyncPeriodNs) / nsPerMsF
+
+// Find the strongest period in a series of equally spaced values. Returns the
+// smallest lag, in bins, that is a local peak of the autocorrelation with at
+// least minConf correlation, along with the correlation. Returns 0 if there
+// isn't one, including when the series is flat.
+func autocorrPeriod(series []float64, minLag, maxLag int, minConf float64) (int, float64) {
+	n := len(series)
+	if n < 4 {
+		return 0, 0.0
+	}
+
+	mean := 0.0
+	for _, v := range series {
+		mean += v
+	}
+	mean /= float64(n)
+
+	variance := 0.0
+	for _, v := range series {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(n)
+	if variance <= 0.0 {
+		return 0, 0.0
+	}
+
+	// The period has to repeat at least twice in the window
+	if maxLag > n/2 {
+		maxLag = n / 2
+	}
+	if minLag < 1 {
+		minLag = 1
+	}
+	if maxLag < minLag {
+		return 0, 0.0
+	}
+
+	// r(0) is the series against itself, so a lag of 1 is only a peak if
+	// it's a perfect match.
+	r := func(lag int) float64 {
+		if lag == 0 {
+			return 1.0
+		}
+		if lag < 0 || lag >= n {
+			return math.Inf(-1)
+		}
+		sum := 0.0
+		for i := 0; i+lag < n; i++ {
+			sum += (series[i] - mean) * (series[i+lag] - mean)
+		}
+		return sum / float64(n-lag) / variance
+	}
+
+	for lag := minLag; lag <= maxLag; lag++ {
+		c := r(lag)
+		if c >= minConf && c >= r(lag-1) && c >= r(lag+1) {
+			return lag, c
+		}
+	}
+	return 0, 0.0
+}
+
+// PeriodicSpinnerAlgo slides a window over the diff series and declares a
+// spinner once NumVotesIn consecutive windows find the same period. It ends
+// once NumVotesOut consecutive windows don't.
+type PeriodicSpinnerAlgo struct {
+	Conf SpinnerAlgoConf
+
+	// State
+	state       *spinnerState
+	window      []*FrameDiffSample
+	votesNeeded int
+	// First window of the pending or current spinner
+	pendingStart *FrameDiffSample
+	// Last sample that changed enough to be part of a spinner
+	lastActive   *FrameDiffSample
+	lastPeriodMs float64
+	// Detections for the current spinner
+	periods     []float64
+	confidences []float64
+}
+
+func NewPeriodicSpinnerAlgo(conf *SpinnerAlgoConf) *PeriodicSpinnerAlgo {
+	c := *conf
+	if c.WindowMs <= 0 {
+		c.WindowMs = defaultPeriodicWindowMs
+	}
+	if c.MinPeriodMs <= 0 {
+		c.MinPeriodMs = defaultPeriodicMinPeriodMs
+	}
+	if c.MaxPeriodMs <= 0 {
+		c.MaxPeriodMs = defaultPeriodicMaxPeriodMs
+	}
+	if c.MinConfidence <= 0.0 {
+		c.MinConfidence = defaultPeriodicMinConfidence
+	}
+	if c.NumVotesIn <= 0 {
+		c.NumVotesIn = defaultPeriodicVotesIn
+	}
+	if c.NumVotesOut <= 0 {
+		c.NumVotesOut = defaultPeriodicVotesOut
+	}
+
+	return &PeriodicSpinnerAlgo{
+		Conf:        c,
+		state:       &spinnerState{},
+		window:      make([]*FrameDiffSample, 0),
+		votesNeeded: c.NumVotesIn,
+	}
+}
+
+func (algo *PeriodicSpinnerAlgo) reset() {
+	algo.window = algo.window[:0]
+	algo.votesNeeded = algo.Conf.NumVotesIn
+	algo.pendingStart = nil
+	algo.lastActive = nil
+	algo.lastPeriodMs = 0.0
+	algo.periods = nil
+	algo.confidences = nil
+}
+
+// Look for a period in the current window. Returns the period in ms and the
+// confidence, or 0 if there isn't one.
+func (algo *PeriodicSpinnerAlgo) detect() (float64, float64) {
+	if len(algo.window) == 0 {
+		return 0.0, 0.0
+	}
+
+	startMs := algo.window[0].Timestamp
+	endMs := algo.window[len(algo.window)-1].Timestamp
+	if endMs-startMs < int64(algo.Conf.WindowMs)/2 {
+		// Not enough history yet
+		return 0.0, 0.0
+	}
+
+	series := make([]float64, int(float64(algo.Conf.WindowMs)/periodicBinMs)+1)
+	for _, sample := range algo.window {
+		if algo.Conf.Max > 0.0 && sample.PctDiff >= algo.Conf.Max {
+			// Too much changed for this to be a spinner
+			return 0.0, 0.0
+		}
+		if sample.PctDiff <= algo.Conf.Min {
+			continue
+		}
+		bin := int(float64(sample.Timestamp-startMs) / periodicBinMs)
+		if bin < len(series) {
+			series[bin] += sample.PctDiff
+		}
+	}
+
+	minLag := int(math.Ceil(float64(algo.Conf.MinPeriodMs) / periodicBinMs))
+	maxLag := int(float64(algo.Conf.MaxPeriodMs) / periodicBinMs)
+
+	lag, conf := autocorrPeriod(series, minLag, maxLag, algo.Conf.MinConfidence)
+	if lag == 0 {
+		return 0.0, 0.0
+	}
+	return float64(lag) * periodicBinMs, conf
+}
+
+func samePeriod(a, b float64) bool {
+	return math.Abs(a-b) <= periodicPeriodTolerance*math.Max(a, b)
+}
+
+// The window still holds the spinner for a while after it stops, so it ends
+// with the last change instead of when the period is lost.
+func (algo *PeriodicSpinnerAlgo) endSpinner(sample *FrameDiffSample) *Spinner {
+	if algo.lastActive != nil {
+		sample = algo.lastActive
+	}
+	algo.state.setState(false)
+	s := algo.state.endSpinner(sample)
+	algo.setPeriod(s)
+	return s
+}
+
+// Fill in the average period and confidence over the spinner.
+func (algo *PeriodicSpinnerAlgo) setPeriod(s *Spinner) {
+	if len(algo.periods) == 0 {
+		return
+	}
+	sumPeriod, sumConf := 0.0, 0.0
+	for i := range algo.periods {
+		sumPeriod += algo.periods[i]
+		sumConf += algo.confidences[i]
+	}
+	s.PeriodMs = sumPeriod / float64(len(algo.periods))
+	s.Confidence = sumConf / float64(len(algo.confidences))
+}
+
+func (algo *PeriodicSpinnerAlgo) Handle(log interface{}) interface{} {
+	if gap, ok := log.(*DataGap); ok {
+		s, reset := algo.state.onGap(gap, algo.Conf.GapPolicy)
+		if s != nil {
+			algo.setPeriod(s)
+		}
+		if reset {
+			// The window doesn't carry across the gap
+			algo.reset()
+		}
+		if s != nil {
+			return s
+		}
+		return nil
+	}
+
+	// We're expecting only frame diff samples
+	sample, ok := log.(*FrameDiffSample)
+	if !ok {
+		return nil
+	}
+
+	if sample.PctDiff == 0.0 && algo.Conf.IgnoreZeros {
+		return nil
+	}
+
+	algo.window = append(algo.window, sample)
+	if sample.PctDiff > algo.Conf.Min {
+		algo.lastActive = sample
+	}
+	for len(algo.window) > 0 && sample.Timestamp-algo.window[0].Timestamp > int64(algo.Conf.WindowMs) {
+		algo.window = algo.window[1:]
+	}
+
+	periodMs, conf := algo.detect()
+
+	if algo.state.isSpinner {
+		if periodMs > 0.0 {
+			algo.votesNeeded = algo.Conf.NumVotesOut
+			algo.periods = append(algo.periods, periodMs)
+			algo.confidences = append(algo.confidences, conf)
+			return nil
+		}
+
+		algo.votesNeeded -= 1
+		if algo.votesNeeded <= 0 {
+			s := algo.endSpinner(sample)
+			algo.reset()
+			return s
+		}
+		return nil
+	}
+
+	if periodMs == 0.0 || (algo.pendingStart != nil && !samePeriod(periodMs, algo.lastPeriodMs)) {
+		// Start over
+		algo.votesNeeded = algo.Conf.NumVotesIn
+		algo.pendingStart = nil
+		algo.periods = nil
+		algo.confidences = nil
+		if periodMs == 0.0 {
+			return nil
+		}
+	}
+
+	if algo.pendingStart == nil {
+		algo.pendingStart = algo.window[0]
+	}
+	algo.lastPeriodMs = periodMs
+	algo.periods = append(algo.periods, periodMs)
+	algo.confidences = append(algo.confidences, conf)
+
+	algo.votesNeeded -= 1
+	if algo.votesNeeded <= 0 {
+		// A stable period, the spinner started with the first window
+		algo.state.setState(true)
+		algo.state.markStartTime(algo.pendingStart)
+		algo.votesNeeded = algo.Conf.NumVotesOut
+	}
+
+	return nil
+}
+
+func (algo *PeriodicSpinnerAlgo) Finish() {}
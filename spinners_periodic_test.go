@@ -0,0 +1,86 @@
+package libphonelabgo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestAutocorrPeriod(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	series := make([]float64, 60)
+	for i := 0; i < len(series); i += 6 {
+		series[i] = 1.0
+	}
+	lag, conf := autocorrPeriod(series, 2, 30, 0.5)
+	assert.Equal(6, lag)
+	assert.InDelta(1.0, conf, 0.0001)
+
+	// Flat
+	lag, _ = autocorrPeriod(make([]float64, 60), 2, 30, 0.5)
+	assert.Equal(0, lag)
+
+	// A single burst
+	series = make([]float64, 60)
+	series[10], series[11], series[12] = 1.0, 1.0, 1.0
+	lag, _ = autocorrPeriod(series, 2, 30, 0.5)
+	assert.Equal(0, lag)
+
+	// A slow sawtooth correlates well at lag 1, but that isn't a peak
+	series = make([]float64, 60)
+	for i := range series {
+		series[i] = float64(i % 20)
+	}
+	lag, _ = autocorrPeriod(series, 1, 30, 0.5)
+	assert.Equal(20, lag)
+}
+
+func periodicSpinnerTestRun(algo *PeriodicSpinnerAlgo, logs []*FrameDiffSample) []*Spinner {
+	spinners := make([]*Spinner, 0)
+	for _, log := range logs {
+		if res := algo.Handle(log); res != nil {
+			spinners = append(spinners, res.(*Spinner))
+		}
+	}
+	return spinners
+}
+
+func TestPeriodicSpinnerAlgo(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := &SpinnerAlgoConf{Min: 0.1, Max: 5.0}
+
+	// Redraws every 100ms until 2s, then nothing
+	logs := make([]*FrameDiffSample, 0)
+	for ts := int64(0); ts <= 3500; ts += 50 {
+		diff := 0.0
+		if ts%100 == 0 && ts <= 2000 {
+			diff = 1.0
+		}
+		logs = append(logs, &FrameDiffSample{
+			SFFrameDiff: SFFrameDiff{Timestamp: ts, PctDiff: diff},
+		})
+	}
+
+	spinners := periodicSpinnerTestRun(NewPeriodicSpinnerAlgo(conf), logs)
+	require.Equal(1, len(spinners))
+	assert.Equal(int64(0), spinners[0].StartTimeMs)
+	assert.Equal(int64(2000), spinners[0].EndTimeMs)
+	assert.InDelta(100.0, spinners[0].PeriodMs, 1.0)
+	assert.True(spinners[0].Confidence >= 0.5)
+
+	// Content loading in irregular bursts, within the min/max band
+	logs = make([]*FrameDiffSample, 0)
+	for _, ts := range []int64{0, 30, 170, 190, 480, 520, 530, 900, 1250, 1260, 1270, 1700, 2300} {
+		logs = append(logs, &FrameDiffSample{
+			SFFrameDiff: SFFrameDiff{Timestamp: ts, PctDiff: 2.0},
+		})
+	}
+	algo := NewPeriodicSpinnerAlgo(conf)
+	assert.Equal(0, len(periodicSpinnerTestRun(algo, logs)))
+	assert.False(algo.state.isSpinner)
+}
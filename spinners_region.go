@@ -0,0 +1,311 @@
+package libphonelabgo
+
+// spinners_region.go is a spinner algorithm that looks at where the screen
+// changed, not just how much. A spinner is a small, connected patch of grid
+// cells that keeps changing at a steady rate. Frames with any large changed
+// area are rejected, even if their global PctDiff falls within the min/max
+// band, so full screen animations aren't mistaken for spinners.
+
+const (
+	defaultRegionMaxCells = 4
+	defaultRegionVotesIn  = 3
+	defaultRegionVotesOut = 1
+)
+
+// SpinnerRegion is where a spinner was on screen, in screen pixels.
+type SpinnerRegion struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+	// Grid cells covered
+	Cells int `json:"cells"`
+}
+
+// Union of two regions.
+func (r *SpinnerRegion) Append(other *SpinnerRegion) {
+	x1 := maxFloat(r.X+r.Width, other.X+other.Width)
+	y1 := maxFloat(r.Y+r.Height, other.Y+other.Height)
+	r.X = minFloat(r.X, other.X)
+	r.Y = minFloat(r.Y, other.Y)
+	r.Width = x1 - r.X
+	r.Height = y1 - r.Y
+	if other.Cells > r.Cells {
+		r.Cells = other.Cells
+	}
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Bounding box of grid cells, rows and columns inclusive.
+type gridBox struct {
+	minRow, minCol, maxRow, maxCol int
+	cells                          int
+}
+
+func (box *gridBox) add(p position) {
+	if box.cells == 0 {
+		box.minRow, box.maxRow = p.row, p.row
+		box.minCol, box.maxCol = p.col, p.col
+	} else {
+		box.minRow = minInt(box.minRow, p.row)
+		box.maxRow = maxInt(box.maxRow, p.row)
+		box.minCol = minInt(box.minCol, p.col)
+		box.maxCol = maxInt(box.maxCol, p.col)
+	}
+	box.cells += 1
+}
+
+// Boxes that touch or overlap are considered the same spinner.
+func (box *gridBox) near(other *gridBox) bool {
+	return box.minRow <= other.maxRow+1 && other.minRow <= box.maxRow+1 &&
+		box.minCol <= other.maxCol+1 && other.minCol <= box.maxCol+1
+}
+
+func (box *gridBox) union(other *gridBox) *gridBox {
+	return &gridBox{
+		minRow: minInt(box.minRow, other.minRow),
+		minCol: minInt(box.minCol, other.minCol),
+		maxRow: maxInt(box.maxRow, other.maxRow),
+		maxCol: maxInt(box.maxCol, other.maxCol),
+		cells:  maxInt(box.cells, other.cells),
+	}
+}
+
+func (box *gridBox) region(props *screenGridProps) *SpinnerRegion {
+	x0, y0, _, _ := props.cellRect(box.minRow, box.minCol)
+	_, _, x1, y1 := props.cellRect(box.maxRow, box.maxCol)
+	return &SpinnerRegion{
+		X:      x0,
+		Y:      y0,
+		Width:  x1 - x0,
+		Height: y1 - y0,
+		Cells:  box.cells,
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Group the changed cells of a diff into 4-connected components.
+func changedComponents(diff *SFFrameDiff, props *screenGridProps) []*gridBox {
+	changed := make(map[position]bool)
+	for _, entry := range diff.GridEntries {
+		if entry.Value <= 0.0 {
+			continue
+		}
+		if row, col := props.entryPosToGridPos(entry.Position); row >= 0 && col >= 0 {
+			changed[position{row, col}] = true
+		}
+	}
+
+	seen := make(map[position]bool)
+	components := make([]*gridBox, 0)
+
+	// Visit in grid order so the result doesn't depend on map order
+	for row := 0; row < props.rows; row++ {
+		for col := 0; col < props.cols; col++ {
+			start := position{row, col}
+			if !changed[start] || seen[start] {
+				continue
+			}
+
+			box := &gridBox{}
+			stack := []position{start}
+			seen[start] = true
+
+			for len(stack) > 0 {
+				p := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				box.add(p)
+
+				for _, n := range []position{
+					{p.row - 1, p.col}, {p.row + 1, p.col},
+					{p.row, p.col - 1}, {p.row, p.col + 1},
+				} {
+					if changed[n] && !seen[n] {
+						seen[n] = true
+						stack = append(stack, n)
+					}
+				}
+			}
+			components = append(components, box)
+		}
+	}
+
+	return components
+}
+
+// RegionSpinnerAlgo tracks change per grid cell. It takes NumVotesIn
+// consecutive frames changing in the same small region at a steady rate to
+// start a spinner, and NumVotesOut frames without it to end one.
+type RegionSpinnerAlgo struct {
+	Conf SpinnerAlgoConf
+
+	// State
+	state       *spinnerState
+	votesNeeded int
+	candidates  []*FrameDiffSample
+	box         *gridBox
+}
+
+func NewRegionSpinnerAlgo(conf *SpinnerAlgoConf) *RegionSpinnerAlgo {
+	c := *conf
+	if c.MaxCells <= 0 {
+		c.MaxCells = defaultRegionMaxCells
+	}
+	if c.NumVotesIn <= 0 {
+		c.NumVotesIn = defaultRegionVotesIn
+	}
+	if c.NumVotesOut <= 0 {
+		c.NumVotesOut = defaultRegionVotesOut
+	}
+
+	return &RegionSpinnerAlgo{
+		Conf:        c,
+		state:       &spinnerState{},
+		votesNeeded: c.NumVotesOut,
+		candidates:  make([]*FrameDiffSample, 0),
+	}
+}
+
+// Find the spinner-like region in a diff, if there is one. near, if not nil,
+// is where the spinner is expected to be.
+func (algo *RegionSpinnerAlgo) spinnerBox(sample *FrameDiffSample, near *gridBox) *gridBox {
+	if sample.PctDiff <= algo.Conf.Min || (algo.Conf.Max > 0 && sample.PctDiff >= algo.Conf.Max) {
+		return nil
+	}
+
+	var found *gridBox
+	for _, box := range changedComponents(&sample.SFFrameDiff, allScreenGrids[0]) {
+		if box.cells > algo.Conf.MaxCells {
+			// Something big is changing, it isn't just a spinner
+			return nil
+		}
+		if found == nil && (near == nil || box.near(near)) {
+			found = box
+		}
+	}
+	return found
+}
+
+func (algo *RegionSpinnerAlgo) resetCandidates() {
+	algo.candidates = algo.candidates[:0]
+	algo.box = nil
+}
+
+func (algo *RegionSpinnerAlgo) candidateTimes() []int64 {
+	times := make([]int64, 0, len(algo.candidates))
+	for _, c := range algo.candidates {
+		times = append(times, c.Timestamp)
+	}
+	return times
+}
+
+func (algo *RegionSpinnerAlgo) endSpinner(sample *FrameDiffSample) *Spinner {
+	algo.state.setState(false)
+	s := algo.state.endSpinner(sample)
+	s.Region = algo.box.region(allScreenGrids[0])
+	algo.resetCandidates()
+	return s
+}
+
+func (algo *RegionSpinnerAlgo) Handle(log interface{}) interface{} {
+	if gap, ok := log.(*DataGap); ok {
+		box := algo.box
+		s, reset := algo.state.onGap(gap, algo.Conf.GapPolicy)
+		if s != nil && box != nil {
+			s.Region = box.region(allScreenGrids[0])
+		}
+		if reset {
+			algo.resetCandidates()
+			algo.votesNeeded = algo.Conf.NumVotesOut
+		}
+		if s != nil {
+			return s
+		}
+		return nil
+	}
+
+	// We're expecting only frame diff samples
+	sample, ok := log.(*FrameDiffSample)
+	if !ok {
+		return nil
+	}
+
+	if sample.PctDiff == 0.0 && algo.Conf.IgnoreZeros {
+		return nil
+	}
+
+	box := algo.spinnerBox(sample, algo.box)
+
+	if algo.state.isSpinner {
+		if box != nil {
+			algo.box = algo.box.union(box)
+			algo.votesNeeded = algo.Conf.NumVotesOut
+			return nil
+		}
+
+		algo.votesNeeded -= 1
+		if algo.votesNeeded <= 0 {
+			return algo.endSpinner(sample)
+		}
+		return nil
+	}
+
+	if box == nil {
+		// Start over, possibly somewhere else
+		algo.resetCandidates()
+		if box = algo.spinnerBox(sample, nil); box == nil {
+			return nil
+		}
+	}
+
+	if algo.box == nil {
+		algo.box = box
+	} else {
+		algo.box = algo.box.union(box)
+	}
+	algo.candidates = append(algo.candidates, sample)
+
+	if len(algo.candidates) < algo.Conf.NumVotesIn {
+		return nil
+	}
+
+	// Spinners change at a steady rate
+	if len(algo.candidates) >= 3 && !isPeriodic(algo.candidateTimes()) {
+		algo.candidates = algo.candidates[1:]
+		return nil
+	}
+
+	algo.state.setState(true)
+	algo.state.markStartTime(algo.candidates[0])
+	algo.votesNeeded = algo.Conf.NumVotesOut
+
+	return nil
+}
+
+func (algo *RegionSpinnerAlgo) Finish() {}
@@ -0,0 +1,121 @@
+package libphonelabgo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func regionSpinnerTestRun(algo *RegionSpinnerAlgo, logs []interface{}) []*Spinner {
+	spinners := make([]*Spinner, 0)
+	for _, log := range logs {
+		if res := algo.Handle(log); res != nil {
+			spinners = append(spinners, res.(*Spinner))
+		}
+	}
+	return spinners
+}
+
+func TestRegionSpinnerAlgo(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := &SpinnerAlgoConf{Min: 0.1, Max: 5.0}
+
+	// A small spinner moving between two neighboring cells, then nothing
+	logs := make([]interface{}, 0)
+	for ts := int64(0); ts <= 1000; ts += 100 {
+		pos := 10
+		if ts%200 == 0 {
+			pos = 11
+		}
+		logs = append(logs, heatmapTestDiff(ts, &GridEntry{pos, 50.0}))
+	}
+	logs = append(logs, heatmapTestDiff(1100))
+
+	spinners := regionSpinnerTestRun(NewRegionSpinnerAlgo(conf), logs)
+	require.Equal(1, len(spinners))
+	assert.Equal(int64(0), spinners[0].StartTimeMs)
+	assert.Equal(int64(1100), spinners[0].EndTimeMs)
+
+	props := allScreenGrids[0]
+	row, col := props.entryPosToGridPos(10)
+	x0, y0, _, _ := props.cellRect(row, col)
+	require.NotNil(spinners[0].Region)
+	assert.Equal(&SpinnerRegion{
+		X:      x0,
+		Y:      y0,
+		Width:  2 * props.pixelsPerWH,
+		Height: props.pixelsPerWH,
+		Cells:  1,
+	}, spinners[0].Region)
+
+	// A full screen animation within the min/max band is rejected
+	logs = make([]interface{}, 0)
+	for ts := int64(0); ts <= 1000; ts += 100 {
+		entries := make([]*GridEntry, 0)
+		for row := 0; row < 8; row++ {
+			for col := 0; col < 5; col++ {
+				entries = append(entries, &GridEntry{row*8 + col, 3.0})
+			}
+		}
+		diff := heatmapTestDiff(ts, entries...)
+		require.True(diff.PctDiff > conf.Min && diff.PctDiff < conf.Max)
+		logs = append(logs, diff)
+	}
+	logs = append(logs, heatmapTestDiff(1100))
+	assert.Equal(0, len(regionSpinnerTestRun(NewRegionSpinnerAlgo(conf), logs)))
+
+	// Small changes at irregular times aren't a spinner either
+	logs = make([]interface{}, 0)
+	for _, ts := range []int64{0, 20, 500, 520, 1100, 1120} {
+		logs = append(logs, heatmapTestDiff(ts, &GridEntry{10, 50.0}))
+	}
+	logs = append(logs, heatmapTestDiff(1200))
+	assert.Equal(0, len(regionSpinnerTestRun(NewRegionSpinnerAlgo(conf), logs)))
+}
+
+func TestRegionSpinnerDataGap(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	algo := NewRegionSpinnerAlgo(&SpinnerAlgoConf{
+		Min:       0.1,
+		Max:       5.0,
+		GapPolicy: GapPolicyMark,
+	})
+
+	for _, ts := range []int64{100, 200, 300, 400} {
+		assert.Nil(algo.Handle(heatmapTestDiff(ts, &GridEntry{10, 50.0})))
+	}
+	require.True(algo.state.isSpinner)
+
+	res := algo.Handle(&DataGap{StartNs: 400 * nsPerMs, EndNs: 2000 * nsPerMs})
+	assert.False(algo.state.isSpinner)
+
+	spinner, ok := res.(*Spinner)
+	require.True(ok)
+	assert.True(spinner.Incomplete)
+	require.NotNil(spinner.Region)
+	assert.Equal(1, spinner.Region.Cells)
+}
+
+func TestSpinnerAppendRegion(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	s := &Spinner{StartTimeMs: 0, EndTimeMs: 100}
+	s.Append(&Spinner{
+		EndTimeMs: 200,
+		Region:    &SpinnerRegion{X: 100, Y: 100, Width: 50, Height: 50, Cells: 1},
+	})
+	s.Append(&Spinner{
+		EndTimeMs: 300,
+		Region:    &SpinnerRegion{X: 120, Y: 80, Width: 50, Height: 50, Cells: 2},
+	})
+
+	assert.Equal(int64(300), s.DurationMs)
+	assert.Equal(&SpinnerRegion{X: 100, Y: 80, Width: 70, Height: 70, Cells: 2}, s.Region)
+}
@@ -248,3 +248,106 @@ processors:
 	assert.True(reflect.DeepEqual(proc.Inputs[0].Args,
 		conf.Sink.Args))
 }
+
+func stitcherTestRun(stitcher *SpinnerStitcher, logs ...interface{}) []*Spinner {
+	stitcher.Source = &sliceSource{logs}
+	res := make([]*Spinner, 0)
+	for _, log := range collectAll(stitcher) {
+		res = append(res, log.(*Spinner))
+	}
+	return res
+}
+
+func stitcherTestSpinner(startMs, endMs int64) *Spinner {
+	return &Spinner{StartTimeMs: startMs, EndTimeMs: endMs, DurationMs: endMs - startMs}
+}
+
+func TestSpinnerStitcher(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	newStitcher := func() *SpinnerStitcher {
+		return &SpinnerStitcher{
+			StitchInterval: 500,
+			MinSegmentMs:   DefaultStitchMinSegmentMs,
+		}
+	}
+
+	// Small gaps are stitched, and the segments are kept
+	res := stitcherTestRun(newStitcher(),
+		stitcherTestSpinner(0, 2000),
+		stitcherTestSpinner(2200, 4000),
+		stitcherTestSpinner(4100, 6000),
+		stitcherTestSpinner(7000, 9000),
+	)
+	require.Equal(2, len(res))
+	assert.Equal(int64(0), res[0].StartTimeMs)
+	assert.Equal(int64(6000), res[0].EndTimeMs)
+	assert.Equal(int64(6000), res[0].DurationMs)
+	require.Equal(3, len(res[0].Segments))
+	assert.Equal(int64(2200), res[0].Segments[1].StartTimeMs)
+	assert.Nil(res[1].Segments)
+
+	// Short spinners are passed through
+	res = stitcherTestRun(newStitcher(),
+		stitcherTestSpinner(0, 2000),
+		stitcherTestSpinner(2100, 2500),
+		stitcherTestSpinner(2600, 5000),
+	)
+	assert.Equal(3, len(res))
+
+	// The default applies to stitchers built without one
+	res = stitcherTestRun(&SpinnerStitcher{StitchInterval: 500},
+		stitcherTestSpinner(0, 2000),
+		stitcherTestSpinner(2100, 2500),
+		stitcherTestSpinner(2600, 5000),
+	)
+	assert.Equal(3, len(res))
+
+	stitcher := newStitcher()
+	stitcher.MinSegmentMs = 100
+	res = stitcherTestRun(stitcher,
+		stitcherTestSpinner(0, 2000),
+		stitcherTestSpinner(2100, 2500),
+		stitcherTestSpinner(2600, 5000),
+	)
+	assert.Equal(1, len(res))
+
+	// Limit on the stitched length
+	stitcher = newStitcher()
+	stitcher.MaxMergedMs = 5000
+	res = stitcherTestRun(stitcher,
+		stitcherTestSpinner(0, 2000),
+		stitcherTestSpinner(2200, 4000),
+		stitcherTestSpinner(4100, 6000),
+	)
+	require.Equal(2, len(res))
+	assert.Equal(int64(4000), res[0].EndTimeMs)
+
+	// App changes and taps
+	appChange := &AppChangeEvent{TimestampNs: 2100 * nsPerMs, App: "com.example"}
+	tap := &TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 2100 * nsPerMs}
+
+	for _, boundary := range []interface{}{appChange, tap} {
+		res = stitcherTestRun(newStitcher(),
+			stitcherTestSpinner(0, 2000), boundary, stitcherTestSpinner(2200, 4000))
+		assert.Equal(1, len(res))
+
+		stitcher = newStitcher()
+		stitcher.AppBoundaries = true
+		stitcher.InputBoundaries = true
+		res = stitcherTestRun(stitcher,
+			stitcherTestSpinner(0, 2000), boundary, stitcherTestSpinner(2200, 4000))
+		assert.Equal(2, len(res))
+	}
+
+	// Taps during a spinner don't split it from the next one
+	stitcher = newStitcher()
+	stitcher.InputBoundaries = true
+	res = stitcherTestRun(stitcher,
+		&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 1000 * nsPerMs},
+		stitcherTestSpinner(0, 2000),
+		stitcherTestSpinner(2200, 4000))
+	assert.Equal(1, len(res))
+}
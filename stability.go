@@ -0,0 +1,263 @@
+package libphonelabgo
+
+import (
+	"math"
+	"sort"
+)
+
+// stability.go decides when the screen has visually settled after an input.
+// Some content never stops changing (ads, carousels, blinking cursors), which
+// keeps responses open until the UI timeout. Regions that keep changing by a
+// small amount at a regular interval are treated as background animation, and
+// the screen is stable once nothing but background has changed for a while.
+
+// Intervals between background changes must be this regular (standard
+// deviation / mean).
+const stabilityMaxIntervalCV = 0.5
+
+// StabilityResult is the time-to-stable for one input.
+type StabilityResult struct {
+	// Last foreground change, or the input itself if there wasn't one.
+	StableNs       int64 `json:"stable_ns"`
+	TimeToStableMs int64 `json:"time_to_stable_ms"`
+	// True if the screen was quiet for the full window before the
+	// measurement finished.
+	Stable bool `json:"stable"`
+	// Grid positions treated as background animation
+	BackgroundRegions []int `json:"background_regions,omitempty"`
+}
+
+type stabilityFrame struct {
+	timestampNs int64
+	entries     []*GridEntry
+}
+
+// Recent changes in one grid region
+type regionHistory struct {
+	times  []int64
+	values []float64
+}
+
+// StabilityRegions learns which regions are background animation. It lasts
+// for a session rather than one input, since slow animations (carousels that
+// change every few seconds) take longer than a measurement to show up.
+type StabilityRegions struct {
+	MaxBackground float64
+	MinChanges    int
+
+	history    map[int]*regionHistory
+	background map[int]bool
+	// Bumped whenever a region becomes background
+	generation int
+}
+
+func NewStabilityRegions(params *InputStateMachineParams) *StabilityRegions {
+	return &StabilityRegions{
+		MaxBackground: params.BackgroundMaxDiff,
+		MinChanges:    params.BackgroundMinChanges,
+		history:       make(map[int]*regionHistory),
+		background:    make(map[int]bool),
+	}
+}
+
+// Forget everything, e.g. when the foreground app changes.
+func (sr *StabilityRegions) Reset() {
+	sr.history = make(map[int]*regionHistory)
+	sr.background = make(map[int]bool)
+	sr.generation += 1
+}
+
+// Add a frame diff.
+func (sr *StabilityRegions) OnFrameDiff(diff *FrameDiffSample) {
+	if diff.PctDiff <= 0.0 {
+		return
+	}
+	ts := diff.TimestampNs()
+	for _, entry := range diff.GridEntries {
+		sr.updateRegion(ts, entry)
+	}
+}
+
+// Is the change to entry background animation?
+func (sr *StabilityRegions) IsBackground(entry *GridEntry) bool {
+	return sr.background[entry.Position] && entry.Value <= sr.MaxBackground
+}
+
+// Sorted background regions
+func (sr *StabilityRegions) Background() []int {
+	var res []int
+	for pos := range sr.background {
+		res = append(res, pos)
+	}
+	sort.Ints(res)
+	return res
+}
+
+// Are the intervals between times regular?
+func isPeriodic(times []int64) bool {
+	if len(times) < 3 {
+		return false
+	}
+
+	intervals := make([]float64, 0, len(times)-1)
+	sum := 0.0
+	for i := 1; i < len(times); i++ {
+		d := float64(times[i] - times[i-1])
+		intervals = append(intervals, d)
+		sum += d
+	}
+
+	mean := sum / float64(len(intervals))
+	if mean <= 0 {
+		return false
+	}
+
+	variance := 0.0
+	for _, d := range intervals {
+		variance += (d - mean) * (d - mean)
+	}
+	variance /= float64(len(intervals))
+
+	return math.Sqrt(variance)/mean <= stabilityMaxIntervalCV
+}
+
+// Update the history for a region.
+func (sr *StabilityRegions) updateRegion(ts int64, entry *GridEntry) {
+	if sr.background[entry.Position] {
+		return
+	}
+
+	hist, ok := sr.history[entry.Position]
+	if !ok {
+		hist = &regionHistory{}
+		sr.history[entry.Position] = hist
+	}
+
+	hist.times = append(hist.times, ts)
+	hist.values = append(hist.values, entry.Value)
+	if len(hist.times) > sr.MinChanges {
+		hist.times = hist.times[1:]
+		hist.values = hist.values[1:]
+	}
+
+	if len(hist.times) < sr.MinChanges {
+		return
+	}
+	for _, v := range hist.values {
+		if v > sr.MaxBackground {
+			return
+		}
+	}
+
+	if isPeriodic(hist.times) {
+		sr.background[entry.Position] = true
+		sr.generation += 1
+	}
+}
+
+// StabilityDetector tracks the frame diffs following one input.
+type StabilityDetector struct {
+	WindowMs int64
+	Regions  *StabilityRegions
+
+	inputNs          int64
+	lastNs           int64
+	lastForegroundNs int64
+	frames           []*stabilityFrame
+	generation       int
+}
+
+// Regions may be shared with other detectors in the same session. Diffs
+// between detectors should be given to it directly. If nil, the detector
+// learns its own.
+func NewStabilityDetector(params *InputStateMachineParams, regions *StabilityRegions,
+	inputNs int64) *StabilityDetector {
+
+	if regions == nil {
+		regions = NewStabilityRegions(params)
+	}
+
+	return &StabilityDetector{
+		WindowMs:         params.StabilityWindowMs,
+		Regions:          regions,
+		inputNs:          inputNs,
+		lastNs:           inputNs,
+		lastForegroundNs: InvalidResponseTime,
+		frames:           make([]*stabilityFrame, 0),
+		generation:       regions.generation,
+	}
+}
+
+func (sd *StabilityDetector) isForeground(frame *stabilityFrame) bool {
+	// Without a grid, we can't tell
+	if len(frame.entries) == 0 {
+		return true
+	}
+	for _, entry := range frame.entries {
+		if !sd.Regions.IsBackground(entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add a frame diff. Returns true if the screen has changed since the input and
+// is now stable.
+func (sd *StabilityDetector) OnFrameDiff(diff *FrameDiffSample) bool {
+	ts := diff.TimestampNs()
+	if ts < sd.inputNs {
+		return false
+	}
+	sd.lastNs = ts
+
+	if diff.PctDiff > 0.0 {
+		frame := &stabilityFrame{
+			timestampNs: ts,
+			entries:     diff.GridEntries,
+		}
+		sd.frames = append(sd.frames, frame)
+		sd.Regions.OnFrameDiff(diff)
+
+		if sd.generation != sd.Regions.generation {
+			// Earlier frames may have only been background after all
+			sd.generation = sd.Regions.generation
+			sd.lastForegroundNs = InvalidResponseTime
+			for _, f := range sd.frames {
+				if sd.isForeground(f) {
+					sd.lastForegroundNs = f.timestampNs
+				}
+			}
+		} else if sd.isForeground(frame) {
+			sd.lastForegroundNs = ts
+		}
+	}
+
+	return sd.isStable(ts)
+}
+
+func (sd *StabilityDetector) isStable(ts int64) bool {
+	return sd.lastForegroundNs != InvalidResponseTime &&
+		ts-sd.lastForegroundNs >= sd.WindowMs*nsPerMs
+}
+
+// Get the result as of finishNs.
+func (sd *StabilityDetector) Result(finishNs int64) *StabilityResult {
+	if finishNs < sd.lastNs {
+		finishNs = sd.lastNs
+	}
+
+	res := &StabilityResult{
+		StableNs: sd.lastForegroundNs,
+		Stable:   true,
+	}
+
+	if res.StableNs == InvalidResponseTime {
+		res.StableNs = sd.inputNs
+	} else {
+		res.Stable = sd.isStable(finishNs)
+	}
+	res.TimeToStableMs = (res.StableNs - sd.inputNs) / nsPerMs
+	res.BackgroundRegions = sd.Regions.Background()
+
+	return res
+}
@@ -0,0 +1,171 @@
+package libphonelabgo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// A global response at 100ms, then a small animation in one region every
+// 100ms until 1500ms.
+func stabilityTestLogs() []interface{} {
+	logs := []interface{}{
+		&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 0, X: 100, Y: 100},
+	}
+
+	response := make([]*GridEntry, 0)
+	for _, pos := range []int{8, 9, 10, 11, 12, 16, 17, 18, 19, 20} {
+		response = append(response, &GridEntry{pos, 100.0})
+	}
+	logs = append(logs, heatmapTestDiff(100, response...))
+
+	for ts := int64(200); ts <= 1500; ts += 100 {
+		logs = append(logs, heatmapTestDiff(ts, &GridEntry{60, 10.0}))
+	}
+	return logs
+}
+
+func TestIsPeriodic(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	assert.True(isPeriodic([]int64{0, 100, 200, 300}))
+	assert.True(isPeriodic([]int64{0, 90, 200, 310}))
+	assert.False(isPeriodic([]int64{0, 50, 400, 420}))
+	assert.False(isPeriodic([]int64{0, 100}))
+}
+
+func TestStabilityDetector(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	params := DefaultInputStateMachineParams()
+
+	sd := NewStabilityDetector(params, nil, 0)
+	stable := make([]int64, 0)
+	for _, log := range stabilityTestLogs()[1:] {
+		diff := log.(*FrameDiffSample)
+		if sd.OnFrameDiff(diff) {
+			stable = append(stable, diff.Timestamp)
+		}
+	}
+
+	// Stable from 500ms after the response on
+	assert.Equal(int64(600), stable[0])
+	assert.Equal(&StabilityResult{
+		StableNs:          100 * nsPerMs,
+		TimeToStableMs:    100,
+		Stable:            true,
+		BackgroundRegions: []int{60},
+	}, sd.Result(1500*nsPerMs))
+
+	// Irregular small changes are still foreground
+	sd = NewStabilityDetector(params, nil, 0)
+	for _, ts := range []int64{200, 250, 600, 620} {
+		sd.OnFrameDiff(heatmapTestDiff(ts, &GridEntry{60, 10.0}))
+	}
+	res := sd.Result(700 * nsPerMs)
+	assert.Equal(int64(620), res.TimeToStableMs)
+	assert.False(res.Stable)
+	assert.Nil(res.BackgroundRegions)
+
+	// Nothing changed at all
+	sd = NewStabilityDetector(params, nil, 0)
+	assert.False(sd.OnFrameDiff(heatmapTestDiff(1000)))
+	res = sd.Result(1000 * nsPerMs)
+	assert.Equal(int64(0), res.TimeToStableMs)
+	assert.True(res.Stable)
+}
+
+func TestInputStateMachineStability(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Finish as soon as it's stable, instead of waiting for a timeout that
+	// never comes.
+	res := collectAll(&InputStateMachineProcessor{
+		Source: &sliceSource{stabilityTestLogs()},
+		Args:   map[string]interface{}{"finish_on_stable": true},
+	})
+	require.Equal(1, len(res))
+
+	result := res[0].(*InputEventResult)
+	assert.Equal(TapEventFinishStable, result.FinishType)
+	assert.Equal(int64(600*nsPerMs), result.FinishNs)
+	require.NotNil(result.Stability)
+	assert.Equal(int64(100), result.Stability.TimeToStableMs)
+	assert.True(result.Stability.Stable)
+
+	// Detect only, the measurement goes on to the end
+	res = collectAll(&InputStateMachineProcessor{
+		Source: &sliceSource{stabilityTestLogs()},
+		Args:   map[string]interface{}{"detect_stability": true},
+	})
+	require.Equal(1, len(res))
+
+	result = res[0].(*InputEventResult)
+	assert.Equal(TapEventFinishShortCircuit, result.FinishType)
+	require.NotNil(result.Stability)
+	assert.Equal(int64(100*nsPerMs), result.Stability.StableNs)
+	assert.Equal([]int{60}, result.Stability.BackgroundRegions)
+
+	// Off by default
+	res = collectAll(&InputStateMachineProcessor{
+		Source: &sliceSource{stabilityTestLogs()},
+	})
+	require.Equal(1, len(res))
+	assert.Nil(res[0].(*InputEventResult).Stability)
+}
+
+// A carousel too slow to learn within one measurement is learned between
+// inputs.
+func TestInputStateMachineStabilitySlowBackground(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	logs := make([]interface{}, 0)
+	for ts := int64(0); ts <= 10000; ts += 2000 {
+		logs = append(logs, heatmapTestDiff(ts, &GridEntry{60, 10.0}))
+	}
+
+	response := make([]*GridEntry, 0)
+	for _, pos := range []int{8, 9, 10, 11, 12, 16, 17, 18, 19, 20} {
+		response = append(response, &GridEntry{pos, 100.0})
+	}
+	logs = append(logs,
+		&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 10500 * nsPerMs, X: 100, Y: 100},
+		heatmapTestDiff(10600, response...),
+		heatmapTestDiff(12000, &GridEntry{60, 10.0}),
+	)
+
+	res := collectAll(&InputStateMachineProcessor{
+		Source: &sliceSource{logs},
+		Args:   map[string]interface{}{"finish_on_stable": true},
+	})
+	require.Equal(1, len(res))
+
+	result := res[0].(*InputEventResult)
+	assert.Equal(TapEventFinishStable, result.FinishType)
+	assert.Equal(int64(12000*nsPerMs), result.FinishNs)
+	require.NotNil(result.Stability)
+	assert.Equal(int64(100), result.Stability.TimeToStableMs)
+	assert.Equal([]int{60}, result.Stability.BackgroundRegions)
+}
+
+func TestStabilityRegions(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	regions := NewStabilityRegions(DefaultInputStateMachineParams())
+	for ts := int64(0); ts < 8000; ts += 2000 {
+		regions.OnFrameDiff(heatmapTestDiff(ts, &GridEntry{60, 10.0}))
+	}
+	assert.Equal([]int{60}, regions.Background())
+	assert.True(regions.IsBackground(&GridEntry{60, 10.0}))
+	assert.False(regions.IsBackground(&GridEntry{60, 50.0}))
+
+	regions.Reset()
+	assert.Nil(regions.Background())
+}
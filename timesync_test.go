@@ -1,7 +1,9 @@
 package libphonelabgo
 
 import (
+	phonelab "github.com/shaseley/phonelab-go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"testing"
 )
 
@@ -23,3 +25,29 @@ func TestFrameDiffOffset(t *testing.T) {
 		assert.Equal(test.expected, adjustTimestampMsToS(test.ts, test.offset))
 	}
 }
+
+// The TimeSyncPreprocessor sends TimeSyncMsgs on their own, not as logline
+// payloads.
+func TestTimeSyncMsgOnItsOwn(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	diffs := collectAll(&FrameDiffEmitter{
+		Source: &sliceSource{[]interface{}{
+			&TimeSyncMsg{OffsetNs: 2 * nsPerSec},
+			diffLogline(1, 1000),
+		}},
+	})
+	require.Equal(1, len(diffs))
+	assert.InDelta(3.0, diffs[0].(*FrameDiffSample).TraceTimeAdj, 1e-9)
+
+	frames := collectAll(&FrameRefreshEmitter{
+		Source: &sliceSource{[]interface{}{
+			&TimeSyncMsg{OffsetNs: 2 * nsPerSec},
+			&phonelab.Logline{Payload: &SFFrameTimesLog{Token: 1, Times: []int64{nsPerSec}}},
+		}},
+	})
+	require.Equal(1, len(frames))
+	assert.InDelta(3.0, frames[0].(*FrameRefreshEvent).TraceTimeAdj, 1e-9)
+}
@@ -0,0 +1,479 @@
+package libphonelabgo
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	phonelab "github.com/shaseley/phonelab-go"
+	"io"
+	"os"
+	"sync"
+)
+
+// trace_export.go writes analysis results in the Chrome Trace Event format,
+// which can be opened in chrome://tracing or the Perfetto UI. Each source file
+// is a process, and each kind of result gets its own track (thread).
+//
+// Format reference:
+// https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU
+
+// ChromeTraceEvent is a single event in the Chrome Trace Event format.
+// Timestamps and durations are in microseconds.
+type ChromeTraceEvent struct {
+	Name  string                 `json:"name"`
+	Cat   string                 `json:"cat,omitempty"`
+	Phase string                 `json:"ph"`
+	Ts    float64                `json:"ts"`
+	Dur   float64                `json:"dur,omitempty"`
+	Pid   int                    `json:"pid"`
+	Tid   int                    `json:"tid"`
+	Scope string                 `json:"s,omitempty"`
+	Args  map[string]interface{} `json:"args,omitempty"`
+}
+
+// Trace event phases
+const (
+	tracePhaseComplete = "X"
+	tracePhaseBegin    = "B"
+	tracePhaseEnd      = "E"
+	tracePhaseInstant  = "i"
+	tracePhaseCounter  = "C"
+	tracePhaseMetadata = "M"
+)
+
+// Tracks (trace threads)
+const (
+	TraceTrackInput = iota + 1
+	TraceTrackStateMachine
+	TraceTrackLocalResponse
+	TraceTrackGlobalResponse
+	TraceTrackJank
+	TraceTrackSpinners
+	TraceTrackFrames
+	TraceTrackDataGaps
+)
+
+var traceTrackNames = map[int]string{
+	TraceTrackInput:          "Input",
+	TraceTrackStateMachine:   "Input state machine",
+	TraceTrackLocalResponse:  "Local response",
+	TraceTrackGlobalResponse: "Global response",
+	TraceTrackJank:           "Jank",
+	TraceTrackSpinners:       "Spinners",
+	TraceTrackFrames:         "Frame refreshes",
+	TraceTrackDataGaps:       "Data gaps",
+}
+
+var finishTypeNames = map[int]string{
+	TapEventFinishTimeout:      "timeout",
+	TapEventFinishShortCircuit: "short_circuit",
+	TapEventFinishStable:       "stable",
+}
+
+func nsToUs(ns int64) float64 {
+	return float64(ns) / 1000.0
+}
+
+func msToUs(ms int64) float64 {
+	return float64(ms) * 1000.0
+}
+
+// ChromeTraceWriter streams trace events to a writer. The enclosing JSON
+// object is finished by Close.
+type ChromeTraceWriter struct {
+	w     *bufio.Writer
+	out   io.WriteCloser
+	count int
+
+	// Process ids, by source
+	pids map[string]int
+}
+
+func NewChromeTraceWriter(out io.WriteCloser) *ChromeTraceWriter {
+	return &ChromeTraceWriter{
+		w:    bufio.NewWriter(out),
+		out:  out,
+		pids: make(map[string]int),
+	}
+}
+
+func (tw *ChromeTraceWriter) writeEvent(event *ChromeTraceEvent) error {
+	if tw.count == 0 {
+		if _, err := tw.w.WriteString("{\"traceEvents\":[\n"); err != nil {
+			return err
+		}
+	} else if _, err := tw.w.WriteString(",\n"); err != nil {
+		return err
+	}
+
+	bytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	tw.count += 1
+	_, err = tw.w.Write(bytes)
+	return err
+}
+
+// Get the process id for a source, writing the process and track names the
+// first time we see it.
+func (tw *ChromeTraceWriter) pidFor(source string) (int, error) {
+	if pid, ok := tw.pids[source]; ok {
+		return pid, nil
+	}
+
+	pid := len(tw.pids) + 1
+	tw.pids[source] = pid
+
+	name := source
+	if len(name) == 0 {
+		name = "session"
+	}
+
+	if err := tw.writeEvent(&ChromeTraceEvent{
+		Name:  "process_name",
+		Phase: tracePhaseMetadata,
+		Pid:   pid,
+		Args:  map[string]interface{}{"name": name},
+	}); err != nil {
+		return pid, err
+	}
+
+	for tid := TraceTrackInput; tid <= TraceTrackDataGaps; tid++ {
+		if err := tw.writeEvent(&ChromeTraceEvent{
+			Name:  "thread_name",
+			Phase: tracePhaseMetadata,
+			Pid:   pid,
+			Tid:   tid,
+			Args:  map[string]interface{}{"name": traceTrackNames[tid]},
+		}); err != nil {
+			return pid, err
+		}
+	}
+
+	return pid, nil
+}
+
+// Write converts a result to trace events and writes them. Unknown types are
+// ignored.
+func (tw *ChromeTraceWriter) Write(data interface{}, source string) error {
+	events := ChromeTraceEventsFor(data)
+	if len(events) == 0 {
+		return nil
+	}
+
+	pid, err := tw.pidFor(source)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		event.Pid = pid
+		if err := tw.writeEvent(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tw *ChromeTraceWriter) Close() error {
+	var err error
+	if tw.count == 0 {
+		_, err = tw.w.WriteString("{\"traceEvents\":[")
+	}
+	if err == nil {
+		_, err = tw.w.WriteString("\n],\"displayTimeUnit\":\"ms\"}\n")
+	}
+	if err == nil {
+		err = tw.w.Flush()
+	}
+	if closeErr := tw.out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// ChromeTraceEventsFor converts a single analysis result into trace events.
+// The pids are left unset.
+func ChromeTraceEventsFor(data interface{}) []*ChromeTraceEvent {
+	switch t := data.(type) {
+	case *TouchScreenEvent:
+		return touchTraceEvents(t)
+	case *InputEventResult:
+		return inputResultTraceEvents(t)
+	case *Spinner:
+		return []*ChromeTraceEvent{
+			&ChromeTraceEvent{
+				Name:  "spinner",
+				Cat:   "spinner",
+				Phase: tracePhaseComplete,
+				Ts:    msToUs(t.StartTimeMs),
+				Dur:   msToUs(t.DurationMs),
+				Tid:   TraceTrackSpinners,
+				Args: map[string]interface{}{
+					"duration_ms": t.DurationMs,
+					"incomplete":  t.Incomplete,
+				},
+			},
+		}
+	case *FrameRefreshEvent:
+		return []*ChromeTraceEvent{
+			&ChromeTraceEvent{
+				Name:  "frame",
+				Cat:   "frame",
+				Phase: tracePhaseInstant,
+				Ts:    nsToUs(t.SysTimeNs),
+				Tid:   TraceTrackFrames,
+				Scope: "t",
+			},
+		}
+	case *FrameDiffSample:
+		return []*ChromeTraceEvent{
+			&ChromeTraceEvent{
+				Name:  "frame_diff",
+				Cat:   "frame_diff",
+				Phase: tracePhaseCounter,
+				Ts:    msToUs(t.Timestamp),
+				Args: map[string]interface{}{
+					"pct_diff": t.PctDiff,
+					"regions":  len(t.GridEntries),
+				},
+			},
+		}
+	case *DataGap:
+		return []*ChromeTraceEvent{
+			&ChromeTraceEvent{
+				Name:  "gap: " + t.Stream,
+				Cat:   "gap",
+				Phase: tracePhaseComplete,
+				Ts:    nsToUs(t.StartNs),
+				Dur:   nsToUs(t.EndNs - t.StartNs),
+				Tid:   TraceTrackDataGaps,
+				Args: map[string]interface{}{
+					"missing_tokens": t.MissingTokens(),
+				},
+			},
+		}
+	case *AppJankEvent:
+		return []*ChromeTraceEvent{
+			&ChromeTraceEvent{
+				Name:  "app jank: " + t.Source,
+				Cat:   "jank",
+				Phase: tracePhaseComplete,
+				Ts:    nsToUs(t.StartNs),
+				Dur:   nsToUs(t.EndNs - t.StartNs),
+				Tid:   TraceTrackJank,
+				Args: map[string]interface{}{
+					"pid":            t.Pid,
+					"skipped_frames": t.SkippedFrames,
+				},
+			},
+		}
+	}
+	return nil
+}
+
+func touchTraceEvents(event *TouchScreenEvent) []*ChromeTraceEvent {
+	args := map[string]interface{}{
+		"x": event.X,
+		"y": event.Y,
+	}
+
+	res := &ChromeTraceEvent{
+		Name: TouchScreenEventName(event.What),
+		Cat:  "input",
+		Ts:   nsToUs(event.Timestamp),
+		Tid:  TraceTrackInput,
+		Args: args,
+	}
+
+	switch event.What {
+	case TouchScreenEventScroll:
+		// Too many of these to be useful
+		return nil
+	case TouchScreenEventScrollStart:
+		res.Name = "scroll"
+		res.Phase = tracePhaseBegin
+	case TouchScreenEventScrollEnd:
+		res.Name = "scroll"
+		res.Phase = tracePhaseEnd
+	case TouchScreenEventKey:
+		res.Phase = tracePhaseInstant
+		res.Scope = "t"
+		res.Args = map[string]interface{}{"code": event.Code}
+	default:
+		res.Phase = tracePhaseInstant
+		res.Scope = "t"
+	}
+
+	return []*ChromeTraceEvent{res}
+}
+
+func inputResultTraceEvents(res *InputEventResult) []*ChromeTraceEvent {
+	name := TouchScreenEventName(res.EventType)
+
+	events := []*ChromeTraceEvent{
+		&ChromeTraceEvent{
+			Name:  name,
+			Cat:   "ism",
+			Phase: tracePhaseComplete,
+			Ts:    nsToUs(res.TimestampNs),
+			Dur:   nsToUs(res.FinishNs - res.TimestampNs),
+			Tid:   TraceTrackStateMachine,
+			Args: map[string]interface{}{
+				"finish":             finishTypeNames[res.FinishType],
+				"touch_response_ms":  res.TouchResponseMs(),
+				"global_response_ms": res.GlobalResponseMs(),
+				"num_jank":           len(res.Jank),
+				"incomplete":         res.Incomplete,
+			},
+		},
+	}
+
+	if res.HasLocalResponse() {
+		events = append(events, responseTraceEvent("local response", res.LocalResponse,
+			TraceTrackLocalResponse, res.TimestampNs))
+	}
+
+	if res.HasGlobalResponse() {
+		events = append(events, responseTraceEvent("global response", res.GlobalResponse,
+			TraceTrackGlobalResponse, res.TimestampNs))
+	}
+
+	for _, jank := range res.Jank {
+		event := &ChromeTraceEvent{
+			Name:  "jank",
+			Cat:   "jank",
+			Phase: tracePhaseComplete,
+			Ts:    nsToUs(jank.TimestampNs) - msToUs(jank.JankAmount),
+			Dur:   msToUs(jank.JankAmount),
+			Tid:   TraceTrackJank,
+			Args: map[string]interface{}{
+				"jank_ms":       jank.JankAmount,
+				"missed_vsyncs": jank.MissedVsyncs,
+				"class":         jank.Class,
+			},
+		}
+		if len(jank.Side) > 0 {
+			event.Args["side"] = jank.Side
+		}
+		events = append(events, event)
+	}
+
+	return events
+}
+
+func responseTraceEvent(name string, response *ResponseDetail, tid int, inputNs int64) *ChromeTraceEvent {
+	dur := 0.0
+	if response.EndNs != InvalidResponseTime {
+		dur = nsToUs(response.EndNs - response.StartNs)
+	}
+
+	return &ChromeTraceEvent{
+		Name:  name,
+		Cat:   "ism",
+		Phase: tracePhaseComplete,
+		Ts:    nsToUs(response.StartNs),
+		Dur:   dur,
+		Tid:   tid,
+		Args: map[string]interface{}{
+			"delay_ms":    (response.StartNs - inputNs) / nsPerMs,
+			"duration_ms": response.DurationMs(),
+		},
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ChromeTraceCollector is a DataCollector that writes everything it gets to
+// a Chrome trace file, one process per source file.
+type ChromeTraceCollector struct {
+	Filename string
+	Gzip     bool
+
+	writer *ChromeTraceWriter
+	err    error
+	sync.Mutex
+}
+
+func NewChromeTraceCollector(kwargs map[string]interface{}) *ChromeTraceCollector {
+	c := &ChromeTraceCollector{}
+
+	if v, ok := kwargs["filename"]; ok {
+		c.Filename, _ = v.(string)
+	}
+
+	if v, ok := kwargs["gzip"]; ok {
+		c.Gzip, _ = v.(bool)
+	}
+
+	return c
+}
+
+func (c *ChromeTraceCollector) open() error {
+	var out io.WriteCloser
+
+	if len(c.Filename) == 0 {
+		out = nopWriteCloser{os.Stdout}
+	} else {
+		name := qualifiedFileName(c.Filename, c.Gzip)
+		file, err := os.Create(name)
+		if err != nil {
+			return fmt.Errorf("Error creating trace file: %v", err)
+		}
+		out = file
+	}
+
+	if c.Gzip {
+		out = newGzipWriteCloser(out)
+	}
+
+	c.writer = NewChromeTraceWriter(out)
+	return nil
+}
+
+func (c *ChromeTraceCollector) OnData(data interface{}, info phonelab.PipelineSourceInfo) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.err != nil {
+		return
+	}
+
+	if c.writer == nil {
+		if c.err = c.open(); c.err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", c.err)
+			return
+		}
+	}
+
+	source := ""
+	if info != nil {
+		source = info.Context()
+	}
+
+	if c.err = c.writer.Write(data, source); c.err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing trace: %v\n", c.err)
+	}
+}
+
+func (c *ChromeTraceCollector) Finish() {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.writer == nil && c.err == nil {
+		// Still write an empty trace
+		if c.err = c.open(); c.err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", c.err)
+			return
+		}
+	}
+
+	if c.writer != nil {
+		if err := c.writer.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error closing trace: %v\n", err)
+		}
+		c.writer = nil
+	}
+}
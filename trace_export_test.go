@@ -0,0 +1,146 @@
+package libphonelabgo
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type chromeTraceFile struct {
+	TraceEvents []*ChromeTraceEvent `json:"traceEvents"`
+}
+
+func TestChromeTraceEvents(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	res := &InputEventResult{
+		TimestampNs: 1000000000,
+		FinishNs:    1500000000,
+		FinishType:  TapEventFinishShortCircuit,
+		EventType:   TouchScreenEventTap,
+		LocalResponse: &ResponseDetail{
+			StartNs: 1050000000,
+			EndNs:   1200000000,
+		},
+		GlobalResponse: NewResponseDetail(),
+		Jank: []*JankEvent{
+			&JankEvent{TimestampNs: 1150000000, JankAmount: 50},
+		},
+	}
+
+	events := ChromeTraceEventsFor(res)
+	assert.Equal(3, len(events))
+
+	assert.Equal("tap", events[0].Name)
+	assert.Equal(tracePhaseComplete, events[0].Phase)
+	assert.Equal(TraceTrackStateMachine, events[0].Tid)
+	assert.Equal(1000000.0, events[0].Ts)
+	assert.Equal(500000.0, events[0].Dur)
+	assert.Equal("short_circuit", events[0].Args["finish"])
+
+	assert.Equal(TraceTrackLocalResponse, events[1].Tid)
+	assert.Equal(1050000.0, events[1].Ts)
+	assert.Equal(150000.0, events[1].Dur)
+	assert.Equal(int64(50), events[1].Args["delay_ms"])
+
+	assert.Equal(TraceTrackJank, events[2].Tid)
+	assert.Equal(1100000.0, events[2].Ts)
+	assert.Equal(50000.0, events[2].Dur)
+
+	// Scroll gestures are begin/end pairs, intermediate events are dropped
+	start := ChromeTraceEventsFor(&TouchScreenEvent{What: TouchScreenEventScrollStart, Timestamp: 2000})
+	assert.Equal(tracePhaseBegin, start[0].Phase)
+	assert.Equal(0, len(ChromeTraceEventsFor(&TouchScreenEvent{What: TouchScreenEventScroll})))
+	end := ChromeTraceEventsFor(&TouchScreenEvent{What: TouchScreenEventScrollEnd, Timestamp: 4000})
+	assert.Equal(tracePhaseEnd, end[0].Phase)
+	assert.Equal(start[0].Name, end[0].Name)
+
+	diff := ChromeTraceEventsFor(&FrameDiffSample{SFFrameDiff: SFFrameDiff{Timestamp: 10, PctDiff: 0.25}})
+	assert.Equal(tracePhaseCounter, diff[0].Phase)
+	assert.Equal(10000.0, diff[0].Ts)
+	assert.Equal(0.25, diff[0].Args["pct_diff"])
+
+	assert.Nil(ChromeTraceEventsFor("something else"))
+}
+
+func TestChromeTraceCollector(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "chrometrace")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "trace.json")
+	c := NewChromeTraceCollector(map[string]interface{}{
+		"filename": filename,
+	})
+
+	a := &testSourceInfo{"a.log"}
+	b := &testSourceInfo{"b.log"}
+
+	c.OnData(&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 1000}, a)
+	c.OnData(&Spinner{StartTimeMs: 1, EndTimeMs: 3, DurationMs: 2}, b)
+	c.OnData(&FrameRefreshEvent{SysTimeNs: 5000}, a)
+	c.OnData("ignored", a)
+	c.Finish()
+
+	bytes, err := ioutil.ReadFile(filename)
+	require.Nil(err)
+
+	var trace chromeTraceFile
+	require.Nil(json.Unmarshal(bytes, &trace))
+
+	pids := make(map[string]int)
+	data := make([]*ChromeTraceEvent, 0)
+	for _, event := range trace.TraceEvents {
+		if event.Phase == tracePhaseMetadata {
+			if event.Name == "process_name" {
+				pids[event.Args["name"].(string)] = event.Pid
+			}
+		} else {
+			data = append(data, event)
+		}
+	}
+
+	require.Equal(2, len(pids))
+	require.Equal(3, len(data))
+	assert.NotEqual(pids["a.log"], pids["b.log"])
+
+	assert.Equal(pids["a.log"], data[0].Pid)
+	assert.Equal(TraceTrackInput, data[0].Tid)
+	assert.Equal(pids["b.log"], data[1].Pid)
+	assert.Equal(TraceTrackSpinners, data[1].Tid)
+	assert.Equal(1000.0, data[1].Ts)
+	assert.Equal(2000.0, data[1].Dur)
+	assert.Equal(pids["a.log"], data[2].Pid)
+	assert.Equal(TraceTrackFrames, data[2].Tid)
+}
+
+func TestChromeTraceEmpty(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "chrometrace")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "trace.json")
+	c := NewChromeTraceCollector(map[string]interface{}{
+		"filename": filename,
+	})
+	c.Finish()
+
+	bytes, err := ioutil.ReadFile(filename)
+	require.Nil(err)
+
+	var trace chromeTraceFile
+	require.Nil(json.Unmarshal(bytes, &trace))
+	require.Equal(0, len(trace.TraceEvents))
+}
@@ -0,0 +1,283 @@
+package libphonelabgo
+
+import (
+	phonelab "github.com/shaseley/phonelab-go"
+)
+
+// typing.go measures typing latency on the soft keyboard. While the IME is
+// shown, taps over the keyboard rows at the bottom of the screen are
+// keystrokes, not ordinary taps, so KeystrokeClassifier relabels them before
+// they reach the input state machine. TypingProcessor then groups the
+// keystroke results into typing bursts and summarizes their response times
+// separately from other taps.
+
+// KeystrokeClassifier turns taps on the soft keyboard into
+// TouchScreenEventKeystroke events. Everything else is passed through.
+type KeystrokeClassifier struct {
+	Source phonelab.Processor
+	// Number of grid rows, from the bottom, covered by the keyboard.
+	KeyboardRows int
+}
+
+// Is y within the bottom rows of the screen?
+func inKeyboardRegion(y float64, rows int) bool {
+	props := allScreenGrids[0]
+	if rows <= 0 || rows > props.rows {
+		rows = props.rows
+	}
+	return y >= float64(props.screenH)-float64(rows)*props.pixelsPerWH
+}
+
+func (proc *KeystrokeClassifier) Process() <-chan interface{} {
+	outChan := make(chan interface{})
+
+	go func() {
+		inChan := proc.Source.Process()
+		keyboardShown := false
+
+		for iLog := range inChan {
+			switch t := iLog.(type) {
+			case *KeyboardEvent:
+				keyboardShown = t.What == KeyboardEventShow
+			case *TouchScreenEvent:
+				if keyboardShown && t.What == TouchScreenEventTap &&
+					inKeyboardRegion(t.Y, proc.KeyboardRows) {
+
+					// Don't modify the original, other branches may have it
+					keystroke := *t
+					keystroke.What = TouchScreenEventKeystroke
+					iLog = &keystroke
+				}
+			}
+			outChan <- iLog
+		}
+		close(outChan)
+	}()
+
+	return outChan
+}
+
+type KeystrokeClassifierGenerator struct{}
+
+func (g *KeystrokeClassifierGenerator) GenerateProcessor(source *phonelab.PipelineSourceInstance,
+	kwargs map[string]interface{}) phonelab.Processor {
+
+	rows := DefaultKeyboardLatencyParams().KeyboardRows
+	if v, ok := kwargs["keyboard_rows"]; ok {
+		rows = v.(int)
+	}
+
+	return &KeystrokeClassifier{
+		Source:       source.Processor,
+		KeyboardRows: rows,
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Keystrokes closer together than this are part of the same burst.
+const DefaultTypingBurstGapMs = 1000
+
+// TypingBurst is a run of keystrokes without a long pause.
+type TypingBurst struct {
+	StartNs        int64   `json:"start_ns"`
+	EndNs          int64   `json:"end_ns"`
+	Keystrokes     int     `json:"keystrokes"`
+	KeysPerMin     float64 `json:"keys_per_min"`
+	MeanResponseMs float64 `json:"mean_response_ms"`
+	MaxResponseMs  int64   `json:"max_response_ms"`
+	// Keystrokes without a local response
+	NoResponse int `json:"no_response"`
+
+	responseSumMs int64
+	responses     int
+}
+
+func (burst *TypingBurst) add(res *InputEventResult) {
+	if burst.Keystrokes == 0 {
+		burst.StartNs = res.TimestampNs
+	}
+	burst.EndNs = res.TimestampNs
+	burst.Keystrokes += 1
+
+	if res.HasLocalResponse() {
+		ms := res.TouchResponseMs()
+		burst.responseSumMs += ms
+		burst.responses += 1
+		if ms > burst.MaxResponseMs {
+			burst.MaxResponseMs = ms
+		}
+	} else {
+		burst.NoResponse += 1
+	}
+}
+
+func (burst *TypingBurst) finish() {
+	if burst.responses > 0 {
+		burst.MeanResponseMs = float64(burst.responseSumMs) / float64(burst.responses)
+	}
+	// A single keystroke has no rate
+	if burst.Keystrokes > 1 && burst.EndNs > burst.StartNs {
+		burst.KeysPerMin = float64(burst.Keystrokes-1) * 60.0 * nsPerSecF / float64(burst.EndNs-burst.StartNs)
+	}
+}
+
+// TypingStats summarizes all keystrokes seen by a TypingAnalyzer.
+type TypingStats struct {
+	Keystrokes int64 `json:"keystrokes"`
+	NoResponse int64 `json:"no_response"`
+	Bursts     int64 `json:"bursts"`
+
+	// Keystroke to local response (the key highlight)
+	ResponseMs *MetricSummary `json:"response_ms"`
+	// Time between keystrokes in the same burst
+	InterKeyMs *MetricSummary `json:"inter_key_ms"`
+	// Typing speed of bursts with more than one keystroke
+	KeysPerMin *MetricSummary `json:"keys_per_min"`
+}
+
+// TypingAnalyzer groups keystroke results into bursts. Results for other
+// event types are ignored.
+type TypingAnalyzer struct {
+	BurstGapMs int64
+
+	burst      *TypingBurst
+	lastNs     int64
+	keystrokes int64
+	noResponse int64
+	bursts     int64
+	responseMs *QuantileSketch
+	interKeyMs *QuantileSketch
+	keysPerMin *QuantileSketch
+}
+
+func NewTypingAnalyzer(burstGapMs int64) *TypingAnalyzer {
+	return &TypingAnalyzer{
+		BurstGapMs: burstGapMs,
+		responseMs: NewQuantileSketch(DefaultSketchAccuracy),
+		interKeyMs: NewQuantileSketch(DefaultSketchAccuracy),
+		keysPerMin: NewQuantileSketch(DefaultSketchAccuracy),
+	}
+}
+
+func (ta *TypingAnalyzer) finishBurst() *TypingBurst {
+	burst := ta.burst
+	if burst == nil {
+		return nil
+	}
+	ta.burst = nil
+
+	burst.finish()
+	ta.bursts += 1
+	if burst.KeysPerMin > 0 {
+		ta.keysPerMin.Add(burst.KeysPerMin)
+	}
+	return burst
+}
+
+// Add a result. Returns the previous burst if this keystroke starts a new
+// one.
+func (ta *TypingAnalyzer) OnResult(res *InputEventResult) *TypingBurst {
+	if res.EventType != TouchScreenEventKeystroke {
+		return nil
+	}
+
+	var done *TypingBurst
+	if ta.burst != nil {
+		gapNs := res.TimestampNs - ta.lastNs
+		if gapNs > ta.BurstGapMs*nsPerMs {
+			done = ta.finishBurst()
+		} else {
+			ta.interKeyMs.Add(float64(gapNs) / nsPerMsF)
+		}
+	}
+
+	if ta.burst == nil {
+		ta.burst = &TypingBurst{}
+	}
+	ta.burst.add(res)
+	ta.lastNs = res.TimestampNs
+
+	ta.keystrokes += 1
+	if res.HasLocalResponse() {
+		ta.responseMs.Add(float64(res.TouchResponseMs()))
+	} else {
+		ta.noResponse += 1
+	}
+
+	return done
+}
+
+// Finish the last burst, if there is one.
+func (ta *TypingAnalyzer) Finish() *TypingBurst {
+	return ta.finishBurst()
+}
+
+func (ta *TypingAnalyzer) Stats() *TypingStats {
+	return &TypingStats{
+		Keystrokes: ta.keystrokes,
+		NoResponse: ta.noResponse,
+		Bursts:     ta.bursts,
+		ResponseMs: NewMetricSummary(ta.responseMs),
+		InterKeyMs: NewMetricSummary(ta.interKeyMs),
+		KeysPerMin: NewMetricSummary(ta.keysPerMin),
+	}
+}
+
+// TypingProcessor sends a TypingBurst for each burst of keystroke
+// InputEventResults, followed by the TypingStats at the end. Other results are
+// passed through if PassThrough is set.
+type TypingProcessor struct {
+	Source      phonelab.Processor
+	BurstGapMs  int64
+	PassThrough bool
+}
+
+func (proc *TypingProcessor) Process() <-chan interface{} {
+	outChan := make(chan interface{})
+
+	go func() {
+		inChan := proc.Source.Process()
+		ta := NewTypingAnalyzer(proc.BurstGapMs)
+
+		for iLog := range inChan {
+			if res, ok := iLog.(*InputEventResult); ok {
+				if burst := ta.OnResult(res); burst != nil {
+					outChan <- burst
+				}
+			}
+			if proc.PassThrough {
+				outChan <- iLog
+			}
+		}
+
+		if burst := ta.Finish(); burst != nil {
+			outChan <- burst
+		}
+		outChan <- ta.Stats()
+		close(outChan)
+	}()
+
+	return outChan
+}
+
+type TypingProcessorGenerator struct{}
+
+func (g *TypingProcessorGenerator) GenerateProcessor(source *phonelab.PipelineSourceInstance,
+	kwargs map[string]interface{}) phonelab.Processor {
+
+	proc := &TypingProcessor{
+		Source:     source.Processor,
+		BurstGapMs: DefaultTypingBurstGapMs,
+	}
+
+	if v, ok := kwargs["burst_gap_ms"]; ok {
+		proc.BurstGapMs = int64(v.(int))
+	}
+
+	if v, ok := kwargs["pass_through"]; ok {
+		proc.PassThrough = v.(bool)
+	}
+
+	return proc
+}
@@ -0,0 +1,108 @@
+package libphonelabgo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestKeystrokeClassifier(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tap := &TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 2000, X: 700, Y: 2400}
+
+	source := &sliceSource{[]interface{}{
+		// Keyboard not shown yet
+		&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 1000, X: 700, Y: 2400},
+		&KeyboardEvent{What: KeyboardEventShow, TimestampNs: 1500},
+		tap,
+		// Above the keyboard
+		&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 3000, X: 700, Y: 1000},
+		&TouchScreenEvent{What: TouchScreenEventScrollStart, Timestamp: 4000, X: 700, Y: 2400},
+		&KeyboardEvent{What: KeyboardEventHide, TimestampNs: 4500},
+		&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 5000, X: 700, Y: 2400},
+	}}
+
+	res := collectAll(&KeystrokeClassifier{
+		Source:       source,
+		KeyboardRows: 3,
+	})
+	require.Equal(7, len(res))
+
+	whats := make([]int, 0)
+	for _, r := range res {
+		if event, ok := r.(*TouchScreenEvent); ok {
+			whats = append(whats, event.What)
+		}
+	}
+	assert.Equal([]int{
+		TouchScreenEventTap,
+		TouchScreenEventKeystroke,
+		TouchScreenEventTap,
+		TouchScreenEventScrollStart,
+		TouchScreenEventTap,
+	}, whats)
+
+	// The original event is left alone
+	assert.Equal(TouchScreenEventTap, tap.What)
+	assert.Equal(700.0, res[2].(*TouchScreenEvent).X)
+}
+
+func typingTestResult(tsMs, responseMs int64) *InputEventResult {
+	res := NewInputEventResult(&TouchScreenEvent{
+		What:      TouchScreenEventKeystroke,
+		Timestamp: tsMs * nsPerMs,
+	})
+	if responseMs >= 0 {
+		res.LocalResponse.StartNs = (tsMs + responseMs) * nsPerMs
+		res.LocalResponse.EndNs = (tsMs + responseMs + 16) * nsPerMs
+	}
+	return res
+}
+
+func TestTypingProcessor(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	source := &sliceSource{[]interface{}{
+		typingTestResult(1000, 20),
+		typingTestResult(1200, 40),
+		// Ordinary taps don't count
+		NewInputEventResult(&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 1300 * nsPerMs}),
+		typingTestResult(1400, -1),
+		// Long pause, new burst
+		typingTestResult(5000, 30),
+	}}
+
+	res := collectAll(&TypingProcessor{
+		Source:     source,
+		BurstGapMs: DefaultTypingBurstGapMs,
+	})
+	require.Equal(3, len(res))
+
+	first := res[0].(*TypingBurst)
+	assert.Equal(3, first.Keystrokes)
+	assert.Equal(1, first.NoResponse)
+	assert.Equal(int64(1000*nsPerMs), first.StartNs)
+	assert.Equal(int64(1400*nsPerMs), first.EndNs)
+	assert.Equal(30.0, first.MeanResponseMs)
+	assert.Equal(int64(40), first.MaxResponseMs)
+	assert.InDelta(300.0, first.KeysPerMin, 0.001)
+
+	second := res[1].(*TypingBurst)
+	assert.Equal(1, second.Keystrokes)
+	assert.Equal(0.0, second.KeysPerMin)
+
+	stats := res[2].(*TypingStats)
+	assert.Equal(int64(4), stats.Keystrokes)
+	assert.Equal(int64(1), stats.NoResponse)
+	assert.Equal(int64(2), stats.Bursts)
+	assert.Equal(int64(3), stats.ResponseMs.Count)
+	assert.Equal(20.0, stats.ResponseMs.Min)
+	assert.Equal(40.0, stats.ResponseMs.Max)
+	assert.Equal(int64(2), stats.InterKeyMs.Count)
+	assert.Equal(int64(1), stats.KeysPerMin.Count)
+}
@@ -0,0 +1,250 @@
+package libphonelabgo
+
+import (
+	phonelab "github.com/shaseley/phonelab-go"
+	"sort"
+)
+
+// user_wait.go links spinners to the input that caused them. A spinner that
+// shortly follows a tap is time the user spent waiting; a spinner on an idle
+// screen may not be.
+
+const (
+	// Inputs more than this long before a spinner didn't cause it.
+	DefaultUserWaitWindowMs = 2000
+
+	// A diff at least this big after a spinner is the content showing up.
+	DefaultUserWaitContentMinDiff = 5.0
+)
+
+// UserWaitRecord is a spinner and the input that caused it, if any.
+type UserWaitRecord struct {
+	Spinner *Spinner          `json:"spinner"`
+	Input   *TouchScreenEvent `json:"input,omitempty"`
+	// Set if no input came shortly before the spinner
+	NoInput bool `json:"no_input,omitempty"`
+
+	// InvalidResponseTime if unknown
+	TapToSpinnerMs        int64 `json:"tap_to_spinner_ms"`
+	SpinnerDurationMs     int64 `json:"spinner_duration_ms"`
+	SpinnerEndToContentMs int64 `json:"spinner_end_to_content_ms"`
+	// From the input to the content, the whole wait
+	TotalWaitMs int64 `json:"total_wait_ms"`
+}
+
+func (rec *UserWaitRecord) MonotonicTimestamp() float64 {
+	return rec.Spinner.MonotonicTimestamp()
+}
+
+type UserWaitStats struct {
+	Spinners  int `json:"spinners"`
+	Triggered int `json:"triggered"`
+	NoInput   int `json:"no_input"`
+
+	TapToSpinnerMs        *MetricSummary `json:"tap_to_spinner_ms"`
+	SpinnerDurationMs     *MetricSummary `json:"spinner_duration_ms"`
+	SpinnerEndToContentMs *MetricSummary `json:"spinner_end_to_content_ms"`
+	TotalWaitMs           *MetricSummary `json:"total_wait_ms"`
+}
+
+// Inputs that can start a wait. Intermediate scroll events don't count.
+func isWaitTrigger(event *TouchScreenEvent) bool {
+	switch event.What {
+	case TouchScreenEventTap, TouchScreenEventKeystroke, TouchScreenEventKey,
+		TouchScreenEventScrollEnd:
+		return true
+	}
+	return false
+}
+
+// Is the diff big enough to be content showing up?
+func isWaitContent(diff *FrameDiffSample, contentMinDiff float64) bool {
+	return diff.PctDiff >= contentMinDiff
+}
+
+// AttributeSpinners pairs each spinner with the most recent trigger input
+// within windowMs before it starts, and finds when content showed up after
+// it ended: the first of contentMs, the times of diffs that were big enough
+// (see isWaitContent). Inputs and content times don't need to be sorted.
+func AttributeSpinners(spinners []*Spinner, inputs []*TouchScreenEvent,
+	contentMs []int64, windowMs int64) []*UserWaitRecord {
+
+	triggers := make([]*TouchScreenEvent, 0, len(inputs))
+	for _, event := range inputs {
+		if isWaitTrigger(event) {
+			triggers = append(triggers, event)
+		}
+	}
+	sort.SliceStable(triggers, func(i, j int) bool {
+		return triggers[i].Timestamp < triggers[j].Timestamp
+	})
+
+	content := make([]int64, len(contentMs))
+	copy(content, contentMs)
+	sort.Slice(content, func(i, j int) bool { return content[i] < content[j] })
+
+	records := make([]*UserWaitRecord, 0, len(spinners))
+
+	for _, s := range spinners {
+		rec := &UserWaitRecord{
+			Spinner:               s,
+			TapToSpinnerMs:        InvalidResponseTime,
+			SpinnerDurationMs:     s.EndTimeMs - s.StartTimeMs,
+			SpinnerEndToContentMs: InvalidResponseTime,
+			TotalWaitMs:           InvalidResponseTime,
+		}
+
+		// Last trigger at or before the spinner started
+		startNs := s.StartTimeMs * nsPerMs
+		i := sort.Search(len(triggers), func(i int) bool {
+			return triggers[i].Timestamp > startNs
+		})
+		if i > 0 && startNs-triggers[i-1].Timestamp <= windowMs*nsPerMs {
+			rec.Input = triggers[i-1]
+			rec.TapToSpinnerMs = (startNs - rec.Input.Timestamp) / nsPerMs
+		} else {
+			rec.NoInput = true
+		}
+
+		j := sort.Search(len(content), func(j int) bool {
+			return content[j] >= s.EndTimeMs
+		})
+		if j < len(content) {
+			rec.SpinnerEndToContentMs = content[j] - s.EndTimeMs
+		}
+
+		if rec.Input != nil {
+			endMs := s.EndTimeMs
+			if rec.SpinnerEndToContentMs != InvalidResponseTime {
+				endMs += rec.SpinnerEndToContentMs
+			}
+			rec.TotalWaitMs = endMs - rec.Input.Timestamp/nsPerMs
+		}
+
+		records = append(records, rec)
+	}
+
+	return records
+}
+
+// UserWaitAnalyzer summarizes user wait records.
+type UserWaitAnalyzer struct {
+	stats *UserWaitStats
+
+	tapToSpinnerMs        *QuantileSketch
+	spinnerDurationMs     *QuantileSketch
+	spinnerEndToContentMs *QuantileSketch
+	totalWaitMs           *QuantileSketch
+}
+
+func NewUserWaitAnalyzer() *UserWaitAnalyzer {
+	return &UserWaitAnalyzer{
+		stats:                 &UserWaitStats{},
+		tapToSpinnerMs:        NewQuantileSketch(DefaultSketchAccuracy),
+		spinnerDurationMs:     NewQuantileSketch(DefaultSketchAccuracy),
+		spinnerEndToContentMs: NewQuantileSketch(DefaultSketchAccuracy),
+		totalWaitMs:           NewQuantileSketch(DefaultSketchAccuracy),
+	}
+}
+
+func (a *UserWaitAnalyzer) OnRecord(rec *UserWaitRecord) {
+	a.stats.Spinners += 1
+	if rec.NoInput {
+		a.stats.NoInput += 1
+		return
+	}
+
+	// Only waits the user saw are summarized
+	a.stats.Triggered += 1
+	a.tapToSpinnerMs.Add(float64(rec.TapToSpinnerMs))
+	a.spinnerDurationMs.Add(float64(rec.SpinnerDurationMs))
+	if rec.SpinnerEndToContentMs != InvalidResponseTime {
+		a.spinnerEndToContentMs.Add(float64(rec.SpinnerEndToContentMs))
+	}
+	a.totalWaitMs.Add(float64(rec.TotalWaitMs))
+}
+
+func (a *UserWaitAnalyzer) Stats() *UserWaitStats {
+	a.stats.TapToSpinnerMs = NewMetricSummary(a.tapToSpinnerMs)
+	a.stats.SpinnerDurationMs = NewMetricSummary(a.spinnerDurationMs)
+	a.stats.SpinnerEndToContentMs = NewMetricSummary(a.spinnerEndToContentMs)
+	a.stats.TotalWaitMs = NewMetricSummary(a.totalWaitMs)
+	return a.stats
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// UserWaitProcessor joins Spinners with TouchScreenEvents and
+// FrameDiffSamples from the same source. Inputs can arrive in any order, so
+// everything is held until the end, then a UserWaitRecord is sent for each
+// spinner, followed by the UserWaitStats. Only the times of content diffs
+// are kept, since there's one diff per frame.
+type UserWaitProcessor struct {
+	Source         phonelab.Processor
+	WindowMs       int64
+	ContentMinDiff float64
+}
+
+func (proc *UserWaitProcessor) Process() <-chan interface{} {
+	outChan := make(chan interface{})
+
+	go func() {
+		inChan := proc.Source.Process()
+
+		spinners := make([]*Spinner, 0)
+		inputs := make([]*TouchScreenEvent, 0)
+		contentMs := make([]int64, 0)
+
+		for iLog := range inChan {
+			switch t := iLog.(type) {
+			case *Spinner:
+				spinners = append(spinners, t)
+			case *TouchScreenEvent:
+				inputs = append(inputs, t)
+			case *FrameDiffSample:
+				if isWaitContent(t, proc.ContentMinDiff) {
+					contentMs = append(contentMs, t.Timestamp)
+				}
+			}
+		}
+
+		analyzer := NewUserWaitAnalyzer()
+		for _, rec := range AttributeSpinners(spinners, inputs, contentMs, proc.WindowMs) {
+
+			analyzer.OnRecord(rec)
+			outChan <- rec
+		}
+		outChan <- analyzer.Stats()
+
+		close(outChan)
+	}()
+
+	return outChan
+}
+
+type UserWaitProcessorGenerator struct{}
+
+func (g *UserWaitProcessorGenerator) GenerateProcessor(source *phonelab.PipelineSourceInstance,
+	kwargs map[string]interface{}) phonelab.Processor {
+
+	proc := &UserWaitProcessor{
+		Source:         source.Processor,
+		WindowMs:       DefaultUserWaitWindowMs,
+		ContentMinDiff: DefaultUserWaitContentMinDiff,
+	}
+
+	if v, ok := kwargs["window_ms"]; ok {
+		proc.WindowMs = int64(v.(int))
+	}
+
+	if v, ok := kwargs["content_min_diff"]; ok {
+		switch t := v.(type) {
+		case int:
+			proc.ContentMinDiff = float64(t)
+		case float64:
+			proc.ContentMinDiff = t
+		}
+	}
+
+	return proc
+}
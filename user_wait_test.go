@@ -0,0 +1,95 @@
+package libphonelabgo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestAttributeSpinners(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	inputs := []*TouchScreenEvent{
+		&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 1000 * nsPerMs},
+		// Scrolling doesn't count until it ends
+		&TouchScreenEvent{What: TouchScreenEventScrollStart, Timestamp: 1100 * nsPerMs},
+		&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 900 * nsPerMs},
+	}
+	spinners := []*Spinner{
+		&Spinner{StartTimeMs: 1200, EndTimeMs: 3000},
+		// Long after the tap
+		&Spinner{StartTimeMs: 8000, EndTimeMs: 9000},
+	}
+	contentMs := []int64{3100}
+
+	records := AttributeSpinners(spinners, inputs, contentMs, DefaultUserWaitWindowMs)
+	require.Equal(2, len(records))
+
+	rec := records[0]
+	require.NotNil(rec.Input)
+	assert.Equal(int64(1000*nsPerMs), rec.Input.Timestamp)
+	assert.False(rec.NoInput)
+	assert.Equal(int64(200), rec.TapToSpinnerMs)
+	assert.Equal(int64(1800), rec.SpinnerDurationMs)
+	assert.Equal(int64(100), rec.SpinnerEndToContentMs)
+	assert.Equal(int64(2100), rec.TotalWaitMs)
+
+	rec = records[1]
+	assert.Nil(rec.Input)
+	assert.True(rec.NoInput)
+	assert.Equal(int64(InvalidResponseTime), rec.TapToSpinnerMs)
+	assert.Equal(int64(InvalidResponseTime), rec.SpinnerEndToContentMs)
+	assert.Equal(int64(InvalidResponseTime), rec.TotalWaitMs)
+}
+
+func TestUserWaitProcessor(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	res := collectAll(&UserWaitProcessor{
+		Source: &sliceSource{[]interface{}{
+			&Spinner{StartTimeMs: 1200, EndTimeMs: 3000},
+			&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 1000 * nsPerMs},
+			&Spinner{StartTimeMs: 8000, EndTimeMs: 9000},
+		}},
+		WindowMs:       DefaultUserWaitWindowMs,
+		ContentMinDiff: DefaultUserWaitContentMinDiff,
+	})
+	require.Equal(3, len(res))
+
+	assert.False(res[0].(*UserWaitRecord).NoInput)
+	assert.True(res[1].(*UserWaitRecord).NoInput)
+
+	stats := res[2].(*UserWaitStats)
+	assert.Equal(2, stats.Spinners)
+	assert.Equal(1, stats.Triggered)
+	assert.Equal(1, stats.NoInput)
+	assert.Equal(int64(1), stats.TapToSpinnerMs.Count)
+	assert.Equal(int64(0), stats.SpinnerEndToContentMs.Count)
+}
+
+func TestUserWaitProcessorContent(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// The first diff is too small to be content
+	res := collectAll(&UserWaitProcessor{
+		Source: &sliceSource{[]interface{}{
+			&TouchScreenEvent{What: TouchScreenEventTap, Timestamp: 1000 * nsPerMs},
+			&Spinner{StartTimeMs: 1200, EndTimeMs: 3000},
+			heatmapTestDiff(3000, &GridEntry{10, 36.0}),
+			heatmapTestDiff(3100, &GridEntry{10, 360.0}),
+		}},
+		WindowMs:       DefaultUserWaitWindowMs,
+		ContentMinDiff: DefaultUserWaitContentMinDiff,
+	})
+	require.Equal(2, len(res))
+
+	rec := res[0].(*UserWaitRecord)
+	assert.Equal(int64(100), rec.SpinnerEndToContentMs)
+	assert.Equal(int64(2100), rec.TotalWaitMs)
+}